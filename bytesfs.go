@@ -0,0 +1,96 @@
+package fontfind
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"time"
+
+	"golang.org/x/image/font"
+)
+
+// LoadFromBytes creates a scalable font for font data held in memory, e.g. for fonts
+// embedded into tests or read from a database. name is used as the font's name and
+// path. data must not be modified afterwards, as it is not copied.
+//
+// The font's Source is SourceUnknown, as its origin is up to the client.
+func LoadFromBytes(name string, data []byte, style font.Style, weight font.Weight) ScalableFont {
+	f := ScalableFont{
+		Name:   name,
+		Style:  style,
+		Weight: weight,
+	}
+	p := path.Base(name)
+	if !fs.ValidPath(p) || p == "." {
+		p = "font"
+	}
+	f.SetFS(&bytesFS{name: p, data: data}, p)
+	return f
+}
+
+// Retain reads the font data of f into memory, so that subsequent reads, e.g. by
+// ReadFontData or Sfnt, do not touch the file-system again. This pays off for fonts
+// read repeatedly, e.g. by a server. File and Path of f are kept. Retaining the data
+// of a font held in memory already does nothing.
+//
+// Close drops the data again, from f only: copies of f made after Retain share the
+// data and keep it until they are closed as well. A resolver pipeline stores a copy
+// without the retained data in its registry (see locate.ResolverPipeline).
+func (f *ScalableFont) Retain() error {
+	if _, ok := f.fileSystem.(*bytesFS); ok {
+		return nil
+	}
+	data, err := f.ReadFontData()
+	if err != nil {
+		return err
+	}
+	f.fileSystem = &bytesFS{name: f.path, data: data, origin: f.fileSystem}
+	return nil
+}
+
+// Close drops font data retained by Retain, so that f reads its data from its
+// original file-system again. It does nothing for other fonts, including fonts
+// created by LoadFromBytes, and never fails. Close implements io.Closer.
+func (f *ScalableFont) Close() error {
+	if fsys, ok := f.fileSystem.(*bytesFS); ok && fsys.origin != nil {
+		f.fileSystem = fsys.origin
+	}
+	return nil
+}
+
+// bytesFS is a file-system containing a single file. It is used as a pointer, which
+// keeps ScalableFont comparable and lets the cache of parsed fonts tell different
+// byte slices apart.
+type bytesFS struct {
+	name   string
+	data   []byte
+	origin fs.FS // file-system the data has been read from, see Retain
+}
+
+func (fsys *bytesFS) Open(name string) (fs.File, error) {
+	if name != fsys.name {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &bytesFile{Reader: bytes.NewReader(fsys.data), fsys: fsys}, nil
+}
+
+// bytesFile is an open file of a bytesFS.
+type bytesFile struct {
+	*bytes.Reader
+	fsys *bytesFS
+}
+
+func (f *bytesFile) Stat() (fs.FileInfo, error) { return bytesFileInfo{f.fsys}, nil }
+func (f *bytesFile) Close() error               { return nil }
+
+// bytesFileInfo describes the single file of a bytesFS.
+type bytesFileInfo struct {
+	fsys *bytesFS
+}
+
+func (fi bytesFileInfo) Name() string       { return fi.fsys.name }
+func (fi bytesFileInfo) Size() int64        { return int64(len(fi.fsys.data)) }
+func (fi bytesFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi bytesFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi bytesFileInfo) IsDir() bool        { return false }
+func (fi bytesFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,33 @@
+package fontfind
+
+import "golang.org/x/image/font/sfnt"
+
+// Covers checks if font f has glyphs for runes. It returns the runes without a
+// glyph, in order of first occurrence, or nil if f covers all of them.
+//
+// The font is parsed with Sfnt, so repeated coverage checks are cheap as long as the
+// parsed font is cached. Fonts in file-systems which are not comparable, e.g.
+// fstest.MapFS, are parsed on every call, as are all fonts if the cache of parsed
+// fonts is disabled (see SetParsedFontCacheSize).
+func (f *ScalableFont) Covers(runes []rune) (missing []rune, err error) {
+	sf, err := f.Sfnt()
+	if err != nil {
+		return nil, err
+	}
+	var buf sfnt.Buffer
+	seen := make(map[rune]bool, len(runes))
+	for _, r := range runes {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		gid, err := sf.GlyphIndex(&buf, r)
+		if err != nil {
+			return nil, err
+		}
+		if gid == 0 {
+			missing = append(missing, r)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,181 @@
+package fontfind
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+)
+
+// ErrInvalidDescriptor is wrapped by the errors of Descriptor.Validate.
+var ErrInvalidDescriptor = errors.New("invalid font descriptor")
+
+// categories are the design categories accepted for Descriptor.Category, with
+// "sans" as a short form of "sans-serif".
+var categories = map[string]bool{
+	"serif": true, "sans-serif": true, "sans": true, "display": true,
+	"handwriting": true, "monospace": true,
+}
+
+// Validate checks desc for values no font could match, e.g. an empty pattern or
+// a weight outside of the CSS range of 100…900, and returns an error wrapping
+// ErrInvalidDescriptor describing the first problem found. Font resolution
+// validates descriptors upfront, so that callers get a precise error instead of
+// a font not being found.
+func (desc Descriptor) Validate() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: "+format, append([]any{ErrInvalidDescriptor}, args...)...)
+	}
+	switch {
+	case strings.TrimSpace(desc.Pattern) == "":
+		return invalid("empty font family pattern")
+	case desc.Style < font.StyleNormal || desc.Style > font.StyleOblique:
+		return invalid("unknown style %d", desc.Style)
+	case desc.Weight < font.WeightThin || desc.Weight > font.WeightBlack:
+		return invalid("weight %d out of range", desc.Weight)
+	case desc.Width < font.StretchUltraCondensed || desc.Width > font.StretchUltraExpanded:
+		return invalid("width %d out of range", desc.Width)
+	case desc.Category != "" && !categories[strings.ToLower(desc.Category)]:
+		return invalid("unknown category %q", desc.Category)
+	case desc.MinConfidence < NoConfidence || desc.MinConfidence > PerfectConfidence:
+		return invalid("minimum confidence %d out of range", desc.MinConfidence)
+	}
+	return nil
+}
+
+// ParseDescriptor parses a CSS-like font specification, e.g.
+//
+//	bold italic 12pt Helvetica
+//	300 condensed "Open Sans", sans-serif
+//
+// Style, weight and width keywords precede the family name, as with the CSS font
+// shorthand. Weights are given as words ("semibold") or CSS numbers (100…900).
+// Font sizes (with or without a line-height, e.g. "12pt/14pt") are ignored for now.
+// Family names containing spaces may be quoted. Of a comma-separated list of
+// families, the first one is used.
+func ParseDescriptor(spec string) (Descriptor, error) {
+	desc := Descriptor{Style: font.StyleNormal, Weight: font.WeightNormal}
+	tokens, err := tokenizeSpec(spec)
+	if err != nil {
+		return desc, err
+	}
+	var family []string
+	for i, tok := range tokens {
+		if tok.quoted || tok.text == "," || !desc.keyword(tok.text) {
+			family = tokens[i:].family()
+			break
+		}
+	}
+	desc.Pattern = strings.Join(family, " ")
+	if desc.Pattern == "" {
+		return desc, fmt.Errorf("no font family in %q", spec)
+	}
+	return desc, nil
+}
+
+// keyword sets style, weight or width of desc if word is a keyword for it, or
+// skips a font size. It returns false if word is not a keyword.
+func (desc *Descriptor) keyword(word string) bool {
+	lower := strings.ToLower(word)
+	switch lower {
+	case "normal":
+		return true
+	case "italic":
+		desc.Style = font.StyleItalic
+		return true
+	case "oblique":
+		desc.Style = font.StyleOblique
+		return true
+	}
+	if w, rest, ok := numericWeight(lower); ok && rest == "" {
+		desc.Weight = w
+		return true
+	}
+	if w, ok := weightFromWord(lower); ok && len(lower) > 1 && lower == strings.TrimSuffix(lower, "italic") {
+		desc.Weight = w
+		return true
+	}
+	if word, width := containedWidth(lower); word != "" && word == lower {
+		desc.Width = width
+		return true
+	}
+	return isFontSize(lower)
+}
+
+// isFontSize returns true for a font size, e.g. "12", "12pt" or "12pt/14pt".
+func isFontSize(word string) bool {
+	if i := strings.IndexByte(word, '/'); i > 0 {
+		word = word[:i]
+	}
+	digits := strings.TrimLeftFunc(word, func(r rune) bool {
+		return unicode.IsDigit(r) || r == '.'
+	})
+	if len(digits) == len(word) {
+		return false // does not start with a number
+	}
+	switch digits {
+	case "", "pt", "px", "pc", "em", "rem", "ex", "%", "mm", "cm", "in":
+		return true
+	}
+	return false
+}
+
+// specToken is a word of a font specification. Commas are tokens of their own.
+type specToken struct {
+	text   string
+	quoted bool
+}
+
+type specTokens []specToken
+
+// family returns the words of the first family of a family list.
+func (tokens specTokens) family() []string {
+	var words []string
+	for _, tok := range tokens {
+		if tok.text == "," && !tok.quoted {
+			if len(words) > 0 {
+				break
+			}
+			continue
+		}
+		words = append(words, tok.text)
+	}
+	return words
+}
+
+// tokenizeSpec splits a font specification into words, quoted strings and commas.
+func tokenizeSpec(spec string) (specTokens, error) {
+	var tokens specTokens
+	runes := []rune(spec)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == ',':
+			tokens = append(tokens, specToken{text: ","})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.New("unterminated quote in font specification")
+			}
+			tokens = append(tokens, specToken{text: strings.TrimSpace(string(runes[i+1 : end])), quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != ',' &&
+				runes[end] != '"' && runes[end] != '\'' {
+				end++
+			}
+			tokens = append(tokens, specToken{text: string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
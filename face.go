@@ -0,0 +1,54 @@
+package fontfind
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Default size and resolution for faces created without explicit options.
+const (
+	DefaultPtSize = 12 // printer's points
+	DefaultDPI    = 72
+)
+
+// NewFace creates a drawable face for font f. For font collections (*.ttc),
+// face number f.FaceIndex is used.
+//
+// If opts is nil, the face is created for DefaultPtSize and DefaultDPI, see FaceOptions.
+func NewFace(f ScalableFont, opts *opentype.FaceOptions) (font.Face, error) {
+	sf, err := f.Sfnt()
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = FaceOptions(fixed.I(DefaultPtSize), DefaultDPI)
+	}
+	return opentype.NewFace(sf, opts)
+}
+
+// FaceOptions returns face options for a font point-size and an output resolution.
+// Package opentype measures sizes in PostScript points (1/72 in), whereas we use
+// printer's points (1/72.27 in, see PtIn). The options are set up such that the
+// resulting face has PpEm(ptSize, dpi) pixels per em.
+func FaceOptions(ptSize fixed.Int26_6, dpi float32) *opentype.FaceOptions {
+	pt := float64(ptSize) / 64
+	return &opentype.FaceOptions{
+		Size:    pt * 72 / 72.27,
+		DPI:     float64(dpi),
+		Hinting: font.HintingNone,
+	}
+}
+
+// FaceFromCollection creates a drawable face for member faceIndex of a font
+// collection (*.ttc, *.otc). A single font (*.ttf, *.otf) is treated as a
+// collection with one member.
+//
+// If opts is nil, opentype's default face options will be used.
+func FaceFromCollection(f ScalableFont, faceIndex int, opts *opentype.FaceOptions) (font.Face, error) {
+	sf, err := loadSfnt(&f, faceIndex)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(sf, opts)
+}
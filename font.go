@@ -20,8 +20,8 @@ differently–actually more or less in an opposite manner.
 
 # Status
 
-Does not yet contain methods for font collections (*.ttc), e.g.,
-/System/Library/Fonts/Helvetica.ttc on Mac OS.
+Font collections (*.ttc), e.g., /System/Library/Fonts/Helvetica.ttc on Mac OS,
+are supported at the face level by FaceFromCollection.
 
 # Links
 
@@ -42,7 +42,10 @@ package fontfind
 import (
 	"embed"
 	"errors"
+	"io"
 	"io/fs"
+	"os"
+	"path/filepath"
 
 	"github.com/npillmayer/schuko/tracing"
 	"golang.org/x/image/font"
@@ -67,11 +70,49 @@ const (
 	WeightBold     = font.WeightBold
 )
 
-// Descriptor describes a requested scalable font by family pattern, style, and weight.
+// Descriptor describes a requested scalable font by family pattern, style, weight
+// and width.
 type Descriptor struct {
 	Pattern string
 	Style   font.Style
 	Weight  font.Weight
+	Width   font.Stretch // condensed or expanded; zero value is normal width
+	Subset  string       // required script subset, e.g. "devanagari"; empty for any
+	Sample  string       // text the font has to cover, see ScalableFont.Covers; empty for any
+	// Category is the required design category of a font, as classified by Google Fonts:
+	// "serif", "sans-serif", "display", "handwriting" or "monospace"; empty for any.
+	// Locators without category information ignore it.
+	Category string
+	// MinConfidence is the match confidence required of a font, see AcceptMatch.
+	// The zero value accepts matches with more than LowConfidence.
+	MinConfidence MatchConfidence
+	// FuzzyFamily lets a pattern match family names with a similar name if no family
+	// matches it exactly, e.g. "Helvetica" for "Helvetca" (see FuzzyFamilyMatch).
+	// Locators without fuzzy matching ignore it.
+	FuzzyFamily bool
+}
+
+// Source tells where a font has been located.
+type Source int
+
+// Font sources, set by the font locators.
+const (
+	SourceUnknown  Source = iota
+	SourcePackaged        // embedded in this module, e.g. fallback fonts
+	SourceSystem          // installed on the host system
+	SourceGoogle          // downloaded from the Google Fonts service
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourcePackaged:
+		return "packaged"
+	case SourceSystem:
+		return "system"
+	case SourceGoogle:
+		return "google"
+	}
+	return "unknown"
 }
 
 // ScalableFont describes a concrete font variant and where to load it from.
@@ -79,14 +120,38 @@ type ScalableFont struct {
 	Name       string
 	Style      font.Style
 	Weight     font.Weight
+	FaceIndex  int             // index of the face within a font collection (*.ttc)
+	Source     Source          // where the font has been located
+	Instance   *NamedInstance  // selected instance of a variable font, see SelectInstance
+	Confidence MatchConfidence // how well the font matches its descriptor, as judged by the locator
 	fileSystem fs.FS
 	path       string
+	file       string // path of the font file on the host, see SetFile()
 }
 
 // SetFS sets file-system and path for loading font bytes.
 func (f *ScalableFont) SetFS(fs fs.FS, path string) {
 	f.fileSystem = fs
 	f.path = path
+	f.file = ""
+	f.Instance = nil
+}
+
+// SetFile sets a font file of the host's file system for loading font bytes.
+func (f *ScalableFont) SetFile(file string) {
+	dir, base := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	f.SetFS(os.DirFS(dir), base)
+	f.file = file
+}
+
+// File returns the path of the font file on the host's file system, if the font
+// has been set up with SetFile. For other fonts, e.g. embedded ones, File
+// returns the empty string.
+func (f *ScalableFont) File() string {
+	return f.file
 }
 
 // Path returns the path of the font file inside the configured file-system.
@@ -94,6 +159,13 @@ func (f *ScalableFont) Path() string {
 	return f.path
 }
 
+// FS returns the file-system the font data is read from, together with the path of
+// the font file inside it, e.g. for handing a font to libraries doing their own reads.
+// The file-system is nil if none has been set (see SetFS and SetFile).
+func (f *ScalableFont) FS() (fs.FS, string) {
+	return f.fileSystem, f.path
+}
+
 // ReadFontData reads the raw bytes of this scalable font from its configured file-system.
 func (f *ScalableFont) ReadFontData() ([]byte, error) {
 	if f.fileSystem == nil {
@@ -105,6 +177,23 @@ func (f *ScalableFont) ReadFontData() ([]byte, error) {
 	return fs.ReadFile(f.fileSystem, f.path)
 }
 
+// WriteTo streams the raw bytes of this scalable font to w, e.g. to save a font to
+// a known path for tools which accept font files only. It implements io.WriterTo.
+func (f *ScalableFont) WriteTo(w io.Writer) (int64, error) {
+	if f.fileSystem == nil {
+		return 0, errors.New("no file system to read from")
+	}
+	if f.path == "" {
+		return 0, errors.New("path not set")
+	}
+	file, err := f.fileSystem.Open(f.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return io.Copy(w, file)
+}
+
 // NullFont is the zero-value marker used when no scalable font could be resolved.
 var NullFont = ScalableFont{}
 
@@ -117,6 +206,7 @@ func FallbackFont() ScalableFont {
 		Name:       "Go-Regular.otf",
 		Style:      font.StyleNormal,
 		Weight:     font.WeightNormal,
+		Source:     SourcePackaged,
 		path:       "locate/fallbackfont/packaged/Go-Regular.otf",
 		fileSystem: fallbackFS,
 	}
@@ -152,7 +242,8 @@ var PtIn fixed.Int26_6 = fixed.I(72) + fixed.I(27)/100
 // PpEm calculates a ppem value for a given font point-size and an output resolution (dpi).
 func PpEm(ptSize fixed.Int26_6, dpi float32) fixed.Int26_6 {
 	_dpi := fixed.Int26_6(dpi * 64)
-	return _dpi * (ptSize / PtIn)
+	// multiply before dividing, ptSize/PtIn would truncate to an integer
+	return fixed.Int26_6(int64(ptSize) * int64(_dpi) / int64(PtIn))
 }
 
 // RasterCoords transforms `u`, a value in font-units, into pixel coordinates.
@@ -160,7 +251,7 @@ func PpEm(ptSize fixed.Int26_6, dpi float32) fixed.Int26_6 {
 func RasterCoords(u sfnt.Units, sfont *sfnt.Font, ptSize fixed.Int26_6, dpi float32) fixed.Int26_6 {
 	_ppem := PpEm(ptSize, dpi)
 	uem := sfont.UnitsPerEm()
-	_uem := fixed.I(int(uem))
-	_u := fixed.I(int(u)) * _ppem / _uem
-	return _u
+	// compute in 64 bits, u * ppem overflows Int26_6 for large coordinates at high dpi
+	_u := int64(u) * int64(_ppem) / int64(uem)
+	return fixed.Int26_6(_u)
 }
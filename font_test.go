@@ -0,0 +1,633 @@
+package fontfind
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/npillmayer/fontfind/internal/fonttest"
+	"github.com/npillmayer/schuko/tracing/gotestingadapter"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const packagedDir = "locate/fallbackfont/packaged/"
+
+func readPackaged(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(packagedDir + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func collectionFont(t testing.TB) ScalableFont {
+	t.Helper()
+	ttc := fonttest.MakeCollection(readPackaged(t, "Go-Regular.otf"), readPackaged(t, "Go-Mono.otf"))
+	dir := t.TempDir() // a comparable file-system, so parsed faces are cached
+	if err := os.WriteFile(dir+"/Go.ttc", ttc, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := ScalableFont{Name: "Go.ttc"}
+	f.SetFS(os.DirFS(dir), "Go.ttc")
+	return f
+}
+
+func TestFaceFromCollection(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := collectionFont(t)
+	regular, err := FaceFromCollection(f, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mono, err := FaceFromCollection(f, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ri, _ := regular.GlyphAdvance('i')
+	rm, _ := regular.GlyphAdvance('M')
+	if ri >= rm {
+		t.Errorf("expected proportional face 0, advances are i=%v, M=%v", ri, rm)
+	}
+	mi, ok := mono.GlyphAdvance('i')
+	mm, _ := mono.GlyphAdvance('M')
+	if !ok || mi != mm {
+		t.Errorf("expected monospaced face 1, advances are i=%v, M=%v", mi, mm)
+	}
+	if _, err = FaceFromCollection(f, 2, nil); err == nil {
+		t.Errorf("expected error for out of range face index")
+	}
+}
+
+func TestNewFace(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := collectionFont(t)
+	f.FaceIndex = 1
+	face, err := NewFace(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+	mi, ok := face.GlyphAdvance('i')
+	mm, _ := face.GlyphAdvance('M')
+	if !ok || mi != mm {
+		t.Errorf("expected monospaced face 1, advances are i=%v, M=%v", mi, mm)
+	}
+	opts := FaceOptions(fixed.I(12), 72)
+	if ppem := opts.Size * opts.DPI / 72; ppem > 11.96 || ppem < 11.95 {
+		t.Errorf("expected face options for 11.955 ppem, have %.3f", ppem)
+	}
+}
+
+func TestCovers(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := FallbackFont()
+	missing, err := f.Covers([]rune("Grüße, Ωμέγα, Жук"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected Go Regular to cover Latin, Greek and Cyrillic, misses %q", missing)
+	}
+	if missing, _ = f.Covers([]rune("aक中क")); string(missing) != "क中" {
+		t.Errorf("expected Devanagari and Han to be missing once each, misses %q", missing)
+	}
+}
+
+func TestPpEm(t *testing.T) {
+	// 10pt at 300 dpi = 10 * 300 / 72.27 = 41.51 pixels
+	if ppem := PpEm(fixed.I(10), 300); ppem.Floor() != 41 || ppem < fixed.I(41)+32 {
+		t.Errorf("expected 41.51 ppem for 10pt at 300 dpi, have %v", ppem)
+	}
+	if ppem := PpEm(fixed.I(12), 72.27); ppem != fixed.I(12) {
+		t.Errorf("expected 12 ppem for 12pt at 72.27 dpi, have %v", ppem)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := FallbackFont()
+	sf, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Metrics(sf, fixed.I(12), 72.27) // 12 ppem
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Ascent <= 0 || m.Descent <= 0 || m.Height < m.Ascent+m.Descent {
+		t.Errorf("expected positive ascent and descent within line height, have %+v", m)
+	}
+	if m.XHeight <= 0 || m.CapHeight <= m.XHeight || m.CapHeight > m.Ascent {
+		t.Errorf("expected 0 < x-height < cap-height <= ascent, have %+v", m)
+	}
+	double, err := Metrics(sf, fixed.I(24), 72.27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := double.Ascent - 2*m.Ascent; d < -1 || d > 1 {
+		t.Errorf("expected metrics to scale with point-size, have %v for 12pt, %v for 24pt", m.Ascent, double.Ascent)
+	}
+}
+
+func TestKern(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := FallbackFont()
+	sf, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf sfnt.Buffer
+	a, _ := sf.GlyphIndex(&buf, 'A')
+	v, _ := sf.GlyphIndex(&buf, 'V')
+	if a == 0 || v == 0 {
+		t.Fatal("expected glyphs for 'A' and 'V'")
+	}
+	ppem := PpEm(fixed.I(12), 72)
+	if k, err := Kern(sf, a, v, ppem); err != nil || k != 0 { // Go fonts are not kerned
+		t.Errorf("expected no kerning of 'A' and 'V', have %v (%v)", k, err)
+	}
+	if _, err := Kern(sf, a, sfnt.GlyphIndex(sf.NumGlyphs()), ppem); err == nil {
+		t.Errorf("expected kerning of glyph out of range to fail")
+	}
+	// a legacy kern table with a single pair
+	kern := make([]byte, 4+6+8+6)
+	binary.BigEndian.PutUint16(kern[2:], 1)      // number of sub-tables
+	binary.BigEndian.PutUint16(kern[6:], 6+8+6)  // sub-table length
+	binary.BigEndian.PutUint16(kern[8:], 0x0001) // horizontal, format 0
+	binary.BigEndian.PutUint16(kern[10:], 1)     // number of pairs
+	binary.BigEndian.PutUint16(kern[18:], uint16(a))
+	binary.BigEndian.PutUint16(kern[20:], uint16(v))
+	binary.BigEndian.PutUint16(kern[22:], 0xff38) // -200 units
+	kerned, err := sfnt.Parse(addTable(readPackaged(t, "Go-Regular.otf"), "kern", kern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := Kern(kerned, a, v, ppem); err != nil || k >= 0 {
+		t.Errorf("expected 'A' and 'V' to be kerned closer together, have %v (%v)", k, err)
+	}
+	if k, err := Kern(kerned, v, a, ppem); err != nil || k != 0 {
+		t.Errorf("expected no kerning of 'V' and 'A', have %v (%v)", k, err)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := FallbackFont()
+	sf, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := Advance(sf, 'i', fixed.I(12), 72.27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Advance(sf, 'M', fixed.I(12), 72.27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i <= 0 || i >= m || m > fixed.I(12) {
+		t.Errorf("expected 0 < advance of 'i' < advance of 'M' <= 12 pixels, have %v, %v", i, m)
+	}
+	// consistent with RasterCoords
+	var buf sfnt.Buffer
+	x, _ := sf.GlyphIndex(&buf, 'M')
+	units, err := sf.GlyphAdvance(&buf, x, fixed.Int26_6(sf.UnitsPerEm()), font.HintingNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := m - RasterCoords(sfnt.Units(units), sf, fixed.I(12), 72.27); d < -1 || d > 1 {
+		t.Errorf("expected advance to equal RasterCoords of advance in font units, differs by %v", d)
+	}
+	if _, err := Advance(sf, '\U0001F600', fixed.I(12), 72.27); err == nil {
+		t.Errorf("expected advance of rune without glyph to fail")
+	}
+}
+
+func TestRasterCoordsLargeValues(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := FallbackFont()
+	sf, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.UnitsPerEm() != 2048 {
+		t.Fatalf("expected Go Regular to have 2048 units per em, has %d", sf.UnitsPerEm())
+	}
+	// 72pt at 600 dpi = 597.76 ppem; 30000 units = 30000/2048 em = 8756.2 pixels
+	px := RasterCoords(30000, sf, fixed.I(72), 600)
+	if px.Floor() != 8756 {
+		t.Errorf("expected 8756.2 pixels, have %v", px)
+	}
+	if px = RasterCoords(-30000, sf, fixed.I(72), 600); px.Ceil() != -8756 {
+		t.Errorf("expected -8756.2 pixels, have %v", px)
+	}
+}
+
+func TestSfntFaceIndex(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := collectionFont(t)
+	f.FaceIndex = 1
+	sf, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post := sf.PostTable(); post == nil || !post.IsFixedPitch {
+		t.Errorf("expected face 1 of collection to be monospaced Go Mono")
+	}
+	again, err := f.Sfnt()
+	if err != nil || again != sf {
+		t.Errorf("expected repeated call to return cached font")
+	}
+	f.FaceIndex = 0
+	if sf, err = f.Sfnt(); err != nil || sf.PostTable().IsFixedPitch {
+		t.Errorf("expected face 0 of collection to be proportional Go Regular")
+	}
+}
+
+func TestSfntSharedAcrossScalableFonts(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	fsys := os.DirFS(packagedDir)
+	a := ScalableFont{Name: "Go-Regular.otf"}
+	a.SetFS(fsys, "Go-Regular.otf")
+	b := ScalableFont{Name: "Go-Regular"}
+	b.SetFS(fsys, "Go-Regular.otf")
+	sa, err := a.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := b.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa != sb {
+		t.Errorf("expected second ScalableFont to reuse parsed font")
+	}
+}
+
+func TestSfntCacheInvalidatedOnChange(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/Go.otf", readPackaged(t, "Go-Regular.otf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := ScalableFont{Name: "Go.otf"}
+	f.SetFS(os.DirFS(dir), "Go.otf")
+	s1, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(dir+"/Go.otf", later, later); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1 == s2 {
+		t.Errorf("expected font to be parsed again after modification")
+	}
+}
+
+func TestSfntMapFSNotCached(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	fsys := fstest.MapFS{"Go.otf": &fstest.MapFile{Data: readPackaged(t, "Go-Regular.otf")}}
+	f := ScalableFont{Name: "Go.otf"}
+	f.SetFS(fsys, "Go.otf")
+	if _, err := f.Sfnt(); err != nil {
+		t.Fatal(err)
+	}
+	fsys["Go.otf"] = &fstest.MapFile{Data: readPackaged(t, "Go-Mono.otf")} // same modification time
+	sf, err := f.Sfnt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post := sf.PostTable(); post == nil || !post.IsFixedPitch {
+		t.Errorf("expected replaced file of a map file-system to be parsed again")
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	fsys := fstest.MapFS{"a1b2c3.otf": &fstest.MapFile{Data: readPackaged(t, "Go-Bold-Italic.otf")}}
+	f := ScalableFont{Name: "a1b2c3.otf"}
+	f.SetFS(fsys, "a1b2c3.otf")
+	md, err := ReadMetadata(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Go Bold declares OS/2 weight class 600
+	if md.Family != "Go" || md.Style != font.StyleItalic || md.Weight != font.WeightSemiBold || md.IsMonospace {
+		t.Errorf("unexpected metadata for Go Bold Italic: %+v", md)
+	}
+	if s, w := GuessFontStyleAndWeight(f); s != font.StyleItalic || w != font.WeightSemiBold {
+		t.Errorf("expected style and weight from font binary for hashed name, got %v, %v", s, w)
+	}
+	mono := collectionFont(t)
+	mono.FaceIndex = 1
+	if md, err = ReadMetadata(mono); err != nil {
+		t.Fatal(err)
+	}
+	if md.Family != "Go Mono" || !md.IsMonospace || md.Weight != font.WeightNormal {
+		t.Errorf("unexpected metadata for face 1 of collection: %+v", md)
+	}
+}
+
+func BenchmarkSfnt(b *testing.B) {
+	fsys := os.DirFS(packagedDir)
+	for i := 0; i < b.N; i++ {
+		f := ScalableFont{Name: "Go-Regular.otf"}
+		f.SetFS(fsys, "Go-Regular.otf")
+		if _, err := f.Sfnt(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLoadFromBytes(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	data := readPackaged(t, "Go-Regular.otf")
+	f := LoadFromBytes("fonts/Go-Regular.otf", data, font.StyleNormal, font.WeightNormal)
+	if f.Path() != "Go-Regular.otf" {
+		t.Errorf("expected path Go-Regular.otf, have %q", f.Path())
+	}
+	read, err := f.ReadFontData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(data) {
+		t.Errorf("expected %d bytes of font data, have %d", len(data), len(read))
+	}
+	if _, err := f.Sfnt(); err != nil {
+		t.Fatal(err)
+	}
+	if missing, err := f.Covers([]rune("abc")); err != nil || len(missing) != 0 {
+		t.Errorf("expected in-memory font to cover 'abc', misses %q (%v)", missing, err)
+	}
+	if fsys, p := f.FS(); fsys == nil || p != f.Path() {
+		t.Errorf("expected file-system and path of in-memory font, have %v, %q", fsys, p)
+	} else if b, err := fs.ReadFile(fsys, p); err != nil || len(b) != len(data) {
+		t.Errorf("expected font data to be readable from file-system, have %d bytes (%v)", len(b), err)
+	}
+	if fsys, _ := NullFont.FS(); fsys != nil {
+		t.Errorf("expected null font to have no file-system")
+	}
+	g := LoadFromBytes("", data, font.StyleNormal, font.WeightNormal)
+	if _, err := g.ReadFontData(); err != nil {
+		t.Errorf("expected unnamed in-memory font to be readable: %v", err)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	f := FallbackFont()
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := readPackaged(t, "Go-Regular.otf")
+	if n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expected %d bytes of Go Regular to be written, have %d", len(data), n)
+	}
+	if _, err := NullFont.WriteTo(&buf); err == nil {
+		t.Errorf("expected writing a font without file system to fail")
+	}
+}
+
+func TestContentHashAndEqual(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	packaged := FallbackFont()
+	copied := LoadFromBytes("copy.otf", readPackaged(t, "Go-Regular.otf"), font.StyleItalic, font.WeightBold)
+	if !packaged.Equal(copied) {
+		t.Errorf("expected a copy of Go Regular to equal the packaged font")
+	}
+	bold := LoadFromBytes("Go-Bold.otf", readPackaged(t, "Go-Bold.otf"), font.StyleNormal, font.WeightNormal)
+	if packaged.Equal(bold) || packaged.Equal(NullFont) {
+		t.Errorf("expected different fonts not to be equal")
+	}
+	file := t.TempDir() + "/font.otf"
+	if err := os.WriteFile(file, []byte("font-v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var f ScalableFont
+	f.SetFile(file)
+	h1, err := f.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := f.ContentHash(); h != h1 || len(h1) != 64 {
+		t.Errorf("expected stable SHA-256 hash, have %q and %q", h1, h)
+	}
+	if err = os.WriteFile(file, []byte("font-v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if h2, err := f.ContentHash(); err != nil || h2 == h1 {
+		t.Errorf("expected hash of changed font file to change (%v)", err)
+	}
+}
+
+// addTable adds a table to a single SFNT font, keeping table records sorted by tag.
+func addTable(data []byte, tag string, table []byte) []byte {
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	pos := 12
+	for pos < 12+16*numTables && string(data[pos:pos+4]) < tag {
+		pos += 16
+	}
+	out := make([]byte, 0, len(data)+16+len(table)+4)
+	out = append(out, data[:pos]...)
+	out = append(out, make([]byte, 16)...)
+	out = append(out, data[pos:]...)
+	binary.BigEndian.PutUint16(out[4:], uint16(numTables+1))
+	for rec := 12; rec < 12+16*(numTables+1); rec += 16 { // tables moved by one record
+		if rec != pos {
+			binary.BigEndian.PutUint32(out[rec+8:], binary.BigEndian.Uint32(out[rec+8:])+16)
+		}
+	}
+	for len(out)%4 != 0 {
+		out = append(out, 0)
+	}
+	copy(out[pos:], tag)
+	binary.BigEndian.PutUint32(out[pos+8:], uint32(len(out)))
+	binary.BigEndian.PutUint32(out[pos+12:], uint32(len(table)))
+	return append(out, table...)
+}
+
+// makeFvar creates an fvar table with axes and instances, given as coordinates in
+// axis order. Instances are named by name ID 2 (subfamily).
+func makeFvar(axes []string, instances ...[]float64) []byte {
+	fvar := make([]byte, 16)
+	binary.BigEndian.PutUint16(fvar[0:], 1)
+	binary.BigEndian.PutUint16(fvar[4:], 16)
+	binary.BigEndian.PutUint16(fvar[6:], 2)
+	binary.BigEndian.PutUint16(fvar[8:], uint16(len(axes)))
+	binary.BigEndian.PutUint16(fvar[10:], 20)
+	binary.BigEndian.PutUint16(fvar[12:], uint16(len(instances)))
+	binary.BigEndian.PutUint16(fvar[14:], uint16(4+4*len(axes)))
+	for _, tag := range axes {
+		axis := make([]byte, 20)
+		copy(axis, tag)
+		fvar = append(fvar, axis...)
+	}
+	for _, coords := range instances {
+		inst := make([]byte, 4+4*len(coords))
+		binary.BigEndian.PutUint16(inst, 2)
+		for i, c := range coords {
+			binary.BigEndian.PutUint32(inst[4+4*i:], uint32(int32(c*65536)))
+		}
+		fvar = append(fvar, inst...)
+	}
+	return fvar
+}
+
+func TestSelectNamedInstance(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	fvar := makeFvar([]string{"wght", "ital"},
+		[]float64{300, 0}, []float64{400, 0}, []float64{700, 0}, []float64{700, 1}, []float64{900, 0})
+	data := addTable(readPackaged(t, "Go-Regular.otf"), "fvar", fvar)
+	f := LoadFromBytes("Go-Variable.otf", data, font.StyleNormal, font.WeightNormal)
+	instances, err := NamedInstances(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 5 || instances[0].Name != "Regular" {
+		t.Fatalf("expected 5 named instances named Regular, have %+v", instances)
+	}
+	for _, test := range []struct {
+		style      font.Style
+		weight     font.Weight
+		wght, ital float64
+	}{
+		{font.StyleNormal, font.WeightBold, 700, 0},
+		{font.StyleItalic, font.WeightBold, 700, 1},
+		{font.StyleNormal, font.WeightLight, 300, 0},
+		{font.StyleNormal, font.WeightBlack, 900, 0},
+	} {
+		ok, err := f.SelectInstance(test.style, test.weight)
+		if !ok || err != nil {
+			t.Fatalf("expected an instance to be selected, have %v", err)
+		}
+		wght, _ := f.Instance.Coord("wght")
+		ital, _ := f.Instance.Coord("ital")
+		if wght != test.wght || ital != test.ital {
+			t.Errorf("expected instance wght=%v ital=%v for %v/%v, have %+v",
+				test.wght, test.ital, test.style, test.weight, f.Instance.Coords)
+		}
+	}
+	g := FallbackFont()
+	if ok, err := g.SelectInstance(font.StyleNormal, font.WeightBold); ok || err != nil || g.Instance != nil {
+		t.Errorf("expected no instance for a static font, have %v (%v)", g.Instance, err)
+	}
+	corrupt := LoadFromBytes("Corrupt.otf", data[:len(data)-len(fvar)/2], font.StyleNormal, font.WeightNormal)
+	if instances, err := NamedInstances(corrupt); err == nil {
+		t.Errorf("expected error for truncated fvar table, have %+v", instances)
+	}
+}
+
+func TestParseDescriptor(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want Descriptor
+	}{
+		{"bold italic 12pt Helvetica", Descriptor{Pattern: "Helvetica", Style: font.StyleItalic, Weight: font.WeightBold}},
+		{`300 condensed "Open Sans", sans-serif`, Descriptor{Pattern: "Open Sans", Weight: font.WeightLight,
+			Width: font.StretchCondensed}},
+		{"oblique semibold 10pt/12pt Times New Roman", Descriptor{Pattern: "Times New Roman",
+			Style: font.StyleOblique, Weight: font.WeightSemiBold}},
+		{"'Noto Sans Devanagari'", Descriptor{Pattern: "Noto Sans Devanagari"}},
+		{"normal 11 Gentium", Descriptor{Pattern: "Gentium"}},
+		{"semicondensed Roboto", Descriptor{Pattern: "Roboto", Width: font.StretchSemiCondensed}},
+	} {
+		desc, err := ParseDescriptor(test.spec)
+		if err != nil {
+			t.Errorf("cannot parse %q: %v", test.spec, err)
+			continue
+		}
+		if desc != test.want {
+			t.Errorf("expected %q to parse as %+v, have %+v", test.spec, test.want, desc)
+		}
+	}
+	for _, spec := range []string{"", "bold italic 12pt", `"Open Sans`} {
+		if _, err := ParseDescriptor(spec); err == nil {
+			t.Errorf("expected %q not to parse", spec)
+		}
+	}
+}
+
+func TestWidthName(t *testing.T) {
+	for width := font.StretchUltraCondensed; width <= font.StretchUltraExpanded; width++ {
+		name := WidthName(width)
+		if (name == "") != (width == font.StretchNormal) {
+			t.Errorf("unexpected name %q for width %d", name, width)
+		} else if name != "" && GuessWidth("Roboto "+name) != width {
+			t.Errorf("expected width %d to be guessed from %q, have %d", width, name, GuessWidth(name))
+		}
+	}
+	if GuessWidth("Archivo Narrow") != font.StretchCondensed {
+		t.Errorf("expected narrow fonts to be condensed")
+	}
+}
+
+func TestDescriptorValidate(t *testing.T) {
+	valid := Descriptor{Pattern: "Noto Sans", Weight: font.WeightBlack, Width: font.StretchUltraCondensed,
+		Category: "Sans-Serif", MinConfidence: HighConfidence}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected %+v to be valid, have %v", valid, err)
+	}
+	for _, desc := range []Descriptor{
+		{Pattern: "  "},
+		{Pattern: "Noto Sans", Style: font.StyleOblique + 1},
+		{Pattern: "Noto Sans", Weight: font.WeightBlack + 1},
+		{Pattern: "Noto Sans", Width: font.StretchUltraExpanded + 1},
+		{Pattern: "Noto Sans", Category: "blackletter"},
+		{Pattern: "Noto Sans", MinConfidence: PerfectConfidence + 1},
+	} {
+		if err := desc.Validate(); !errors.Is(err, ErrInvalidDescriptor) {
+			t.Errorf("expected %+v to be invalid, have %v", desc, err)
+		}
+	}
+}
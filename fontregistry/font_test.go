@@ -1,11 +1,21 @@
 package fontregistry
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/schuko/tracing"
 	"github.com/npillmayer/schuko/tracing/gotestingadapter"
 	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
 )
 
 type sw struct {
@@ -30,6 +40,57 @@ func TestGuess(t *testing.T) {
 	}
 }
 
+func TestGuessNumericWeight(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	for k, v := range map[string]sw{
+		"Roboto-100.ttf":       {font.StyleNormal, font.WeightThin},
+		"Roboto-200.ttf":       {font.StyleNormal, font.WeightExtraLight},
+		"Inter-300italic.ttf":  {font.StyleItalic, font.WeightLight},
+		"Roboto-400.ttf":       {font.StyleNormal, font.WeightNormal},
+		"Roboto-500.ttf":       {font.StyleNormal, font.WeightMedium},
+		"Roboto-600.ttf":       {font.StyleNormal, font.WeightSemiBold},
+		"Roboto-700italic.ttf": {font.StyleItalic, font.WeightBold},
+		"Roboto-800.ttf":       {font.StyleNormal, font.WeightExtraBold},
+		"Roboto-900.ttf":       {font.StyleNormal, font.WeightBlack},
+		"Lato-350.ttf":         {font.StyleNormal, font.WeightNormal},
+	} {
+		style, weight := fontfind.GuessStyleAndWeight(k)
+		if style != v.s || weight != v.w {
+			t.Errorf("expected style %d and weight %d for %s, got %d and %d", v.s, v.w, k, style, weight)
+		}
+	}
+	if !fontfind.Matches("fonts/Roboto-900.ttf", "roboto", font.StyleNormal, font.WeightBlack) {
+		t.Errorf("expected match for Roboto Black, haven't")
+	}
+}
+
+func TestGuessWeightWords(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	for k, v := range map[string]sw{
+		"Inter-Thin.ttf":                 {font.StyleNormal, font.WeightThin},
+		"Inter-ExtraLight.ttf":           {font.StyleNormal, font.WeightExtraLight},
+		"Inter-Regular.ttf":              {font.StyleNormal, font.WeightNormal},
+		"Inter-Medium.ttf":               {font.StyleNormal, font.WeightMedium},
+		"Inter-SemiBold.ttf":             {font.StyleNormal, font.WeightSemiBold},
+		"Inter-ExtraBoldItalic.ttf":      {font.StyleItalic, font.WeightExtraBold},
+		"Inter-Heavy.ttf":                {font.StyleNormal, font.WeightBlack},
+		"Inter-Black.ttf":                {font.StyleNormal, font.WeightBlack},
+		"Gill Sans MT Semi Bold.ttf":     {font.StyleNormal, font.WeightSemiBold},
+		"Gill Sans MT Medium Italic.ttf": {font.StyleItalic, font.WeightMedium},
+		"NothingYouCouldDo.ttf":          {font.StyleNormal, font.WeightNormal},
+		"Go-BoldItalic.ttf":              {font.StyleItalic, font.WeightBold},
+	} {
+		style, weight := fontfind.GuessStyleAndWeight(k)
+		if style != v.s || weight != v.w {
+			t.Errorf("expected style %d and weight %d for %s, got %d and %d", v.s, v.w, k, style, weight)
+		}
+	}
+}
+
 func TestMatch(t *testing.T) {
 	teardown := gotestingadapter.QuickConfig(t, "resources")
 	defer teardown()
@@ -48,6 +109,50 @@ func TestMatch(t *testing.T) {
 	}
 }
 
+func TestClosestMatchCombinedVariant(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fdescs := []fontfind.FontVariantsLocation{
+		{Family: "Noto Sans", Variants: []string{"regular", "italic", "700", "700italic"}},
+	}
+	_, v, conf := fontfind.ClosestMatch(fdescs, "noto", font.StyleItalic, font.WeightBold)
+	if v != "700italic" || conf != fontfind.PerfectConfidence {
+		t.Errorf("expected perfect match 700italic, got %q with confidence %d", v, conf)
+	}
+}
+
+func TestClosestMatchWidth(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fdescs := []fontfind.FontVariantsLocation{
+		{Family: "Roboto Condensed", Variants: []string{"regular", "700"}},
+		{Family: "Roboto", Variants: []string{"regular", "700"}},
+	}
+	m, _, conf := fontfind.ClosestMatch(fdescs, "roboto", font.StyleNormal, font.WeightNormal)
+	if m.Family != "Roboto" || conf != fontfind.PerfectConfidence {
+		t.Errorf("expected normal width Roboto, got %q with confidence %d", m.Family, conf)
+	}
+	m, _, conf = fontfind.ClosestMatchWithWidth(fdescs, "roboto", font.StyleNormal, font.WeightNormal,
+		font.StretchCondensed)
+	if m.Family != "Roboto Condensed" || conf != fontfind.PerfectConfidence {
+		t.Errorf("expected Roboto Condensed, got %q with confidence %d", m.Family, conf)
+	}
+	// a perfect style and weight do not make up for a width one step off
+	m, _, conf = fontfind.ClosestMatchWithWidth(fdescs[1:], "roboto", font.StyleNormal, font.WeightNormal,
+		font.StretchSemiCondensed)
+	if m.Family != "Roboto" || conf != fontfind.LowConfidence {
+		t.Errorf("expected Roboto with low confidence, got %q with confidence %d", m.Family, conf)
+	}
+	if c := fontfind.MatchWidth("DejaVu Sans Semi Condensed", font.StretchCondensed); c != fontfind.HighConfidence {
+		t.Errorf("expected high confidence for semi-condensed font, got %d", c)
+	}
+	if c := fontfind.MatchWidth("Arial", font.StretchExpanded); c != fontfind.NoConfidence {
+		t.Errorf("expected no confidence for normal width font, got %d", c)
+	}
+}
+
 func TestNormalizeFont(t *testing.T) {
 	teardown := gotestingadapter.QuickConfig(t, "resources")
 	defer teardown()
@@ -56,6 +161,38 @@ func TestNormalizeFont(t *testing.T) {
 	if n != "clarendon-italic-bold" {
 		t.Errorf("expected different normalized name for clarendon")
 	}
+	medium := NormalizeFontname("Inter", font.StyleNormal, font.WeightMedium)
+	regular := NormalizeFontname("Inter", font.StyleNormal, font.WeightNormal)
+	semibold := NormalizeFontname("Inter", font.StyleNormal, font.WeightSemiBold)
+	bold := NormalizeFontname("Inter", font.StyleNormal, font.WeightBold)
+	if medium == regular || medium == bold || semibold == bold {
+		t.Errorf("expected distinct keys for weights, got %s, %s, %s, %s", regular, medium, semibold, bold)
+	}
+	condensed := NormalizeFontnameWithWidth("Inter", font.StyleNormal, font.WeightBold, font.StretchCondensed)
+	if condensed == bold || NormalizeFontnameWithWidth("Inter", font.StyleNormal, font.WeightBold, font.StretchNormal) != bold {
+		t.Errorf("expected width to be part of key for non-normal width only, got %s", condensed)
+	}
+	for _, test := range []struct {
+		name   string
+		style  font.Style
+		weight font.Weight
+		key    string
+	}{
+		{"Inter", font.StyleNormal, font.WeightSemiBold, "inter-semibold"},
+		{"Inter", font.StyleNormal, font.WeightBold, "inter-bold"},
+		{"Inter", font.StyleNormal, font.WeightMedium, "inter-medium"},
+		{"Inter", font.StyleNormal, font.WeightNormal, "inter"},
+		{"Inter.ttf", font.StyleNormal, font.WeightNormal, "inter"},
+		{" Inter ", font.StyleNormal, font.WeightNormal, "inter"},
+		{"Font 1.5", font.StyleNormal, font.WeightNormal, "font_1.5"},
+		{".otf", font.StyleNormal, font.WeightNormal, ".otf"},
+		{"Inter", font.StyleOblique, font.WeightNormal, "inter-oblique"},
+		{"Inter", font.StyleNormal, font.WeightBlack + 1, "inter-w6"},
+	} {
+		if key := NormalizeFontname(test.name, test.style, test.weight); key != test.key {
+			t.Errorf("expected key %q for %q, have %q", test.key, test.name, key)
+		}
+	}
 }
 
 func TestRegistryFallbackFont(t *testing.T) {
@@ -85,3 +222,393 @@ func TestRegistryFontReturnsFallbackOnMiss(t *testing.T) {
 		t.Fatalf("expected fallback font Go-Regular.otf, got %s", f.Name)
 	}
 }
+
+func TestNegativeCacheExpiry(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	nc := NewNegativeCache(20 * time.Millisecond)
+	nc.Add("zz-missing")
+	if !nc.Contains("zz-missing") {
+		t.Fatalf("expected negative cache to contain recent miss")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if nc.Contains("zz-missing") {
+		t.Errorf("expected miss to expire")
+	}
+	nc.Add("zz-missing")
+	nc.Clear()
+	if nc.Contains("zz-missing") {
+		t.Errorf("expected cleared negative cache to be empty")
+	}
+	var none *NegativeCache
+	none.Add("zz-missing")
+	if none.Contains("zz-missing") {
+		t.Errorf("expected nil negative cache to contain nothing")
+	}
+}
+
+func TestRegistryRemoveAndClear(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := New()
+	if _, err := fr.FallbackFont(); err != nil {
+		t.Fatal(err)
+	}
+	fr.StoreFont("go", fontfind.FallbackFont())
+	if !fr.Remove("go") {
+		t.Errorf("expected Remove to report existing key")
+	}
+	if fr.Remove("go") {
+		t.Errorf("expected Remove to report missing key")
+	}
+	fr.StoreFont("go", fontfind.FallbackFont())
+	fr.Clear()
+	if _, err := fr.GetFont("go"); err == nil {
+		t.Errorf("expected cleared registry not to contain font")
+	}
+	if _, ok := fr.fonts[fallbackFontKey]; !ok {
+		t.Errorf("expected Clear to keep fallback font")
+	}
+	// run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("font-%d", i%3)
+			for j := 0; j < 100; j++ {
+				fr.StoreFont(key, fontfind.FallbackFont())
+				fr.Remove(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRegistryStoreFontForce(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := New()
+	old, reloaded := fontfind.FallbackFont(), fontfind.FallbackFont()
+	reloaded.Name = "Go-Reloaded.otf"
+	fr.StoreFont("go", old)
+	if _, err := fr.GetTypecase("go", 12, 72); err != nil {
+		t.Fatal(err)
+	}
+	fr.StoreFont("go", reloaded)
+	if f, _ := fr.GetFont("go"); f.Name != old.Name {
+		t.Errorf("expected StoreFont not to overwrite, have %s", f.Name)
+	}
+	fr.StoreFontForce("go", reloaded)
+	if f, _ := fr.GetFont("go"); f.Name != reloaded.Name {
+		t.Errorf("expected StoreFontForce to overwrite, have %s", f.Name)
+	}
+	if len(fr.typecases["go"]) != 0 {
+		t.Errorf("expected typecases of replaced font to be dropped")
+	}
+	fr.StoreFontForce("new", reloaded)
+	if _, err := fr.GetFont("new"); err != nil {
+		t.Errorf("expected StoreFontForce to store new key, have %v", err)
+	}
+}
+
+func TestRegistryOnStore(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := New()
+	var stored []string
+	fr.OnStore(func(name string, f fontfind.ScalableFont) {
+		stored = append(stored, name)
+		fr.GetFont(name) // hooks are called without holding the lock
+	})
+	fr.OnStore(func(name string, f fontfind.ScalableFont) {
+		stored = append(stored, name+"/"+f.Name)
+	})
+	fr.StoreFont("go", fontfind.FallbackFont())
+	fr.StoreFont("go", fontfind.FallbackFont()) // not stored again
+	fr.StoreFontForce("go", fontfind.FallbackFont())
+	if len(stored) != 4 || stored[0] != "go" || stored[1] != "go/Go-Regular.otf" {
+		t.Errorf("expected hooks to be called for stored fonts, have %v", stored)
+	}
+}
+
+func TestRegistryLimitEvictsLeastRecentlyUsed(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := NewRegistryWithLimit(2)
+	if _, err := fr.FallbackFont(); err != nil {
+		t.Fatal(err)
+	}
+	fr.StoreFont("a", fontfind.FallbackFont())
+	fr.StoreFont("b", fontfind.FallbackFont())
+	if _, err := fr.GetFont("a"); err != nil { // "b" is now least recently used
+		t.Fatal(err)
+	}
+	fr.StoreFont("c", fontfind.FallbackFont())
+	if _, err := fr.GetFont("b"); err == nil {
+		t.Errorf("expected least recently used font b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := fr.GetFont(key); err != nil {
+			t.Errorf("expected font %s to survive eviction", key)
+		}
+	}
+	if _, ok := fr.fonts[fallbackFontKey]; !ok {
+		t.Errorf("expected fallback font never to be evicted")
+	}
+}
+
+func TestLogFontListConcurrentWithStore(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := New()
+	dump := tracing.NoOpTrace() // the registry's own tracer is not safe for changing levels
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				fr.StoreFont(fmt.Sprintf("font-%d-%d", i, j), fontfind.FallbackFont())
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				fr.LogFontList(dump)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRegistryList(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := New()
+	fr.StoreFont("go-bold", fontfind.FallbackFont())
+	fr.StoreFont("go", fontfind.FallbackFont())
+	fonts := fr.List()
+	if len(fonts) != 2 || fonts[0].NormalizedName != "go" || fonts[1].NormalizedName != "go-bold" {
+		t.Fatalf("expected fonts go and go-bold, sorted by name, got %v", fonts)
+	}
+	if fonts[0].Name != "Go-Regular.otf" || fonts[0].Path == "" || fonts[0].Source != fontfind.SourcePackaged {
+		t.Errorf("unexpected font info %+v", fonts[0])
+	}
+	fonts[0].NormalizedName = "modified"
+	if fr.List()[0].NormalizedName != "go" {
+		t.Errorf("expected List to return a copy")
+	}
+}
+
+func TestRegistryIndexRoundTrip(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	dir := t.TempDir()
+	present, gone := filepath.Join(dir, "Present.ttf"), filepath.Join(dir, "Gone.ttf")
+	for _, file := range []string{present, gone} {
+		if err := os.WriteFile(file, []byte("dummy"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fr := New()
+	if _, err := fr.FallbackFont(); err != nil {
+		t.Fatal(err)
+	}
+	presentKey := NormalizeFontnameWithWidth("present", font.StyleNormal, font.WeightBold, font.StretchCondensed) +
+		"-cyrillic"
+	instance := &fontfind.NamedInstance{Name: "Bold", Coords: []fontfind.AxisValue{{Tag: "wght", Value: 700}}}
+	for key, file := range map[string]string{presentKey: present, "gone": gone} {
+		f := fontfind.ScalableFont{Name: key, Weight: font.WeightBold, Source: fontfind.SourceSystem, FaceIndex: 1,
+			Confidence: fontfind.HighConfidence}
+		f.SetFile(file)
+		f.Instance = instance
+		fr.StoreFont(key, f)
+	}
+	fr.StoreFont("embedded", fontfind.FallbackFont())
+	var buf bytes.Buffer
+	if err := fr.SaveIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "embedded") || strings.Contains(buf.String(), fallbackFontKey) {
+		t.Errorf("expected embedded fonts not to be saved, index is %s", buf.String())
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := New()
+	if err := reloaded.LoadIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+	fonts := reloaded.List()
+	if len(fonts) != 1 || fonts[0].NormalizedName != presentKey {
+		t.Fatalf("expected only %s to be reloaded, got %v", presentKey, fonts)
+	}
+	f, err := reloaded.GetFont(presentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.File() != present || f.Weight != font.WeightBold || f.Source != fontfind.SourceSystem || f.FaceIndex != 1 ||
+		f.Confidence != fontfind.HighConfidence || f.Instance == nil || f.Instance.Name != "Bold" {
+		t.Errorf("unexpected reloaded font %+v", f)
+	}
+	if data, err := f.ReadFontData(); err != nil || string(data) != "dummy" {
+		t.Errorf("expected reloaded font to be readable, got %v", err)
+	}
+}
+
+func TestRegistryPreloadDir(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fallback := fontfind.FallbackFont()
+	data, err := fallback.ReadFontData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"fonts/Go-Regular.otf":      {Data: data},
+		"fonts/sub/Go-Copy.otf":     {Data: data}, // same font, stored once
+		"fonts/sub/Broken.ttf":      {Data: []byte("dummy")},
+		"fonts/ReadMe.txt":          {Data: []byte("not a font")},
+		"other/Go-Regular-Copy.otf": {Data: data},
+	}
+	fr := New()
+	n, err := fr.PreloadDir(fsys, "fonts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 font to be preloaded, have %d", n)
+	}
+	f, err := fr.GetFont(NormalizeFontname("Go", font.StyleNormal, font.WeightNormal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "Go-Regular.otf" {
+		t.Errorf("expected preloaded Go-Regular.otf, have %s", f.Name)
+	}
+	if n, err = fr.PreloadDir(fsys, "fonts"); err != nil || n != 0 {
+		t.Errorf("expected no font to be stored twice, have %d (%v)", n, err)
+	}
+	if _, err := fr.PreloadDir(fsys, "missing"); err == nil {
+		t.Errorf("expected error for missing directory")
+	}
+}
+
+func TestRegistryTypecaseCache(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := New()
+	fr.StoreFont("go", fontfind.FallbackFont())
+	tc, err := fr.GetTypecase("go", fixed.I(12), 72)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.PtSize != fixed.I(12) || tc.DPI != 72 || tc.Sfnt == nil {
+		t.Errorf("unexpected typecase %+v", tc)
+	}
+	if tc.PpEm.Floor() != 11 || tc.Metrics.Ascent <= 0 {
+		t.Errorf("expected 11.95 ppem and positive ascent, have %v and %v", tc.PpEm, tc.Metrics.Ascent)
+	}
+	if again, _ := fr.GetTypecase("go", fixed.I(12), 72); again != tc {
+		t.Errorf("expected typecase to be cached")
+	}
+	if other, _ := fr.GetTypecase("go", fixed.I(10), 72); other == tc {
+		t.Errorf("expected different typecase for different size")
+	}
+	if _, err = fr.GetTypecase("unknown", fixed.I(12), 72); err == nil {
+		t.Errorf("expected error for unknown font")
+	}
+	fr.Remove("go")
+	fr.StoreFont("go", fontfind.FallbackFont())
+	if again, _ := fr.GetTypecase("go", fixed.I(12), 72); again == tc {
+		t.Errorf("expected typecase to be dropped with its font")
+	}
+}
+
+func TestAppendSize(t *testing.T) {
+	if key := appendSize("helvetica-bold", fixed.I(12)+32, 300); key != "helvetica-bold-12.50pt-300dpi" {
+		t.Errorf("unexpected size key %q", key)
+	}
+}
+
+func TestSetFallbackOverridesCachedFallback(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fr := New()
+	if f, err := fr.FallbackFont(); err != nil || f.Name != "Go-Regular.otf" {
+		t.Fatalf("expected packaged fallback font, got %q (%v)", f.Name, err)
+	}
+	brand := fontfind.ScalableFont{Name: "Brand-Regular.otf", Source: fontfind.SourceSystem}
+	SetFallback(brand)
+	defer SetFallback(fontfind.NullFont)
+	if f, _ := fr.FallbackFont(); f.Name != brand.Name {
+		t.Errorf("expected application fallback font after override, got %q", f.Name)
+	}
+	if f, err := fr.GetFont("zz-missing"); err == nil || f.Name != brand.Name {
+		t.Errorf("expected miss to return application fallback font, got %q", f.Name)
+	}
+	SetFallback(fontfind.NullFont)
+	if f, _ := fr.FallbackFont(); f.Name != "Go-Regular.otf" {
+		t.Errorf("expected packaged fallback font after reset, got %q", f.Name)
+	}
+}
+
+func TestClosestMatchFuzzyFamily(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	fdescs := []fontfind.FontVariantsLocation{
+		{Family: "Helvetica", Variants: []string{"regular", "bold"}},
+		{Family: "M PLUS 1p", Variants: []string{"regular"}},
+	}
+	fuzzy := func(pattern string, weight font.Weight) fontfind.Descriptor {
+		return fontfind.Descriptor{Pattern: pattern, Weight: weight, FuzzyFamily: true}
+	}
+	m, _, conf := fuzzy("Helvetca", font.WeightBold).ClosestMatch(fdescs)
+	if m.Family != "Helvetica" || conf != fontfind.HighConfidence {
+		t.Errorf("expected Helvetica with high confidence, got %q with confidence %d", m.Family, conf)
+	}
+	if m, _, _ = fuzzy("M PLUS 1 p", font.WeightNormal).ClosestMatch(fdescs); m.Family != "M PLUS 1p" {
+		t.Errorf("expected M PLUS 1p, got %q", m.Family)
+	}
+	if m, _, _ = fuzzy("Helvetica Neue Condensed", font.WeightNormal).ClosestMatch(fdescs); m.Family != "" {
+		t.Errorf("expected no match for a different family, got %q", m.Family)
+	}
+	if m, _, conf = fontfind.ClosestMatch(fdescs, "Helvetca", font.StyleNormal, font.WeightBold); m.Family != "" {
+		t.Errorf("expected no match without fuzzy matching, got %q with confidence %d", m.Family, conf)
+	}
+}
+
+func TestNormalizeFamily(t *testing.T) {
+	for name, normalized := range map[string]string{
+		"Noto Sans":     "noto sans",
+		"noto  sans":    "noto sans",
+		"Amático SC":    "amatico sc",
+		"Così":          "cosi",
+		"Go-Mono_Bold ": "go mono bold",
+		"M PLUS 1p":     "m plus 1p",
+	} {
+		if n := fontfind.NormalizeFamily(name); n != normalized {
+			t.Errorf("expected %q to normalize to %q, have %q", name, normalized, n)
+		}
+	}
+	fdescs := []fontfind.FontVariantsLocation{
+		{Family: "Amático SC", Variants: []string{"regular"}},
+	}
+	m, _, conf := fontfind.ClosestMatch(fdescs, "amatico  sc", font.StyleNormal, font.WeightNormal)
+	if m.Family != "Amático SC" || conf != fontfind.PerfectConfidence {
+		t.Errorf("expected Amático SC to match perfectly, got %q with confidence %d", m.Family, conf)
+	}
+}
@@ -0,0 +1,84 @@
+package fontregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/npillmayer/fontfind"
+	xfont "golang.org/x/image/font"
+)
+
+// indexEntry is the persisted form of a registered font. Width, subset and category
+// a font has been registered for are part of its key (see NormalizeFontnameWithWidth).
+type indexEntry struct {
+	Name       string                   `json:"name"`
+	File       string                   `json:"file"`
+	Source     fontfind.Source          `json:"source"`
+	FaceIndex  int                      `json:"faceIndex,omitempty"`
+	Style      xfont.Style              `json:"style"`
+	Weight     xfont.Weight             `json:"weight"`
+	Instance   *fontfind.NamedInstance  `json:"instance,omitempty"`
+	Confidence fontfind.MatchConfidence `json:"confidence,omitempty"`
+}
+
+// SaveIndex writes the registry's mapping of normalized names to font files as JSON.
+// Only fonts located in the host's file system (see fontfind.ScalableFont.File) are
+// written; embedded fonts, including the fallback font, are skipped. The normalized
+// names keep the width, subset and category of a registered font, and the selected
+// instance of a variable font and its match confidence are written as well.
+func (fr *Registry) SaveIndex(w io.Writer) error {
+	index := make(map[string]indexEntry)
+	fr.RLock()
+	for k, f := range fr.fonts {
+		if k == fallbackFontKey || f.File() == "" {
+			continue
+		}
+		index[k] = indexEntry{
+			Name:       f.Name,
+			File:       f.File(),
+			Source:     f.Source,
+			FaceIndex:  f.FaceIndex,
+			Style:      f.Style,
+			Weight:     f.Weight,
+			Instance:   f.Instance,
+			Confidence: f.Confidence,
+		}
+	}
+	fr.RUnlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(index); err != nil {
+		return fmt.Errorf("cannot save registry index: %w", err)
+	}
+	return nil
+}
+
+// LoadIndex reads a registry index written by SaveIndex and stores its fonts into
+// the registry. Entries whose font files no longer exist are skipped. Fonts already
+// contained in the registry are not overridden.
+func (fr *Registry) LoadIndex(r io.Reader) error {
+	var index map[string]indexEntry
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return fmt.Errorf("cannot load registry index: %w", err)
+	}
+	for k, entry := range index {
+		if _, err := os.Stat(entry.File); err != nil {
+			tracer().Infof("registry index: skipping font %s: %v", k, err)
+			continue
+		}
+		f := fontfind.ScalableFont{
+			Name:       entry.Name,
+			Style:      entry.Style,
+			Weight:     entry.Weight,
+			FaceIndex:  entry.FaceIndex,
+			Source:     entry.Source,
+			Confidence: entry.Confidence,
+		}
+		f.SetFile(entry.File)
+		f.Instance = entry.Instance // SetFile resets the instance
+		fr.StoreFont(k, f)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package fontregistry
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultNegativeTTL is the default time a failed font lookup is remembered.
+const DefaultNegativeTTL = 30 * time.Second
+
+// NegativeCache remembers font lookups which failed, by normalized name, for
+// a limited time. This keeps clients repeatedly asking for an unavailable font
+// from running expensive lookups (e.g., network requests) over and over.
+//
+// All methods may be called on a nil *NegativeCache, which never contains an entry.
+type NegativeCache struct {
+	sync.Mutex
+	ttl    time.Duration
+	misses map[string]time.Time // normalized name -> expiry
+}
+
+var globalNegativeCache *NegativeCache
+
+var globalNegativeCacheCreation sync.Once
+
+// GlobalNegativeCache returns an application-wide negative cache, for resolver
+// pipelines to share (see locate.ResolverPipeline.WithNegativeCache).
+func GlobalNegativeCache() *NegativeCache {
+	globalNegativeCacheCreation.Do(func() {
+		globalNegativeCache = NewNegativeCache(DefaultNegativeTTL)
+	})
+	return globalNegativeCache
+}
+
+// NewNegativeCache creates an empty negative cache, remembering misses for ttl.
+func NewNegativeCache(ttl time.Duration) *NegativeCache {
+	return &NegativeCache{
+		ttl:    ttl,
+		misses: make(map[string]time.Time),
+	}
+}
+
+// SetTTL changes the time misses are remembered. It applies to misses added
+// afterwards. A ttl of zero or less disables the cache.
+func (nc *NegativeCache) SetTTL(ttl time.Duration) {
+	if nc == nil {
+		return
+	}
+	nc.Lock()
+	defer nc.Unlock()
+	nc.ttl = ttl
+}
+
+// Add remembers a failed lookup for normalizedName.
+func (nc *NegativeCache) Add(normalizedName string) {
+	if nc == nil {
+		return
+	}
+	nc.Lock()
+	defer nc.Unlock()
+	if nc.ttl <= 0 {
+		return
+	}
+	tracer().Debugf("negative cache remembers miss for %s", normalizedName)
+	nc.misses[normalizedName] = time.Now().Add(nc.ttl)
+}
+
+// Contains returns true if a lookup for normalizedName failed recently.
+// Expired entries are dropped.
+func (nc *NegativeCache) Contains(normalizedName string) bool {
+	if nc == nil {
+		return false
+	}
+	nc.Lock()
+	defer nc.Unlock()
+	expiry, ok := nc.misses[normalizedName]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry) {
+		delete(nc.misses, normalizedName)
+		return false
+	}
+	return true
+}
+
+// Clear forgets all remembered misses.
+func (nc *NegativeCache) Clear() {
+	if nc == nil {
+		return
+	}
+	nc.Lock()
+	defer nc.Unlock()
+	nc.misses = make(map[string]time.Time)
+}
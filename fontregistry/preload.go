@@ -0,0 +1,86 @@
+package fontregistry
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/npillmayer/fontfind"
+)
+
+// PreloadDir walks the directory root of fsys and stores every font found into
+// the registry, e.g. for servers to have fonts ready before the first request.
+// Fonts are stored under the normalized name of the family, style, weight and width
+// read from the font binary (see fontfind.ReadMetadata), so that they are found by
+// lookups for their descriptor. Every face of a font collection is stored.
+//
+// Files which are not fonts or cannot be read are skipped. Fonts already contained
+// in the registry are not overridden and are not counted. PreloadDir returns the
+// number of fonts stored and an error only if root cannot be walked.
+func (fr *Registry) PreloadDir(fsys fs.FS, root string) (int, error) {
+	n := 0
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			tracer().Infof("registry preload: skipping %s: %v", p, err)
+			return nil
+		}
+		if d.IsDir() || !isFontFile(p) {
+			return nil
+		}
+		n += fr.preloadFontFile(fsys, p)
+		return nil
+	})
+	return n, err
+}
+
+// preloadFontFile stores the faces of font file p into the registry and returns the
+// number of faces stored.
+func (fr *Registry) preloadFontFile(fsys fs.FS, p string) int {
+	n := 0
+	collection := isCollection(p)
+	for index := 0; ; index++ {
+		f := fontfind.ScalableFont{Name: path.Base(p), FaceIndex: index}
+		f.SetFS(fsys, p)
+		md, err := fontfind.ReadMetadata(f)
+		if err != nil {
+			if index == 0 {
+				tracer().Infof("registry preload: skipping %s: %v", p, err)
+			}
+			break
+		}
+		if md.Family == "" {
+			tracer().Infof("registry preload: skipping %s: font has no family name", p)
+		} else {
+			f.Style, f.Weight = md.Style, md.Weight
+			if fr.storeFont(NormalizeFontnameWithWidth(md.Family, md.Style, md.Weight, md.Width), f) {
+				n++
+			}
+		}
+		if !collection {
+			break
+		}
+	}
+	return n
+}
+
+// isFontFile returns true if p has the extension of a font file package sfnt is
+// able to parse.
+func isFontFile(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".ttf", ".otf", ".ttc", ".otc":
+		return true
+	}
+	return false
+}
+
+// isCollection returns true if p has the extension of a font collection.
+func isCollection(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".ttc", ".otc":
+		return true
+	}
+	return false
+}
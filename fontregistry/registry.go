@@ -1,19 +1,32 @@
 package fontregistry
 
 import (
+	"container/list"
 	"fmt"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/npillmayer/fontfind"
 	"github.com/npillmayer/schuko/tracing"
 	xfont "golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
 )
 
 // Registry caches resolved scalable fonts by normalized name.
 type Registry struct {
-	sync.Mutex
-	fonts map[string]fontfind.ScalableFont
+	sync.RWMutex
+	fonts   map[string]fontfind.ScalableFont
+	limit   int                      // maximum number of fonts, excluding fallback; 0 for no limit
+	order   *list.List               // of normalized names, most recently used first
+	recency map[string]*list.Element // position of normalized names in order
+	// typecases caches scaled fonts, by normalized name and size key
+	typecases map[string]map[string]*fontfind.Typecase
+	// fallbackVersion is the version of the application fallback the cached
+	// fallback font has been taken from, see SetFallback
+	fallbackVersion uint64
+	onStore         []func(string, fontfind.ScalableFont) // see OnStore
 }
 
 var globalFontRegistry *Registry
@@ -31,29 +44,94 @@ func GlobalRegistry() *Registry {
 // New creates an empty font registry.
 func New() *Registry {
 	fr := &Registry{
-		fonts: make(map[string]fontfind.ScalableFont),
+		fonts:     make(map[string]fontfind.ScalableFont),
+		order:     list.New(),
+		recency:   make(map[string]*list.Element),
+		typecases: make(map[string]map[string]*fontfind.Typecase),
 	}
 	return fr
 }
 
+// NewRegistryWithLimit creates an empty font registry holding at most n fonts.
+// If storing a font exceeds the limit, the least recently used font is evicted.
+// The cached fallback font does not count against the limit and is never evicted.
+// A limit of zero or less means no limit.
+func NewRegistryWithLimit(n int) *Registry {
+	fr := New()
+	fr.limit = n
+	return fr
+}
+
 const fallbackFontKey = "fallback"
 
 // StoreFont pushes a font into the registry if it isn't contained yet.
 //
 // The font will be stored using the normalized font name as a key. If this
-// key is already associated with a font, that font will not be overridden.
+// key is already associated with a font, that font will not be overridden
+// (see StoreFontForce).
 func (fr *Registry) StoreFont(normalizedName string, f fontfind.ScalableFont) {
+	fr.storeFont(normalizedName, f)
+}
+
+// storeFont is StoreFont, returning true if f has been stored.
+func (fr *Registry) storeFont(normalizedName string, f fontfind.ScalableFont) bool {
 	if f.Name == "" {
 		tracer().Errorf("registry cannot store null font")
-		return
+		return false
 	}
 	fr.Lock()
-	defer fr.Unlock()
 	//style, weight := GuessStyleAndWeight(f.Fontname)
 	//fname := NormalizeFontname(f.Fontname, style, weight)
-	if _, ok := fr.fonts[normalizedName]; !ok {
+	if _, ok := fr.fonts[normalizedName]; ok {
+		fr.Unlock()
+		return false
+	}
+	tracer().Debugf("registry stores font %s as %s", f.Name, normalizedName)
+	fr.fonts[normalizedName] = f
+	fr.touch(normalizedName)
+	fr.evict()
+	hooks := fr.onStore
+	fr.Unlock()
+	notifyStore(hooks, normalizedName, f)
+	return true
+}
+
+// StoreFontForce pushes a font into the registry, replacing a font already stored
+// under normalizedName, e.g. for reloading a font file which changed on disk.
+// Typecases of a replaced font are dropped.
+func (fr *Registry) StoreFontForce(normalizedName string, f fontfind.ScalableFont) {
+	if f.Name == "" {
+		tracer().Errorf("registry cannot store null font")
+		return
+	}
+	fr.Lock()
+	if _, ok := fr.fonts[normalizedName]; ok {
+		tracer().Debugf("registry replaces font %s by %s", normalizedName, f.Name)
+		delete(fr.typecases, normalizedName)
+	} else {
 		tracer().Debugf("registry stores font %s as %s", f.Name, normalizedName)
-		fr.fonts[normalizedName] = f
+	}
+	fr.fonts[normalizedName] = f
+	fr.touch(normalizedName)
+	fr.evict()
+	hooks := fr.onStore
+	fr.Unlock()
+	notifyStore(hooks, normalizedName, f)
+}
+
+// OnStore subscribes hook to fonts being stored into the registry by StoreFont,
+// StoreFontForce and the functions using them, e.g. for metering font usage.
+// Hooks are called synchronously after the registry has been unlocked, so they may
+// use the registry themselves.
+func (fr *Registry) OnStore(hook func(name string, f fontfind.ScalableFont)) {
+	fr.Lock()
+	defer fr.Unlock()
+	fr.onStore = append(fr.onStore, hook)
+}
+
+func notifyStore(hooks []func(string, fontfind.ScalableFont), name string, f fontfind.ScalableFont) {
+	for _, hook := range hooks {
+		hook(name, f)
 	}
 }
 
@@ -66,6 +144,7 @@ func (fr *Registry) GetFont(normalizedName string) (fontfind.ScalableFont, error
 	tracer().Debugf("registry searches for font %s", normalizedName)
 	fr.Lock()
 	if t, ok := fr.fonts[normalizedName]; ok {
+		fr.touch(normalizedName)
 		fr.Unlock()
 		tracer().Infof("registry found font %s", normalizedName)
 		return t, nil
@@ -81,57 +160,280 @@ func (fr *Registry) GetFont(normalizedName string) (fontfind.ScalableFont, error
 }
 
 // FallbackFont returns the default fallback font from registry cache.
-// If absent, it will load and cache the packaged fallback under key "fallback".
+// If absent, it will load and cache the fallback under key "fallback". This is
+// the application fallback font, if one has been set with SetFallback, or the
+// packaged fallback font otherwise. The fallback font is the same for every script;
+// for a fallback by script subset, see locator fallbackfont.FindOrDefault.
 func (fr *Registry) FallbackFont() (fontfind.ScalableFont, error) {
+	custom, isCustom, version := applicationFallback()
 	fr.Lock()
-	if t, ok := fr.fonts[fallbackFontKey]; ok {
+	if t, ok := fr.fonts[fallbackFontKey]; ok && fr.fallbackVersion == version {
 		fr.Unlock()
 		return t, nil
 	}
 	fr.Unlock()
 
-	f := fontfind.FallbackFont()
+	f := custom
+	if !isCustom {
+		f = fontfind.FallbackFont()
+	}
 	fr.Lock()
 	defer fr.Unlock()
 	// Another goroutine may have inserted fallback while we were loading.
-	if t, ok := fr.fonts[fallbackFontKey]; ok {
+	if t, ok := fr.fonts[fallbackFontKey]; ok && fr.fallbackVersion == version {
 		return t, nil
 	}
-	tracer().Infof("font registry caches fallback font %s", fallbackFontKey)
+	tracer().Infof("font registry caches fallback font %s", f.Name)
 	fr.fonts[fallbackFontKey] = f
+	fr.fallbackVersion = version
+	delete(fr.typecases, fallbackFontKey)
 	return f, nil
 }
 
+// appFallback is the application fallback font, shared by all registries.
+var appFallback struct {
+	sync.RWMutex
+	font    fontfind.ScalableFont
+	set     bool
+	version uint64 // incremented with every change
+}
+
+// SetFallback sets an application fallback font, which all registries will
+// use instead of the packaged fallback font. This is useful for applications
+// shipping their own (e.g., brand) font. Registries which already cached a
+// fallback font will switch to f.
+//
+// Setting fontfind.NullFont restores the packaged fallback font.
+func SetFallback(f fontfind.ScalableFont) {
+	appFallback.Lock()
+	defer appFallback.Unlock()
+	appFallback.font = f
+	appFallback.set = f.Name != ""
+	appFallback.version++
+	tracer().Infof("application fallback font set to %q", f.Name)
+}
+
+// ApplicationFallback returns the application fallback font set with SetFallback.
+// ok is false if none is set.
+func ApplicationFallback() (f fontfind.ScalableFont, ok bool) {
+	f, ok, _ = applicationFallback()
+	return f, ok
+}
+
+func applicationFallback() (fontfind.ScalableFont, bool, uint64) {
+	appFallback.RLock()
+	defer appFallback.RUnlock()
+	return appFallback.font, appFallback.set, appFallback.version
+}
+
+// Remove drops the font stored under normalizedName from the registry.
+// It returns true if the registry contained such a font.
+//
+// Removing key "fallback" drops the cached fallback font; it will be re-loaded on
+// demand.
+func (fr *Registry) Remove(normalizedName string) bool {
+	fr.Lock()
+	defer fr.Unlock()
+	if _, ok := fr.fonts[normalizedName]; !ok {
+		return false
+	}
+	tracer().Debugf("registry removes font %s", normalizedName)
+	delete(fr.fonts, normalizedName)
+	delete(fr.typecases, normalizedName)
+	fr.forget(normalizedName)
+	return true
+}
+
+// Clear drops all fonts from the registry, except the cached fallback font.
+// To drop the fallback font as well, call Remove("fallback").
+func (fr *Registry) Clear() {
+	fr.Lock()
+	defer fr.Unlock()
+	fallback, hasFallback := fr.fonts[fallbackFontKey]
+	fr.fonts = make(map[string]fontfind.ScalableFont)
+	fr.order.Init()
+	fr.recency = make(map[string]*list.Element)
+	fr.typecases = make(map[string]map[string]*fontfind.Typecase)
+	if hasFallback {
+		fr.fonts[fallbackFontKey] = fallback
+	}
+}
+
+// GetTypecase returns the font stored under normalizedName, scaled to point-size
+// ptSize for output resolution dpi. Typecases are cached, so a font is parsed and
+// scaled only once per size and resolution.
+//
+// If the registry does not contain a font for normalizedName, an error is returned.
+func (fr *Registry) GetTypecase(normalizedName string, ptSize fixed.Int26_6, dpi float32) (
+	*fontfind.Typecase, error) {
+	//
+	key := appendSize("", ptSize, dpi)
+	fr.RLock()
+	tc, ok := fr.typecases[normalizedName][key]
+	fr.RUnlock()
+	if ok {
+		return tc, nil
+	}
+	f, err := fr.GetFont(normalizedName)
+	if err != nil {
+		return nil, err
+	}
+	if tc, err = fontfind.NewTypecase(f, ptSize, dpi); err != nil {
+		return nil, fmt.Errorf("cannot scale font %s: %w", normalizedName, err)
+	}
+	fr.Lock()
+	defer fr.Unlock()
+	if _, ok := fr.fonts[normalizedName]; !ok { // removed in the meantime
+		return tc, nil
+	}
+	if fr.typecases[normalizedName] == nil {
+		fr.typecases[normalizedName] = make(map[string]*fontfind.Typecase)
+	}
+	if cached, ok := fr.typecases[normalizedName][key]; ok {
+		return cached, nil
+	}
+	tracer().Debugf("registry stores typecase %s", appendSize(normalizedName, ptSize, dpi))
+	fr.typecases[normalizedName][key] = tc
+	return tc, nil
+}
+
+// appendSize appends point-size and resolution to a font name, e.g.
+// "helvetica-bold" → "helvetica-bold-12.00pt-72dpi".
+func appendSize(fname string, ptSize fixed.Int26_6, dpi float32) string {
+	pt := float64(ptSize) / 64
+	if fname == "" {
+		return fmt.Sprintf("%.2fpt-%gdpi", pt, dpi)
+	}
+	return fmt.Sprintf("%s-%.2fpt-%gdpi", fname, pt, dpi)
+}
+
+// touch marks a font as most recently used. Expects the lock to be held.
+func (fr *Registry) touch(normalizedName string) {
+	if normalizedName == fallbackFontKey {
+		return
+	}
+	if elem, ok := fr.recency[normalizedName]; ok {
+		fr.order.MoveToFront(elem)
+		return
+	}
+	fr.recency[normalizedName] = fr.order.PushFront(normalizedName)
+}
+
+// forget drops a font from the recency list. Expects the lock to be held.
+func (fr *Registry) forget(normalizedName string) {
+	if elem, ok := fr.recency[normalizedName]; ok {
+		fr.order.Remove(elem)
+		delete(fr.recency, normalizedName)
+	}
+}
+
+// evict drops least recently used fonts until the registry is within its limit.
+// Expects the lock to be held.
+func (fr *Registry) evict() {
+	for fr.limit > 0 && fr.order.Len() > fr.limit {
+		name := fr.order.Back().Value.(string)
+		tracer().Debugf("registry evicts font %s", name)
+		delete(fr.fonts, name)
+		delete(fr.typecases, name)
+		fr.forget(name)
+	}
+}
+
+// RegisteredFont describes a font known to a registry.
+type RegisteredFont struct {
+	NormalizedName string          // registry key
+	Name           string          // display name of the font
+	Path           string          // path of the font file within its file system
+	Source         fontfind.Source // where the font has been located
+}
+
+// List returns a snapshot of the fonts known to the registry, sorted by
+// normalized name.
+func (fr *Registry) List() []RegisteredFont {
+	fr.RLock()
+	fonts := make([]RegisteredFont, 0, len(fr.fonts))
+	for k, f := range fr.fonts {
+		fonts = append(fonts, RegisteredFont{
+			NormalizedName: k,
+			Name:           f.Name,
+			Path:           f.Path(),
+			Source:         f.Source,
+		})
+	}
+	fr.RUnlock()
+	sort.Slice(fonts, func(i, j int) bool {
+		return fonts[i].NormalizedName < fonts[j].NormalizedName
+	})
+	return fonts
+}
+
 // LogFontList is a helper function to dump the list of fonts known to a
 // registry to the tracer (log-level Info).
 func (fr *Registry) LogFontList(tracer tracing.Trace) {
+	fonts := fr.List()
 	level := tracer.GetTraceLevel()
 	tracer.SetTraceLevel(tracing.LevelInfo)
 	tracer.Infof("--- registered fonts ---")
-	for k, v := range fr.fonts {
-		tracer.Infof("typeface [%s] = %s @ %v", k, v.Name, v.Path)
+	for _, f := range fonts {
+		tracer.Infof("typeface [%s] = %s @ %v", f.NormalizedName, f.Name, f.Path)
 	}
 	tracer.Infof("------------------------")
 	tracer.SetTraceLevel(level)
 }
 
 // NormalizeFontname returns a normalized cache key for a font descriptor.
+//
+// Keys are the lower-case name, with blanks replaced by '_' and a font file
+// extension (e.g. ".ttf") removed, followed by a key for the style ("-italic" or
+// "-oblique") and for every weight but regular (e.g. "-semibold", see weightKeys).
+// Distinct variants of a family therefore get distinct keys, and equal variants
+// the same key regardless of how the name is spelled.
 func NormalizeFontname(fname string, style xfont.Style, weight xfont.Weight) string {
-	fname = strings.TrimSpace(fname)
+	return NormalizeFontnameWithWidth(fname, style, weight, xfont.StretchNormal)
+}
+
+// NormalizeFontnameWithWidth returns a normalized cache key for a font descriptor,
+// including the font's width. Keys for normal width equal those of NormalizeFontname.
+func NormalizeFontnameWithWidth(fname string, style xfont.Style, weight xfont.Weight,
+	width xfont.Stretch) string {
+	//
+	fname = strings.ToLower(strings.TrimSpace(fname))
 	fname = strings.ReplaceAll(fname, " ", "_")
-	if dot := strings.LastIndex(fname, "."); dot > 0 {
-		fname = fname[:dot]
+	if ext := path.Ext(fname); fontExtensions[ext] && len(ext) < len(fname) {
+		fname = strings.TrimSuffix(fname, ext)
 	}
-	fname = strings.ToLower(fname)
 	switch style {
-	case xfont.StyleItalic, xfont.StyleOblique:
+	case xfont.StyleItalic:
 		fname += "-italic"
+	case xfont.StyleOblique:
+		fname += "-oblique"
 	}
-	switch weight {
-	case xfont.WeightLight, xfont.WeightExtraLight:
-		fname += "-light"
-	case xfont.WeightBold, xfont.WeightExtraBold, xfont.WeightSemiBold:
-		fname += "-bold"
+	if w, ok := weightKeys[weight]; ok {
+		fname += "-" + w
+	} else if weight != xfont.WeightNormal {
+		fname += fmt.Sprintf("-w%d", weight) // off the CSS weight ladder
+	}
+	if w := fontfind.WidthName(width); w != "" {
+		fname += "-" + w
 	}
 	return fname
 }
+
+// fontExtensions are the file extensions stripped from normalized font names. Other
+// dots belong to the name, e.g. in "Font 1.5".
+var fontExtensions = map[string]bool{
+	".ttf": true, ".otf": true, ".ttc": true, ".otc": true, ".woff": true, ".woff2": true,
+}
+
+// weightKeys are the weight parts of normalized font names. Every weight gets a
+// key of its own, so that e.g. Medium and Bold variants of a family do not collide.
+var weightKeys = map[xfont.Weight]string{
+	xfont.WeightThin:       "thin",
+	xfont.WeightExtraLight: "extralight",
+	xfont.WeightLight:      "light",
+	xfont.WeightMedium:     "medium",
+	xfont.WeightSemiBold:   "semibold",
+	xfont.WeightBold:       "bold",
+	xfont.WeightExtraBold:  "extrabold",
+	xfont.WeightBlack:      "black",
+}
@@ -6,9 +6,7 @@ require (
 	github.com/flopp/go-findfont v0.1.0
 	github.com/npillmayer/schuko v0.2.0-alpha.2
 	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
+	golang.org/x/text v0.3.2
 )
 
-require (
-	github.com/davecgh/go-spew v1.1.1 // indirect
-	golang.org/x/text v0.3.2 // indirect
-)
+require github.com/davecgh/go-spew v1.1.1 // indirect
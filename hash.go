@@ -0,0 +1,90 @@
+package fontfind
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// maxContentHashes is the number of content hashes kept in memory. Hashes are
+// small, but fonts loaded from memory might create an unbounded number of keys.
+const maxContentHashes = 1024
+
+type hashEntry struct {
+	mtime time.Time
+	hash  string
+}
+
+// contentHashes remembers content hashes of font files, keyed like parsed fonts
+// (with face index 0), shared by all ScalableFonts of a process.
+var contentHashes = struct {
+	sync.Mutex
+	entries map[sfntKey]hashEntry
+}{
+	entries: make(map[sfntKey]hashEntry),
+}
+
+// ContentHash returns the hex-encoded SHA-256 checksum of the font data of f. Fonts
+// with identical data have the same hash, regardless of where they have been located.
+//
+// Hashes are remembered process-wide, keyed by file-system, path and modification
+// time of the font file, so repeated calls do not read the font data again. Fonts in
+// file-systems which are not comparable, e.g. fstest.MapFS, are hashed on every call.
+func (f ScalableFont) ContentHash() (string, error) {
+	var key sfntKey
+	var mtime time.Time
+	fsys, cacheable := fsIdentity(f.fileSystem)
+	if cacheable {
+		key = sfntKey{fsys: fsys, path: f.path}
+		if fi, err := fs.Stat(f.fileSystem, f.path); err == nil {
+			mtime = fi.ModTime()
+		}
+		contentHashes.Lock()
+		entry, ok := contentHashes.entries[key]
+		contentHashes.Unlock()
+		if ok && entry.mtime.Equal(mtime) {
+			return entry.hash, nil
+		}
+	}
+	h := sha256.New()
+	if _, err := f.WriteTo(h); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if cacheable {
+		contentHashes.Lock()
+		if len(contentHashes.entries) >= maxContentHashes {
+			clear(contentHashes.entries) // cheap to re-compute
+		}
+		contentHashes.entries[key] = hashEntry{mtime: mtime, hash: hash}
+		contentHashes.Unlock()
+	}
+	return hash, nil
+}
+
+// Equal returns true if f and other denote the same face, i.e. the same face of
+// identical font data, and the same named instance of a variable font. Name, style,
+// weight and source of the fonts are not compared, so a font from the host system
+// equals a copy of it located elsewhere. Fonts whose data cannot be read are not
+// equal to any font.
+func (f ScalableFont) Equal(other ScalableFont) bool {
+	if f.FaceIndex != other.FaceIndex || instanceName(f) != instanceName(other) {
+		return false
+	}
+	h1, err := f.ContentHash()
+	if err != nil {
+		return false
+	}
+	h2, err := other.ContentHash()
+	return err == nil && h1 == h2
+}
+
+// instanceName returns the name of the selected instance of a variable font f, or "".
+func instanceName(f ScalableFont) string {
+	if f.Instance == nil {
+		return ""
+	}
+	return f.Instance.Name
+}
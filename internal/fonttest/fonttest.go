@@ -0,0 +1,29 @@
+/*
+Package fonttest has helpers for the tests of package fontfind and its sub-packages.
+*/
+package fonttest
+
+import "encoding/binary"
+
+// MakeCollection packs single SFNT fonts into a font collection (TTC).
+func MakeCollection(fonts ...[]byte) []byte {
+	header := 12 + 4*len(fonts)
+	coll := make([]byte, header)
+	copy(coll, "ttcf")
+	binary.BigEndian.PutUint32(coll[4:], 0x00010000)
+	binary.BigEndian.PutUint32(coll[8:], uint32(len(fonts)))
+	for i, data := range fonts {
+		for len(coll)%4 != 0 {
+			coll = append(coll, 0)
+		}
+		offset := uint32(len(coll))
+		binary.BigEndian.PutUint32(coll[12+4*i:], offset)
+		coll = append(coll, data...)
+		numTables := int(binary.BigEndian.Uint16(data[4:]))
+		for j := 0; j < numTables; j++ {
+			rec := int(offset) + 12 + 16*j + 8
+			binary.BigEndian.PutUint32(coll[rec:], binary.BigEndian.Uint32(coll[rec:])+offset)
+		}
+	}
+	return coll
+}
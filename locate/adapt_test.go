@@ -0,0 +1,29 @@
+package locate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/npillmayer/fontfind"
+)
+
+func TestAdaptLocatorHonorsCancelledContext(t *testing.T) {
+	called := false
+	locator := func(_ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		called = true
+		return fontfind.FallbackFont(), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := adaptLocator(locator)(ctx, fontfind.Descriptor{Pattern: "zz-adapt-probe"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if called {
+		t.Fatal("expected wrapped locator not to be called for cancelled context")
+	}
+	if _, err = adaptLocator(locator)(context.Background(), fontfind.Descriptor{}); err != nil || !called {
+		t.Fatalf("expected wrapped locator to be called for live context, err = %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package locate
+
+import (
+	"context"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/fontregistry"
+)
+
+// FontResult is the outcome of resolving a single descriptor.
+type FontResult struct {
+	Font fontfind.ScalableFont
+	Err  error
+	// Confidence tells how well Font matches the descriptor, as judged by the locator
+	// which found it (see fontfind.ScalableFont.Confidence). It is NoConfidence for a
+	// fallback font returned on a miss.
+	Confidence fontfind.MatchConfidence
+}
+
+// BatchPromise runs searching for a batch of fonts asynchronously in the background.
+// Fonts blocks until all fonts of the batch are resolved, and FontsWithContext allows
+// waiting with caller-controlled cancellation and deadlines.
+//
+// Results are returned in the order of the requested descriptors. Errors are
+// reported per descriptor; a failing lookup does not affect the rest of the batch.
+type BatchPromise interface {
+	Fonts() []FontResult
+	FontsWithContext(ctx context.Context) ([]FontResult, error)
+}
+
+type batchLoader struct {
+	await func(ctx context.Context) ([]FontResult, error)
+}
+
+func (loader batchLoader) Fonts() []FontResult {
+	results, _ := loader.FontsWithContext(context.Background())
+	return results
+}
+
+func (loader batchLoader) FontsWithContext(ctx context.Context) ([]FontResult, error) {
+	return loader.await(ctx)
+}
+
+// ResolveFontLocBatch resolves a batch of descriptors concurrently, using the given
+// resolver chain for each of them (see ResolveFontLoc). This is useful for resolving
+// the variants of a font family in one go. Resolvers are shared by all lookups of
+// the batch, thus set-up work of a resolver (e.g., loading the Google Fonts
+// directory) is done once only.
+func ResolveFontLocBatch(descs []fontfind.Descriptor, resolvers ...FontLocator) BatchPromise {
+	return adaptedPipeline(resolvers).ResolveBatch(context.Background(), descs)
+}
+
+// ResolveFontLocBatchWithContext is the context-aware variant of ResolveFontLocBatch.
+// Cancelling ctx cancels all lookups of the batch which are not yet completed.
+func ResolveFontLocBatchWithContext(ctx context.Context, descs []fontfind.Descriptor,
+	resolvers ...FontLocatorWithContext) BatchPromise {
+	//
+	return defaultPipeline(resolvers...).ResolveBatch(ctx, descs)
+}
+
+// ResolveBatch resolves a batch of font requests asynchronously using this pipeline's
+// registry and resolvers. The lookups of the batch run concurrently.
+func (pipeline ResolverPipeline) ResolveBatch(ctx context.Context, descs []fontfind.Descriptor) BatchPromise {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if pipeline.registry == nil {
+		pipeline.registry = fontregistry.GlobalRegistry()
+	}
+	results := make([]FontResult, len(descs))
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for i, desc := range descs {
+			wg.Add(1)
+			go func(i int, desc fontfind.Descriptor) {
+				defer wg.Done()
+				r := searchScalableFont(ctx, pipeline, desc)
+				results[i] = r.result()
+			}(i, desc)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	loader := batchLoader{}
+	loader.await = func(waitCtx context.Context) ([]FontResult, error) {
+		select {
+		case <-waitCtx.Done():
+			return nil, waitCtx.Err()
+		case <-done:
+			return results, nil
+		}
+	}
+	return loader
+}
@@ -0,0 +1,73 @@
+package locate
+
+import (
+	"context"
+
+	"github.com/npillmayer/fontfind"
+)
+
+// LocationReport tells where a font would be resolved from, see LocateFont.
+type LocationReport struct {
+	Resolver int                   // position of the matching resolver in the chain, or -1
+	Font     fontfind.ScalableFont // font located; a font still to be downloaded has no data
+	URL      string                // URL the font would be downloaded from, if not yet cached
+}
+
+// LocateFont tells whether and where a font for desc would be resolved, without
+// downloading or reading it, e.g. for tooling auditing the availability of fonts.
+// Resolvers are asked in the given order, like ResolveFontLoc does, but neither the
+// registry nor the fallback font are consulted, and glyph coverage of desc.Sample is
+// not checked. Resolvers are called with a context for which DryRun is true.
+//
+// The report names the first resolver which located a font. For fonts which would
+// have to be downloaded, report.URL tells the file to download, and report.Font
+// has no font data. Resolvers which ignore DryRun, e.g. FontLocators adapted to
+// a context, locate fonts as usual, downloading them if necessary. For the standard
+// chain of locators, pass resolvers.DefaultWithContext, not resolvers.Default.
+func LocateFont(ctx context.Context, desc fontfind.Descriptor, resolvers ...FontLocatorWithContext) (
+	LocationReport, error) {
+	//
+	report := LocationReport{Resolver: -1, Font: fontfind.NullFont}
+	if err := desc.Validate(); err != nil {
+		return report, err
+	}
+	var errs []error
+	for i, resolver := range resolvers {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		run := &dryRun{}
+		f, err := callSafely(context.WithValue(ctx, dryRunKey{}, run), resolver, desc)
+		if err == nil {
+			report.Resolver, report.Font, report.URL = i, f, run.url
+			return report, nil
+		} else if ctxErr := ctx.Err(); ctxErr != nil {
+			return report, ctxErr
+		}
+		errs = append(errs, err)
+	}
+	return report, resolversFailed(registryKey(desc), errs)
+}
+
+type dryRunKey struct{}
+
+// dryRun collects the findings of a resolver during a dry run.
+type dryRun struct {
+	url string
+}
+
+// DryRun returns true if ctx belongs to a dry run of LocateFont. Context-aware
+// resolvers should then locate fonts without downloading them, and tell the URL
+// they would download from with ReportDownload.
+func DryRun(ctx context.Context) bool {
+	_, ok := ctx.Value(dryRunKey{}).(*dryRun)
+	return ok
+}
+
+// ReportDownload tells LocateFont the URL a font would be downloaded from. It does
+// nothing if ctx does not belong to a dry run.
+func ReportDownload(ctx context.Context, url string) {
+	if run, ok := ctx.Value(dryRunKey{}).(*dryRun); ok {
+		run.url = url
+	}
+}
@@ -0,0 +1,23 @@
+package locate
+
+import "errors"
+
+// Errors of font resolution. Resolvers wrap them, so that applications may tell
+// the kind of failure with errors.Is, e.g. to ask the user to configure an API key
+// instead of retrying later.
+var (
+	// ErrFontNotFound is returned (possibly wrapped) if no resolver could locate a font.
+	ErrFontNotFound = errors.New("font not found")
+	// ErrNetworkFailure is wrapped by errors of requests to a font service, e.g.
+	// connection failures or unexpected HTTP responses.
+	ErrNetworkFailure = errors.New("network failure")
+	// ErrMissingAPIKey is wrapped by errors of font services which require an API key
+	// which has not been configured.
+	ErrMissingAPIKey = errors.New("missing API key")
+	// ErrCacheFailure is wrapped by errors of reading or writing the font cache.
+	ErrCacheFailure = errors.New("font cache failure")
+	// ErrResolverTimeout is wrapped by errors of resolvers which exceeded their own
+	// timeout, see WithTimeout. Resolutions failing for a timeout are not remembered
+	// as misses.
+	ErrResolverTimeout = errors.New("font resolver timed out")
+)
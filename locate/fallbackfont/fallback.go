@@ -2,9 +2,13 @@ package fallbackfont
 
 import (
 	"embed"
-	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/fontregistry"
 	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/schuko/tracing"
 	"golang.org/x/image/font"
@@ -21,55 +25,165 @@ var packaged embed.FS
 const defaultFallbackFilename = "Go-Regular.otf"
 
 // Find creates a locator that resolves fonts from the embedded fallback set.
+// If no embedded font matches a descriptor, the locator returns an error.
+// Embedded fonts match with any confidence, unless the descriptor requires a
+// minimum confidence.
 func Find() locate.FontLocator {
 	return func(descr fontfind.Descriptor) (fontfind.ScalableFont, error) {
-		pattern := descr.Pattern
-		style := descr.Style
-		weight := descr.Weight
-		return FindFallbackFont(pattern, style, weight)
+		return findFallbackFont(descr)
 	}
 }
 
-// Default returns the default packaged fallback font.
+// FindOrDefault creates a locator that resolves fonts from the embedded fallback set.
+// If no embedded font matches a descriptor, the locator returns the packaged
+// fallback font for the descriptor's subset (see FindFallbackFontForScript) or,
+// if there is none, the default packaged font. Use this as an explicit last resort
+// in a chain of locators.
+func FindOrDefault() locate.FontLocator {
+	return func(descr fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		f, err := FindFallbackFont(descr.Pattern, descr.Style, descr.Weight)
+		if err == nil {
+			return f, nil
+		}
+		if script, ok := ScriptOfSubset(descr.Subset); ok {
+			if f, err := FindFallbackFontForScript(script); err == nil {
+				return f, nil
+			}
+		}
+		tracer().Debugf("no embedded font matches %s, using default", descr.Pattern)
+		return Default()
+	}
+}
+
+// Default returns the default fallback font. This is the application fallback font
+// (see SetDefault), if any, or the default packaged font otherwise.
 func Default() (fontfind.ScalableFont, error) {
-	// Ensure packaged default exists in embedded resources.
-	if _, err := packaged.Open("packaged/" + defaultFallbackFilename); err != nil {
+	if f, ok := fontregistry.ApplicationFallback(); ok {
+		return f, nil
+	}
+	return packagedFont(defaultFallbackFilename)
+}
+
+// SetDefault sets an application font to be used as the default fallback font,
+// e.g. a brand font shipped with the application. It is the same as
+// fontregistry.SetFallback: the font is the fallback font of the font registries
+// as well.
+//
+// Setting fontfind.NullFont restores the default packaged font.
+func SetDefault(f fontfind.ScalableFont) {
+	fontregistry.SetFallback(f)
+}
+
+// packagedFont returns the embedded font with file name fname.
+func packagedFont(fname string) (fontfind.ScalableFont, error) {
+	// Ensure packaged font exists in embedded resources.
+	path := "packaged/" + fname
+	if _, err := packaged.Open(path); err != nil {
 		return fontfind.NullFont, err
 	}
-	path := "packaged/" + defaultFallbackFilename
+	style, weight := fontfind.GuessStyleAndWeight(fname)
 	sfnt := fontfind.ScalableFont{
-		Name:   defaultFallbackFilename,
-		Style:  font.StyleNormal,
-		Weight: font.WeightNormal,
+		Name:   fname,
+		Style:  style,
+		Weight: weight,
+		Source: fontfind.SourcePackaged,
 	}
 	sfnt.SetFS(packaged, path)
 	return sfnt, nil
 }
 
-// FindFallbackFont looks up a matching font in embedded fallback resources.
-// If no match exists, it returns the first available packaged font.
+// FindFallbackFont looks up the closest matching font in embedded fallback resources.
+// Embedded fonts are grouped into families, and the variant closest to style and
+// weight is selected (see fontfind.ClosestMatch).
+// If no packaged font matches pattern, an error is returned.
 func FindFallbackFont(pattern string, style font.Style, weight font.Weight) (fontfind.ScalableFont, error) {
-	fonts, _ := packaged.ReadDir("packaged")
-	var fname string // path to embedded font, if any
-	for _, f := range fonts {
+	return findFallbackFont(fontfind.Descriptor{Pattern: pattern, Style: style, Weight: weight})
+}
+
+// findFallbackFont is FindFallbackFont for a descriptor, accepting matches with at
+// least desc.MinConfidence.
+func findFallbackFont(desc fontfind.Descriptor) (fontfind.ScalableFont, error) {
+	fonts, err := packagedFonts(desc.Pattern)
+	if err != nil {
+		return fontfind.NullFont, err
+	}
+	desc.Width = font.StretchNormal // packaged fonts are of normal width
+	match, variant, confidence := desc.ClosestMatch(fonts)
+	if confidence == fontfind.NoConfidence || confidence < desc.MinConfidence {
+		return fontfind.NullFont, fmt.Errorf("%w: no embedded font matches %q", locate.ErrFontNotFound, desc.Pattern)
+	}
+	tracer().Debugf("found embedded font file %s", match.Path)
+	v := fontfind.ParseVariant(variant)
+	// font is packaged embedded font
+	sFont := fontfind.ScalableFont{
+		Name:       match.Path,
+		Style:      v.Style,
+		Weight:     v.Weight,
+		Source:     fontfind.SourcePackaged,
+		Confidence: confidence,
+	}
+	sFont.SetFS(packaged, "packaged/"+match.Path)
+	return sFont, nil
+}
+
+// packagedFonts lists the embedded fonts, one entry per font file. Family names
+// are derived from the file names, with style and weight suffixes removed.
+// Fonts with a family name equal to pattern are listed first.
+func packagedFonts(pattern string) ([]fontfind.FontVariantsLocation, error) {
+	files, err := packaged.ReadDir("packaged")
+	if err != nil {
+		return nil, err
+	}
+	var exact, other []fontfind.FontVariantsLocation
+	for _, f := range files {
 		if f.IsDir() {
 			continue
 		}
-		if fontfind.Matches(f.Name(), pattern, style, weight) {
-			tracer().Debugf("found embedded font file %s", f.Name())
-			fname = f.Name()
-			break
+		loc := fontfind.FontVariantsLocation{
+			Family:   familyFromFilename(f.Name()),
+			Variants: []string{variantFromFilename(f.Name())},
+			Path:     f.Name(),
+		}
+		if strings.EqualFold(loc.Family, pattern) {
+			exact = append(exact, loc)
+		} else {
+			other = append(other, loc)
 		}
-		fname = f.Name()
 	}
-	var sFont fontfind.ScalableFont
-	if fname == "" {
-		return fontfind.NullFont, errors.New("font not found")
+	return append(exact, other...), nil
+}
+
+var styleSuffixes = map[string]bool{
+	"regular": true, "r": true, "normal": true,
+	"bold": true, "b": true, "italic": true, "i": true,
+	"light": true, "xlight": true, "xbold": true, "black": true,
+}
+
+// familyFromFilename strips extension and style/weight suffixes from a font file name,
+// e.g., "Go-Bold-Italic.otf" → "Go", "Go-Mono.otf" → "Go Mono".
+func familyFromFilename(fname string) string {
+	fname = strings.TrimSuffix(fname, path.Ext(fname))
+	parts := strings.Split(fname, "-")
+	for len(parts) > 1 && styleSuffixes[strings.ToLower(parts[len(parts)-1])] {
+		parts = parts[:len(parts)-1]
 	}
-	// font is packaged embedded font
-	sFont.Name = fname
-	sFont.Style = style
-	sFont.Weight = weight
-	sFont.SetFS(packaged, "packaged/"+fname)
-	return sFont, nil
+	return strings.Join(parts, " ")
+}
+
+// variantFromFilename creates a variant name in the style of Google Fonts
+// ("regular", "italic", "700", "700italic") from a font file name.
+func variantFromFilename(fname string) string {
+	style, weight := fontfind.GuessStyleAndWeight(fname)
+	italic := style == font.StyleItalic || style == font.StyleOblique
+	if weight == font.WeightNormal {
+		if italic {
+			return "italic"
+		}
+		return "regular"
+	}
+	variant := strconv.Itoa((int(weight) + 4) * 100)
+	if italic {
+		variant += "italic"
+	}
+	return variant
 }
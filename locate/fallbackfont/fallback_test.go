@@ -0,0 +1,132 @@
+package fallbackfont
+
+import (
+	"testing"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/fontregistry"
+	"github.com/npillmayer/schuko/tracing/gotestingadapter"
+	"golang.org/x/image/font"
+)
+
+func TestFindFallbackFontVariants(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	for _, tc := range []struct {
+		style  font.Style
+		weight font.Weight
+		file   string
+	}{
+		{font.StyleNormal, font.WeightNormal, "Go-Regular.otf"},
+		{font.StyleNormal, font.WeightBold, "Go-Bold.otf"},
+		{font.StyleItalic, font.WeightNormal, "Go-Italic.otf"},
+		{font.StyleItalic, font.WeightBold, "Go-Bold-Italic.otf"},
+	} {
+		f, err := FindFallbackFont("Go", tc.style, tc.weight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name != tc.file {
+			t.Errorf("expected %s for style=%d, weight=%d; got %s", tc.file, tc.style, tc.weight, f.Name)
+		}
+		if _, err = f.ReadFontData(); err != nil {
+			t.Errorf("cannot read embedded font %s: %v", f.Name, err)
+		}
+	}
+}
+
+func TestFindFallbackFontNoMatch(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	files, err := packaged.ReadDir("packaged")
+	if err != nil || len(files) < 2 {
+		t.Fatalf("expected at least two packaged fonts, have %d (%v)", len(files), err)
+	}
+	if f, err := FindFallbackFont("zz-no-such-font", font.StyleNormal, font.WeightNormal); err == nil {
+		t.Errorf("expected error for unmatchable pattern, got %s", f.Name)
+	}
+	if f, err := Find()(fontfind.Descriptor{Pattern: "zz-no-such-font"}); err == nil {
+		t.Errorf("expected locator to report missing font, got %s", f.Name)
+	}
+	f, err := FindOrDefault()(fontfind.Descriptor{Pattern: "zz-no-such-font"})
+	if err != nil || f.Name != defaultFallbackFilename {
+		t.Errorf("expected default font for opt-in locator, got %s (%v)", f.Name, err)
+	}
+	if f, err = FindFallbackFont("Go Mono", font.StyleNormal, font.WeightNormal); err != nil || f.Name != "Go-Mono.otf" {
+		t.Errorf("expected Go-Mono.otf for Go Mono, got %s (%v)", f.Name, err)
+	}
+}
+
+func TestFindFallbackFontMinConfidence(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	desc := fontfind.Descriptor{Pattern: "Gentium", Style: font.StyleItalic}
+	if _, err := Find()(desc); err != nil {
+		t.Errorf("expected lenient lookup to substitute Gentium regular, have %v", err)
+	}
+	desc.MinConfidence = fontfind.HighConfidence
+	if f, err := Find()(desc); err == nil {
+		t.Errorf("expected strict lookup for Gentium italic to fail, got %s", f.Name)
+	}
+	desc.Pattern, desc.Weight = "Go", font.WeightBold
+	if f, err := Find()(desc); err != nil || f.Name != "Go-Bold-Italic.otf" {
+		t.Errorf("expected strict lookup to find Go-Bold-Italic.otf, got %s (%v)", f.Name, err)
+	}
+}
+
+func TestFindFallbackFontForScript(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	for _, subset := range []string{"latin", "greek-ext", "cyrillic"} {
+		script, ok := ScriptOfSubset(subset)
+		if !ok {
+			t.Fatalf("expected script for subset %s", subset)
+		}
+		f, err := FindFallbackFontForScript(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.ReadFontData(); err != nil {
+			t.Errorf("cannot read fallback font %s for script %s: %v", f.Name, script, err)
+		}
+	}
+	arabic, _ := ScriptOfSubset("arabic")
+	if f, err := FindFallbackFontForScript(arabic); err == nil {
+		t.Errorf("expected no packaged fallback for arabic, got %s", f.Name)
+	}
+	for script, fname := range scriptFallbacks { // mapping must refer to packaged fonts only
+		if _, err := packaged.Open("packaged/" + fname); err != nil {
+			t.Errorf("fallback font %s for script %s is not packaged", fname, script)
+		}
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	brand, err := FindFallbackFont("Go Mono", font.StyleNormal, font.WeightNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefault(brand)
+	defer SetDefault(fontfind.NullFont)
+	if f, _ := Default(); f.Name != brand.Name {
+		t.Errorf("expected custom default font, got %s", f.Name)
+	}
+	if f, _ := fontregistry.GlobalRegistry().FallbackFont(); f.Name != brand.Name {
+		t.Errorf("expected registry to use custom default font, got %s", f.Name)
+	}
+	SetDefault(fontfind.NullFont)
+	if f, _ := Default(); f.Name != defaultFallbackFilename {
+		t.Errorf("expected packaged default font after reset, got %s", f.Name)
+	}
+	fontregistry.SetFallback(brand) // one setting for both
+	if f, _ := Default(); f.Name != brand.Name {
+		t.Errorf("expected registry fallback font to be the default font, got %s", f.Name)
+	}
+}
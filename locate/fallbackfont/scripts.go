@@ -0,0 +1,65 @@
+package fallbackfont
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
+	"golang.org/x/text/language"
+)
+
+// scriptFallbacks maps scripts to the packaged font to use as a fallback for
+// text in that script. This is the one place to register new script-specific
+// fallback fonts: add the font file to folder "packaged" and an entry here.
+//
+// Currently only the Go fonts are packaged, which cover Latin, Greek and
+// Cyrillic. Scripts without an entry, e.g. CJK, Arabic or emoji, do not have a
+// packaged fallback.
+var scriptFallbacks = map[language.Script]string{
+	language.MustParseScript("Latn"): defaultFallbackFilename,
+	language.MustParseScript("Grek"): defaultFallbackFilename,
+	language.MustParseScript("Cyrl"): defaultFallbackFilename,
+}
+
+// subsetScripts maps Google Fonts subset names (as used for fontfind.Descriptor.Subset)
+// to scripts.
+var subsetScripts = map[string]string{
+	"latin":               "Latn",
+	"latin-ext":           "Latn",
+	"vietnamese":          "Latn",
+	"greek":               "Grek",
+	"greek-ext":           "Grek",
+	"cyrillic":            "Cyrl",
+	"cyrillic-ext":        "Cyrl",
+	"arabic":              "Arab",
+	"hebrew":              "Hebr",
+	"devanagari":          "Deva",
+	"thai":                "Thai",
+	"chinese-simplified":  "Hans",
+	"chinese-traditional": "Hant",
+	"chinese-hongkong":    "Hant",
+	"japanese":            "Jpan",
+	"korean":              "Kore",
+	"emoji":               "Zsye",
+}
+
+// ScriptOfSubset returns the script of a font subset name, e.g. "cyrillic-ext" → Cyrl.
+func ScriptOfSubset(subset string) (language.Script, bool) {
+	code, ok := subsetScripts[strings.ToLower(subset)]
+	if !ok {
+		return language.Script{}, false
+	}
+	return language.MustParseScript(code), true
+}
+
+// FindFallbackFontForScript returns the packaged fallback font for text in
+// a given script. If no packaged font covers script, an error is returned.
+func FindFallbackFontForScript(script language.Script) (fontfind.ScalableFont, error) {
+	fname, ok := scriptFallbacks[script]
+	if !ok {
+		return fontfind.NullFont, fmt.Errorf("%w: no packaged fallback font for script %s", locate.ErrFontNotFound, script)
+	}
+	tracer().Debugf("packaged fallback font for script %s is %s", script, fname)
+	return packagedFont(fname)
+}
@@ -0,0 +1,83 @@
+package locate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+)
+
+// Concurrent lookups for the same font would each run the resolver chain, possibly
+// downloading the same font twice. Instead, concurrent identical lookups join a
+// single resolution in flight (in the spirit of golang.org/x/sync/singleflight).
+
+// flightKey identifies a resolution. Lookups using different registries or pipelines
+// of different identity (resolvers, strictness) do not share resolutions.
+type flightKey struct {
+	registry FontRegistry
+	pipeline string
+	name     string
+}
+
+// flight is a resolution in progress. Its result fields are written before done
+// is closed.
+type flight struct {
+	done     chan struct{}
+	font     fontfind.ScalableFont
+	position int
+	err      error
+}
+
+// flightGroup coordinates resolutions in flight.
+type flightGroup struct {
+	sync.Mutex
+	flights map[flightKey]*flight
+}
+
+var inFlight = &flightGroup{flights: make(map[flightKey]*flight)}
+
+// resolveOnce calls resolve, unless a resolution for the same registry, pipeline and
+// name is already in flight. In this case it waits for the result of the running resolution.
+// Waiting stops if ctx is done. A result failing with the context error of another
+// caller is not shared: the caller resolves on its own instead.
+//
+// Registries of non-comparable types are never shared.
+func (g *flightGroup) resolveOnce(ctx context.Context, registry FontRegistry, pipeline, name string,
+	resolve func() (fontfind.ScalableFont, int, error)) (fontfind.ScalableFont, int, error) {
+	//
+	if registry == nil || !reflect.TypeOf(registry).Comparable() {
+		return resolve()
+	}
+	key := flightKey{registry: registry, pipeline: pipeline, name: name}
+	g.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.Unlock()
+		tracer().Debugf("joining resolution of %s in flight", name)
+		select {
+		case <-ctx.Done():
+			return fontfind.NullFont, -1, ctx.Err()
+		case <-f.done:
+		}
+		if isContextError(f.err) && ctx.Err() == nil {
+			return resolve()
+		}
+		return f.font, f.position, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.Unlock()
+	defer func() {
+		g.Lock()
+		delete(g.flights, key)
+		g.Unlock()
+		close(f.done)
+	}()
+	f.font, f.position, f.err = resolve()
+	return f.font, f.position, f.err
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,147 @@
+package locate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+	"golang.org/x/image/font"
+)
+
+// FSLocator creates a locator that resolves fonts from the directory root of fsys,
+// e.g. application fonts in an embed.FS or test fonts in an fstest.MapFS.
+//
+// Fonts are identified by the family, style, weight and width read from the font
+// binaries (see fontfind.ReadMetadata), not by their file names, and matched with
+// fontfind.ClosestMatchWithWidth. Every face of a font collection is considered.
+// The directory is scanned on first use of the locator; fonts added to fsys later
+// are not found. Files which are not fonts or cannot be parsed are skipped.
+//
+// Fonts found read their data from fsys (see fontfind.ScalableFont.SetFS). Their
+// Source is fontfind.SourceUnknown, as the origin of fsys is up to the client.
+func FSLocator(fsys fs.FS, root string) FontLocator {
+	var scan struct {
+		once  sync.Once
+		fonts []fontfind.FontVariantsLocation
+		err   error
+	}
+	return func(descr fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		scan.once.Do(func() {
+			scan.fonts, scan.err = scanFSFonts(fsys, root)
+			tracer().Debugf("font folder %s contains %d fonts", root, len(scan.fonts))
+		})
+		if scan.err != nil {
+			return fontfind.NullFont, scan.err
+		}
+		match, variant, confidence := descr.ClosestMatch(scan.fonts)
+		if !fontfind.AcceptMatch(confidence, descr.MinConfidence) {
+			return fontfind.NullFont, fmt.Errorf("%w: no font in %s matches %q",
+				ErrFontNotFound, root, descr.Pattern)
+		}
+		tracer().Debugf("found font %s|%s", match.Path, variant)
+		v := fontfind.ParseVariant(variant)
+		sFont := fontfind.ScalableFont{
+			Name:       path.Base(match.Path),
+			Style:      v.Style,
+			Weight:     v.Weight,
+			FaceIndex:  match.FaceIndex,
+			Confidence: confidence,
+		}
+		sFont.SetFS(fsys, match.Path)
+		return sFont, nil
+	}
+}
+
+// scanFSFonts walks the directory root of fsys and reads the metadata of the font
+// files found. It returns an error only if root cannot be walked.
+func scanFSFonts(fsys fs.FS, root string) ([]fontfind.FontVariantsLocation, error) {
+	var fonts []fontfind.FontVariantsLocation
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil // skip unreadable entries
+		}
+		if d.IsDir() || !isFontFile(p) {
+			return nil
+		}
+		fonts = append(fonts, scanFontFile(fsys, p)...)
+		return nil
+	})
+	return fonts, err
+}
+
+// scanFontFile reads the metadata of all faces of font file p.
+func scanFontFile(fsys fs.FS, p string) []fontfind.FontVariantsLocation {
+	var locs []fontfind.FontVariantsLocation
+	for index := 0; ; index++ {
+		f := fontfind.ScalableFont{Name: path.Base(p), FaceIndex: index}
+		f.SetFS(fsys, p)
+		md, err := fontfind.ReadMetadata(f)
+		if err != nil {
+			if index == 0 {
+				tracer().Debugf("cannot read font %s: %v", p, err)
+			}
+			break
+		}
+		if md.Family != "" {
+			locs = append(locs, fontfind.FontVariantsLocation{
+				Family:    md.Family,
+				Variants:  []string{variantName(md)},
+				Path:      p,
+				FaceIndex: index,
+			})
+		}
+		if !isCollection(p) {
+			break
+		}
+	}
+	return locs
+}
+
+// variantName creates a variant name in the style of Google Fonts ("regular",
+// "italic", "700", "700italic") from font metadata. Widths other than normal are
+// appended, e.g. "700 condensed".
+func variantName(md fontfind.Metadata) string {
+	italic := md.Style == font.StyleItalic || md.Style == font.StyleOblique
+	var variant string
+	switch {
+	case md.Weight == font.WeightNormal && italic:
+		variant = "italic"
+	case md.Weight == font.WeightNormal:
+		variant = "regular"
+	default:
+		variant = strconv.Itoa((int(md.Weight) + 4) * 100)
+		if italic {
+			variant += "italic"
+		}
+	}
+	if w := fontfind.WidthName(md.Width); w != "" {
+		variant += " " + w
+	}
+	return variant
+}
+
+// isFontFile returns true if p has the extension of a font file package sfnt is
+// able to parse.
+func isFontFile(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".ttf", ".otf", ".ttc", ".otc":
+		return true
+	}
+	return false
+}
+
+// isCollection returns true if p has the extension of a font collection.
+func isCollection(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".ttc", ".otc":
+		return true
+	}
+	return false
+}
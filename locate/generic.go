@@ -0,0 +1,109 @@
+package locate
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+)
+
+// Generic font families, as known from CSS ("sans-serif", "serif", "monospace"),
+// do not denote a font, but a list of concrete font patterns to try in order.
+// The last pattern of each default list is a font packaged with fallbackfont.
+
+var genericFamilies = struct {
+	sync.RWMutex
+	aliases map[string][]string
+}{
+	aliases: defaultGenericFamilies(runtime.GOOS),
+}
+
+// defaultGenericFamilies returns the generic family aliases for an operating system.
+func defaultGenericFamilies(goos string) map[string][]string {
+	switch goos {
+	case "darwin":
+		return map[string][]string{
+			"sans-serif": {"Helvetica", "Arial", "Go"},
+			"serif":      {"Times", "Georgia", "Gentium"},
+			"monospace":  {"Menlo", "Courier", "Go Mono"},
+		}
+	case "windows":
+		return map[string][]string{
+			"sans-serif": {"Arial", "Segoe UI", "Go"},
+			"serif":      {"Times New Roman", "Georgia", "Gentium"},
+			"monospace":  {"Consolas", "Courier New", "Go Mono"},
+		}
+	}
+	return map[string][]string{
+		"sans-serif": {"DejaVu Sans", "Liberation Sans", "Noto Sans", "Helvetica", "Arial", "Go"},
+		"serif":      {"DejaVu Serif", "Liberation Serif", "Noto Serif", "Times", "Gentium"},
+		"monospace":  {"DejaVu Sans Mono", "Liberation Mono", "Noto Sans Mono", "Courier", "Go Mono"},
+	}
+}
+
+// genericCategories maps generic families to the font category of their fonts, see
+// fontfind.Descriptor.Category.
+var genericCategories = map[string]string{
+	"serif":      "serif",
+	"sans-serif": "sans-serif",
+	"monospace":  "monospace",
+	"cursive":    "handwriting",
+	"fantasy":    "display",
+}
+
+// SetGenericFamily sets the font patterns a generic family is expanded to, in order of
+// priority, overriding the platform defaults. Generic family names are
+// case-insensitive. Calling SetGenericFamily without patterns removes generic.
+func SetGenericFamily(generic string, patterns ...string) {
+	generic = strings.ToLower(strings.TrimSpace(generic))
+	genericFamilies.Lock()
+	defer genericFamilies.Unlock()
+	if len(patterns) == 0 {
+		delete(genericFamilies.aliases, generic)
+		return
+	}
+	genericFamilies.aliases[generic] = append([]string(nil), patterns...)
+}
+
+// GenericFamily returns the font patterns a generic family is expanded to. If name
+// is not a generic family, nil is returned.
+func GenericFamily(name string) []string {
+	genericFamilies.RLock()
+	defer genericFamilies.RUnlock()
+	patterns := genericFamilies.aliases[strings.ToLower(strings.TrimSpace(name))]
+	return append([]string(nil), patterns...)
+}
+
+// resolveGeneric calls resolve for desc. If desc.Pattern is a generic family, resolve
+// is called for each of its concrete patterns in turn, until one succeeds. Unless desc
+// requests a category, fonts are required to be of the generic family's category,
+// e.g. "monospace" (for locators knowing about font categories).
+func resolveGeneric(ctx context.Context, resolve resolveFunc, resolvers []FontLocatorWithContext,
+	desc fontfind.Descriptor) (fontfind.ScalableFont, int, error) {
+	//
+	patterns := GenericFamily(desc.Pattern)
+	if len(patterns) == 0 {
+		return resolve(ctx, resolvers, desc)
+	}
+	category := desc.Category
+	if category == "" {
+		category = genericCategories[strings.ToLower(strings.TrimSpace(desc.Pattern))]
+	}
+	var errs []error
+	for _, pattern := range patterns {
+		d := desc
+		d.Pattern, d.Category = pattern, category
+		f, i, err := resolve(ctx, resolvers, d)
+		if err == nil {
+			tracer().Debugf("generic family %s resolved as %s", desc.Pattern, pattern)
+			return f, i, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fontfind.NullFont, -1, ctxErr
+		}
+		errs = append(errs, err)
+	}
+	return fontfind.NullFont, -1, resolversFailed("no font for generic family "+desc.Pattern, errs)
+}
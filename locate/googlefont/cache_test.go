@@ -2,13 +2,26 @@ package googlefont
 
 import (
 	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/fontregistry"
+	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/schuko/schukonf/testconfig"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
 )
 
 func TestCacheDownload(t *testing.T) {
@@ -22,16 +35,16 @@ func TestCacheDownload(t *testing.T) {
 		"fonts-cache-dir": t.TempDir(),
 	}
 
-	cachedir, err := cacheFontDirPath(hostio, conf, "A")
+	svc := newGoogleService(hostio)
+	cache, err := svc.fontCache(conf)
 	if err != nil {
 		t.Fatal(err)
 	}
-	dst := path.Join(cachedir, "test.svg")
-	err = downloadCachedFile(hostio, dst, url)
+	err = downloadCachedFile(context.Background(), hostio, cache, "A/test.svg", url, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	got, err := os.ReadFile(dst)
+	got, err := os.ReadFile(path.Join(conf["fonts-cache-dir"].(string), "A", "test.svg"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -45,7 +58,7 @@ type failingStatusIO struct {
 	status int
 }
 
-func (f failingStatusIO) HTTPGet(u string) (*http.Response, error) {
+func (f failingStatusIO) HTTPGet(ctx context.Context, u string) (*http.Response, error) {
 	return &http.Response{
 		StatusCode: f.status,
 		Status:     "502 Bad Gateway",
@@ -59,12 +72,570 @@ func TestCacheDownloadHTTPStatusError(t *testing.T) {
 		fakeIO: newFakeIO(t),
 		status: http.StatusBadGateway,
 	}
-	dst := path.Join(t.TempDir(), "test.svg")
-	err := downloadCachedFile(hostio, dst, "https://example.test/failure.svg")
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.svg"
+	err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/failure.svg", nil)
+	if !errors.Is(err, locate.ErrNetworkFailure) {
+		t.Fatalf("expected download failure for non-200 status, got %v", err)
+	}
+	if _, statErr := os.Stat(cache.file(dst)); statErr == nil {
+		t.Fatal("expected no file to be created for failed download")
+	}
+}
+
+type fakeResponseIO struct {
+	*fakeIO
+	contentType   string
+	contentLength int64
+}
+
+func (f fakeResponseIO) HTTPGet(ctx context.Context, u string) (*http.Response, error) {
+	header := make(http.Header)
+	header.Set("Content-Type", f.contentType)
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Status:        "200 OK",
+		Body:          io.NopCloser(bytes.NewReader(f.fontBytes)),
+		Header:        header,
+		ContentLength: f.contentLength,
+	}, nil
+}
+
+func TestCacheDownloadRejectsHTML(t *testing.T) {
+	hostio := fakeResponseIO{fakeIO: newFakeIO(t), contentType: "text/html; charset=utf-8", contentLength: -1}
+	hostio.fontBytes = []byte("<html><body>quota exceeded</body></html>")
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.ttf"
+	if err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/font.ttf", nil); err == nil {
+		t.Fatal("expected download failure for HTML response")
+	}
+	if _, statErr := os.Stat(cache.file(dst)); statErr == nil {
+		t.Fatal("expected no file to be created for HTML response")
+	}
+}
+
+func TestCacheDownloadRejectsTruncated(t *testing.T) {
+	hostio := fakeResponseIO{fakeIO: newFakeIO(t), contentType: "font/ttf"}
+	hostio.contentLength = int64(len(hostio.fontBytes)) + 100
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.ttf"
+	if err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/font.ttf", nil); err == nil {
+		t.Fatal("expected download failure for truncated response")
+	}
+	if _, statErr := os.Stat(cache.file(dst)); statErr == nil {
+		t.Fatal("expected truncated file to be removed")
+	}
+	hostio.contentLength = int64(len(hostio.fontBytes))
+	if err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/font.ttf", nil); err != nil {
+		t.Fatalf("expected complete download to succeed, got %v", err)
+	}
+}
+
+func TestCacheDownloadProgress(t *testing.T) {
+	hostio := fakeResponseIO{fakeIO: newFakeIO(t), contentType: "font/ttf"}
+	hostio.fontBytes = bytes.Repeat([]byte("font"), 50000) // 200000 bytes
+	hostio.contentLength = int64(len(hostio.fontBytes))
+	var calls, last, total int64
+	ctx := WithDownloadProgress(context.Background(), func(written, size int64) {
+		if written < last {
+			t.Errorf("expected progress to increase, went from %d to %d", last, written)
+		}
+		calls, last, total = calls+1, written, size
+	})
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.ttf"
+	if err := downloadCachedFile(ctx, hostio, cache, dst, "https://example.test/font.ttf", downloadProgress(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if calls < 2 || last != 200000 || total != 200000 {
+		t.Errorf("expected periodic progress up to 200000 bytes, got %d calls, last %d of %d", calls, last, total)
+	}
+	hostio.contentLength, last = -1, 0
+	if err := downloadCachedFile(ctx, hostio, cache, dst+"2", "https://example.test/font.ttf", downloadProgress(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if total != -1 {
+		t.Errorf("expected unknown download size to be reported as -1, got %d", total)
+	}
+}
+
+type failingBodyIO struct {
+	*fakeIO
+}
+
+// failingReader delivers some bytes, then fails like an interrupted connection.
+type failingReader struct {
+	sent bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.sent {
+		return 0, errors.New("connection reset")
+	}
+	r.sent = true
+	return copy(p, "partial-font-bytes"), nil
+}
+
+func (f failingBodyIO) HTTPGet(ctx context.Context, u string) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Status:        "200 OK",
+		Body:          io.NopCloser(&failingReader{}),
+		Header:        make(http.Header),
+		ContentLength: -1,
+	}, nil
+}
+
+func TestCacheDownloadInterrupted(t *testing.T) {
+	hostio := failingBodyIO{fakeIO: newFakeIO(t)}
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.ttf"
+	if err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/font.ttf", nil); err == nil {
+		t.Fatal("expected download failure for interrupted copy")
+	}
+	if _, statErr := os.Stat(cache.file(dst)); statErr == nil {
+		t.Fatal("expected no final file for interrupted download")
+	}
+	if _, statErr := os.Stat(cache.file(dst) + ".part"); statErr == nil {
+		t.Fatal("expected temporary file of interrupted download to be removed")
+	}
+}
+
+// minimalIO implements only the methods required by IO, none of its optional
+// interfaces.
+type minimalIO struct {
+	IO
+}
+
+func TestCacheDownloadInterruptedMinimalIO(t *testing.T) {
+	hostio := minimalIO{failingBodyIO{fakeIO: newFakeIO(t)}}
+	if _, ok := IO(hostio).(Remover); ok {
+		t.Fatal("expected minimal IO not to implement Remover")
+	}
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.ttf"
+	if err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/font.ttf", nil); err == nil {
+		t.Fatal("expected download failure for interrupted copy")
+	}
+	if _, statErr := os.Stat(cache.file(dst) + ".part"); statErr == nil {
+		t.Fatal("expected temporary file of interrupted download to be removed without Remover")
+	}
+}
+
+func TestCacheDownloadMinimalIO(t *testing.T) {
+	hostio := minimalIO{newFakeIO(t)}
+	if _, ok := IO(hostio).(Renamer); ok {
+		t.Fatal("expected minimal IO not to implement Renamer")
+	}
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.ttf"
+	if err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/font.ttf", nil); err != nil {
+		t.Fatalf("expected download to succeed without Renamer, got %v", err)
+	}
+	if _, statErr := os.Stat(cache.file(dst)); statErr != nil {
+		t.Fatalf("expected downloaded file to be in place: %v", statErr)
+	}
+	if _, statErr := os.Stat(cache.file(dst) + ".part"); statErr == nil {
+		t.Fatal("expected no temporary file after download")
+	}
+}
+
+type stallingIO struct {
+	*fakeIO
+}
+
+// stallingReader blocks until its context is done, like a stalled connection.
+type stallingReader struct {
+	ctx context.Context
+}
+
+func (r stallingReader) Read(p []byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+func (f stallingIO) HTTPGet(ctx context.Context, u string) (*http.Response, error) {
+	if strings.HasPrefix(u, defaultGoogleFontsAPI) {
+		return f.fakeIO.HTTPGet(ctx, u)
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Status:        "200 OK",
+		Body:          io.NopCloser(stallingReader{ctx}),
+		Header:        make(http.Header),
+		ContentLength: -1,
+	}, nil
+}
+
+func TestFindWithContextAbortsDownload(t *testing.T) {
+	hostio := stallingIO{fakeIO: newFakeIO(t)}
+	conf := testconfig.Conf{"app-key": "tyse-test"}
+	locator := FindWithContext(conf, hostio)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	desc := fontfind.Descriptor{Pattern: "Inconsolata", Style: font.StyleNormal, Weight: font.WeightNormal}
+	_, err := locator(ctx, desc)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected download to be aborted by deadline, got %v", err)
+	}
+}
+
+func TestCacheDownloadErrorContainsURL(t *testing.T) {
+	hostio := failingStatusIO{
+		fakeIO: newFakeIO(t),
+		status: http.StatusBadGateway,
+	}
+	cache, dst := &dirCache{io: hostio, root: t.TempDir()}, "test.ttf"
+	err := downloadCachedFile(context.Background(), hostio, cache, dst, "https://example.test/fonts/failure.ttf?key=secret-key", nil)
 	if err == nil {
 		t.Fatal("expected download failure for non-200 status")
 	}
-	if _, statErr := os.Stat(dst); statErr == nil {
-		t.Fatal("expected no file to be created for failed download")
+	if !strings.Contains(err.Error(), "https://example.test/fonts/failure.ttf") {
+		t.Errorf("expected error to contain font URL, got %q", err)
+	}
+	if strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("expected API key to be redacted from error, got %q", err)
+	}
+}
+
+// encodeWOFF packs SFNT data into a WOFF 1.0 container, compressing all tables.
+func encodeWOFF(t *testing.T, sfnt []byte) []byte {
+	t.Helper()
+	numTables := int(binary.BigEndian.Uint16(sfnt[4:]))
+	header := make([]byte, 44+20*numTables)
+	copy(header, "wOFF")
+	copy(header[4:], sfnt[:4])
+	binary.BigEndian.PutUint16(header[12:], uint16(numTables))
+	var tables []byte
+	for i := 0; i < numTables; i++ {
+		rec := sfnt[12+16*i:]
+		offset := binary.BigEndian.Uint32(rec[8:])
+		length := binary.BigEndian.Uint32(rec[12:])
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(sfnt[offset : offset+length])
+		w.Close()
+		data := buf.Bytes()
+		if len(data) >= int(length) {
+			data = sfnt[offset : offset+length]
+		}
+		entry := header[44+20*i:]
+		copy(entry, rec[:4])
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(header)+len(tables)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(data)))
+		binary.BigEndian.PutUint32(entry[12:], length)
+		binary.BigEndian.PutUint32(entry[16:], binary.BigEndian.Uint32(rec[4:]))
+		tables = append(tables, data...)
+		for len(tables)%4 != 0 {
+			tables = append(tables, 0)
+		}
+	}
+	woff := append(header, tables...)
+	binary.BigEndian.PutUint32(woff[8:], uint32(len(woff)))
+	return woff
+}
+
+func webFontInfo(url string) GoogleFontInfo {
+	fi := GoogleFontInfo{Files: map[string]string{"regular": url}}
+	fi.Family = "Go"
+	fi.Variants = []string{"regular"}
+	return fi
+}
+
+func TestCacheWOFFDecodesToSFNT(t *testing.T) {
+	otf, err := os.ReadFile("../fallbackfont/packaged/Go-Regular.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostio := newFakeIO(t)
+	hostio.fontBytes = encodeWOFF(t, otf)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{"app-key": "tyse-test"}
+	cache, name, err := svc.cacheGoogleFont(context.Background(), conf, webFontInfo("https://example.test/go.woff"), "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "G/Go-regular.ttf"
+	if string(otf[:4]) == "OTTO" {
+		expected = "G/Go-regular.otf"
+	}
+	if name != expected {
+		t.Errorf("expected decoded font to be cached as %s, is %s", expected, name)
+	}
+	data, err := fs.ReadFile(cache, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sfnt.Parse(data); err != nil {
+		t.Errorf("cannot parse decoded font: %v", err)
+	}
+}
+
+func TestCacheFailure(t *testing.T) {
+	svc := newGoogleService(newFakeIO(t))
+	conf := testconfig.Conf{} // no app-key, no cache directory
+	_, _, err := svc.cacheGoogleFont(context.Background(), conf, webFontInfo("https://example.test/go.ttf"), "regular")
+	if !errors.Is(err, locate.ErrCacheFailure) {
+		t.Errorf("expected ErrCacheFailure without a cache directory, got %v", err)
+	}
+}
+
+func TestCorruptCachedFontRefetched(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{"app-key": "tyse-test"}
+	fi := webFontInfo("https://example.test/go.ttf")
+	cache, name, err := svc.cacheGoogleFont(context.Background(), conf, fi, "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := cache.(*dirCache).file(name)
+	if _, err = os.Stat(cached + checksumExt); err != nil {
+		t.Fatalf("expected checksum next to cached font: %v", err)
+	}
+	hostio.requestedURL = nil
+	if _, _, err = svc.cacheGoogleFont(context.Background(), conf, fi, "regular"); err != nil || len(hostio.requestedURL) != 0 {
+		t.Fatalf("expected intact cached font to be used, got %d requests (%v)", len(hostio.requestedURL), err)
+	}
+	if err = os.WriteFile(cached, []byte("dummy-font"), 0644); err != nil { // truncated
+		t.Fatal(err)
+	}
+	if _, _, err = svc.cacheGoogleFont(context.Background(), conf, fi, "regular"); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 1 {
+		t.Errorf("expected corrupt cached font to be downloaded again, got %d requests", len(hostio.requestedURL))
+	}
+	if data, _ := os.ReadFile(cached); string(data) != string(hostio.fontBytes) {
+		t.Errorf("expected cached font to be restored, is %q", data)
+	}
+}
+
+func TestDecodeWOFFChecksTableSizes(t *testing.T) {
+	otf, err := os.ReadFile("../fallbackfont/packaged/Go-Regular.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	woff := encodeWOFF(t, otf)
+	if _, _, err = decodeWOFF(woff); err != nil {
+		t.Fatal(err)
+	}
+	compressed := -1 // directory entry of a compressed table
+	for i := 0; i < int(binary.BigEndian.Uint16(woff[12:])); i++ {
+		entry := woff[44+20*i:]
+		if binary.BigEndian.Uint32(entry[8:]) < binary.BigEndian.Uint32(entry[12:]) {
+			compressed = 44 + 20*i
+			break
+		}
+	}
+	if compressed < 0 {
+		t.Fatal("expected a compressed table in test font")
+	}
+	origLength := binary.BigEndian.Uint32(woff[compressed+12:])
+	for _, length := range []uint32{origLength + 1, maxWOFFTableSize + 1} {
+		bad := bytes.Clone(woff)
+		binary.BigEndian.PutUint32(bad[compressed+12:], length)
+		if _, _, err = decodeWOFF(bad); err == nil {
+			t.Errorf("expected error for table declared with %d instead of %d bytes", length, origLength)
+		}
+	}
+}
+
+func TestCacheWOFFDecodeFailure(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{"app-key": "tyse-test"}
+	cache, _, err := svc.cacheGoogleFont(context.Background(), conf, webFontInfo("https://example.test/go.woff2"), "regular")
+	if err == nil {
+		t.Fatal("expected error for undecodable web font")
+	}
+	if !cache.Has("G/Go-regular.woff2") {
+		t.Errorf("expected original download to be kept")
+	}
+	for _, ext := range []string{".ttf", ".otf"} {
+		if cache.Has("G/Go-regular" + ext) {
+			t.Errorf("expected no decoded font to be cached")
+		}
+	}
+}
+
+// memCache is a FontCache held in memory.
+type memCache struct {
+	entries map[string][]byte
+}
+
+func (c *memCache) Has(name string) bool {
+	_, ok := c.entries[name]
+	return ok
+}
+
+func (c *memCache) Open(name string) (fs.File, error) {
+	return fstest.MapFS{name: &fstest.MapFile{Data: c.entries[name], ModTime: time.Now()}}.Open(name)
+}
+
+func (c *memCache) Put(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	c.entries[name] = data
+	return nil
+}
+
+func TestCustomFontCache(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	cache := &memCache{entries: make(map[string][]byte)}
+	svc.cache = cache
+	conf := testconfig.Conf{} // no app-key required
+	f, err := svc.findGoogleFont(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal, font.WeightNormal,
+		fontfind.NoConfidence, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := f.ReadFontData(); err != nil || string(data) != string(hostio.fontBytes) {
+		t.Errorf("expected font to be read from custom cache, got %q (%v)", data, err)
+	}
+	for _, name := range []string{directoryCacheFile, "I/Inconsolata-regular.ttf", "I/Inconsolata-regular.ttf" + checksumExt} {
+		if !cache.Has(name) {
+			t.Errorf("expected %s in custom cache", name)
+		}
+	}
+	if entries, _ := os.ReadDir(hostio.cacheDir); len(entries) != 0 {
+		t.Errorf("expected host cache directory to be untouched, has %d entries", len(entries))
+	}
+	hostio.requestedURL = nil
+	svc.googleFontsDirs = nil
+	if _, err = svc.findGoogleFont(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal, font.WeightNormal,
+		fontfind.NoConfidence, false); err != nil || len(hostio.requestedURL) != 0 {
+		t.Errorf("expected directory and font to be taken from custom cache, got %d requests (%v)",
+			len(hostio.requestedURL), err)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	root := t.TempDir()
+	conf := testconfig.Conf{"fonts-cache-dir": root}
+	cache := &dirCache{io: hostio, root: root}
+	put := func(name string, size int, age time.Duration) {
+		if err := cache.Put(name, bytes.NewReader(make([]byte, size))); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(cache.file(name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(directoryCacheFile, 1000, 100*time.Hour)
+	put("A/Old-regular.ttf", 100, 50*time.Hour)
+	put("A/Old-regular.ttf"+checksumExt, 10, 50*time.Hour)
+	put("B/Busy-regular.ttf", 100, 40*time.Hour)
+	put("C/Mid-regular.ttf", 100, 2*time.Hour)
+	put("D/New-regular.ttf", 100, time.Hour)
+	done := startDownload(cache.file("B/Busy-regular"))
+	defer done()
+	if err := svc.pruneCache(conf, PruneOptions{MaxAge: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range map[string]bool{
+		directoryCacheFile: true, "A/Old-regular.ttf": false, "A/Old-regular.ttf" + checksumExt: false,
+		"B/Busy-regular.ttf": true, "C/Mid-regular.ttf": true,
+	} {
+		if cache.Has(name) != kept {
+			t.Errorf("expected %s to be kept = %v after pruning by age", name, kept)
+		}
+	}
+	if err := svc.pruneCache(conf, PruneOptions{MaxBytes: 250}); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Has("B/Busy-regular.ttf") || cache.Has("C/Mid-regular.ttf") || !cache.Has("D/New-regular.ttf") {
+		t.Errorf("expected least recently downloaded font to be pruned, except fonts being downloaded")
+	}
+}
+
+func TestPruneCacheKeepsUsedFonts(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	root := t.TempDir()
+	conf := testconfig.Conf{"fonts-cache-dir": root, "fonts-cache-max-age": "24h"}
+	cache := &dirCache{io: hostio, root: root}
+	put := func(name string, age time.Duration) {
+		if err := cache.Put(name, bytes.NewReader(make([]byte, 100))); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(cache.file(name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("A/Used-regular.ttf", 50*time.Hour)
+	put("B/Unused-regular.ttf", 40*time.Hour)
+	put("E/Registered-regular.ttf", 50*time.Hour)
+	touchCached(cache, "A/Used-regular.ttf")
+	registered := fontfind.ScalableFont{Name: "Registered-regular.ttf", Source: fontfind.SourceGoogle}
+	fontregistry.GlobalRegistry().StoreFont("registered-prune-test", registered)
+	defer fontregistry.GlobalRegistry().Remove("registered-prune-test")
+	if err := svc.pruneCache(conf, PruneOptions{MaxBytes: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Has("A/Used-regular.ttf") || cache.Has("B/Unused-regular.ttf") || !cache.Has("E/Registered-regular.ttf") {
+		t.Errorf("expected least recently used font to be pruned, except fonts in use or registered")
+	}
+	put("F/Old-regular.ttf", 50*time.Hour)
+	if err := svc.prunePeriodically(conf); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Has("F/Old-regular.ttf") {
+		t.Fatalf("expected expired font to be pruned after download")
+	}
+	put("F/Old-regular.ttf", 50*time.Hour)
+	if err := svc.prunePeriodically(conf); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Has("F/Old-regular.ttf") {
+		t.Errorf("expected cache not to be pruned again within the prune interval")
+	}
+	conf["fonts-cache-prune-interval"] = "0s"
+	if err := svc.prunePeriodically(conf); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Has("F/Old-regular.ttf") {
+		t.Errorf("expected cache to be pruned after every download with interval 0")
+	}
+}
+
+func TestListCached(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{"app-key": "tyse-test"}
+	for _, family := range []string{"Anonymous Pro", "Noto Sans"} {
+		if _, err := svc.findGoogleFont(context.Background(), conf, family, "", "", font.StyleItalic, font.WeightBold,
+			fontfind.NoConfidence, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache, err := svc.fontCache(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a font downloaded as a web font is cached in both formats
+	if err = cache.Put("A/Anonymous Pro-700italic.woff", strings.NewReader("web font")); err != nil {
+		t.Fatal(err)
+	}
+	fonts, err := svc.listCached(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fonts) != 2 {
+		t.Fatalf("expected 2 cached fonts, have %v", fonts)
+	}
+	for i, family := range []string{"Anonymous Pro", "Noto Sans"} {
+		f, size := fonts[i], int64(len(hostio.fontBytes))
+		if i == 0 {
+			size += int64(len("web font"))
+		}
+		if f.Family != family || f.Variant != "700italic" || f.Size != size ||
+			path.Base(path.Dir(f.Path)) != family[:1] || path.Ext(f.Path) == ".woff" {
+			t.Errorf("unexpected cached font %+v", f)
+		}
+	}
+	svc.cache = &memCache{entries: make(map[string][]byte)}
+	if _, err = svc.listCached(conf); err == nil {
+		t.Errorf("expected listing a custom cache to fail")
 	}
 }
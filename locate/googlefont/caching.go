@@ -1,36 +1,319 @@
 package googlefont
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	neturl "net/url"
 	"path"
+	"strings"
+	"time"
 
+	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/schuko"
 )
 
-// downloadFile will download a url to a local file (usually located in the
-// user's cache directory).
-func downloadCachedFile(hostio IO, filepath string, url string) error {
-	resp, err := hostio.HTTPGet(url)
+// downloadCachedFile will download a url to an entry of a font cache (usually
+// located in the user's cache directory).
+//
+// The download is streamed into cache.Put, which does not store incomplete
+// downloads. An interrupted download will therefore never be mistaken for a
+// cached font.
+//
+// Responses which clearly are not fonts (e.g., HTML error pages) are rejected, as
+// are downloads not matching the announced Content-Length. In this case no entry
+// is stored.
+//
+// If progress is not nil, it is called periodically during the download.
+//
+// Errors are wrapped with the (redacted) url of the download.
+func downloadCachedFile(ctx context.Context, hostio IO, cache FontCache, name string, url string,
+	progress ProgressFunc) (err error) {
+	//
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("download of %s failed: %w", redactURL(url), err)
+		}
+	}()
+	resp, err := hostio.HTTPGet(ctx, url)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", locate.ErrNetworkFailure, redactError(err))
 	}
 	if resp == nil {
-		return errors.New("download request returned nil response")
+		return fmt.Errorf("%w: download request returned nil response", locate.ErrNetworkFailure)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download request failed: %s", resp.Status)
+		err = fmt.Errorf("%w: download request failed: %s", locate.ErrNetworkFailure, resp.Status)
+		if !isTransientStatus(resp.StatusCode) {
+			err = permanent(err)
+		}
+		return err
+	}
+	if ctype := resp.Header.Get("Content-Type"); !isFontContentType(ctype) {
+		return permanent(fmt.Errorf("%w: download returned %s, not a font", locate.ErrNetworkFailure, ctype))
+	}
+	err = cache.Put(name, &downloadReader{r: resp.Body, progress: progress, total: resp.ContentLength})
+	if err != nil && !errors.Is(err, locate.ErrNetworkFailure) {
+		err = fmt.Errorf("%w: %w", locate.ErrCacheFailure, err)
+	}
+	return err
+}
+
+// ProgressFunc is called periodically while a font is downloaded, with the number of
+// bytes written so far and the size of the download. If the size is unknown,
+// totalBytes is -1.
+type ProgressFunc func(bytesWritten, totalBytes int64)
+
+// progressReportInterval is the number of bytes between calls of a ProgressFunc.
+const progressReportInterval = 64 * 1024
+
+type progressKey struct{}
+
+// WithDownloadProgress returns a context which carries a progress callback for font
+// downloads. Pass it to a context-aware locator (see FindWithContext) to be informed
+// about the progress of downloads.
+func WithDownloadProgress(ctx context.Context, progress ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// downloadProgress returns the progress callback carried by ctx, if any.
+func downloadProgress(ctx context.Context) ProgressFunc {
+	progress, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return progress
+}
+
+// downloadReader reads the body of a download and reports its progress to a
+// ProgressFunc, if any. At the end of the download, it fails for empty downloads and
+// downloads not matching the announced size. Its errors wrap locate.ErrNetworkFailure.
+type downloadReader struct {
+	r        io.Reader
+	progress ProgressFunc
+	total    int64 // announced size, or -1
+	read     int64
+	reported int64
+}
+
+func (dr *downloadReader) Read(p []byte) (int, error) {
+	n, err := dr.r.Read(p)
+	dr.read += int64(n)
+	if err != nil && err != io.EOF {
+		return n, fmt.Errorf("%w: %w", locate.ErrNetworkFailure, err)
+	}
+	if dr.progress != nil && dr.read-dr.reported >= progressReportInterval {
+		dr.reported = dr.read
+		dr.progress(dr.read, dr.total)
+	}
+	if err == io.EOF {
+		if dr.read == 0 {
+			return n, fmt.Errorf("%w: download is empty", locate.ErrNetworkFailure)
+		} else if dr.total > 0 && dr.read != dr.total {
+			return n, fmt.Errorf("%w: download truncated: got %d of %d bytes", locate.ErrNetworkFailure,
+				dr.read, dr.total)
+		}
+		if dr.progress != nil && dr.reported != dr.read {
+			dr.reported = dr.read
+			dr.progress(dr.read, dr.total)
+		}
+	}
+	return n, err
+}
+
+// checksumExt is the extension of the sidecar file holding the SHA-256 checksum
+// of a cached font file, in the format of sha256sum.
+const checksumExt = ".sha256"
+
+// fileChecksum returns the hex-encoded SHA-256 checksum of a cache entry.
+func fileChecksum(cache FontCache, name string) (string, error) {
+	data, err := fs.ReadFile(cache, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeChecksum stores the checksum of a cache entry in a sidecar entry. Failing to
+// do so is not an error, as the cached file is usable without it.
+func writeChecksum(cache FontCache, name string) {
+	sum, err := fileChecksum(cache, name)
+	if err != nil {
+		tracer().Errorf("cannot compute checksum of %s: %v", name, err)
+		return
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, path.Base(name))
+	if err = cache.Put(name+checksumExt, strings.NewReader(line)); err != nil {
+		tracer().Errorf("cannot store checksum of %s: %v", name, err)
+	}
+}
+
+// verifyChecksum checks a cache entry against the checksum in its sidecar entry.
+// Files cached without a sidecar entry are trusted, and a sidecar entry is created.
+func verifyChecksum(cache FontCache, name string) bool {
+	stored, err := fs.ReadFile(cache, name+checksumExt)
+	if err != nil {
+		writeChecksum(cache, name)
+		return true
+	}
+	sum, err := fileChecksum(cache, name)
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stored))
+	return len(fields) > 0 && fields[0] == sum
+}
+
+// isTransientStatus returns true for HTTP status codes of failures which may go away
+// by retrying, e.g. 503 Service Unavailable.
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}
+
+// isFontContentType returns false for content types which clearly do not denote
+// a font, such as HTML error pages. Missing or generic content types are accepted.
+func isFontContentType(ctype string) bool {
+	if ctype == "" {
+		return true
 	}
-	out, err := hostio.Create(filepath)
+	mediatype, _, err := mime.ParseMediaType(ctype)
 	if err != nil {
+		return true
+	}
+	switch mediatype {
+	case "text/html", "application/xhtml+xml", "application/json", "text/javascript":
+		return false
+	}
+	return true
+}
+
+// redactError removes an API key from the URL of a failed request in err, e.g. of
+// a transport error returned by HTTPGet, making err suitable for logging.
+func redactError(err error) error {
+	var urlErr *neturl.Error
+	if !errors.As(err, &urlErr) {
 		return err
 	}
-	defer out.Close()
-	_, err = io.Copy(out, resp.Body)
-	return err
+	redacted := *urlErr
+	redacted.URL = redactURL(urlErr.URL)
+	return &redacted
+}
+
+// redactURL removes an API key from a URL, making it suitable for logging.
+func redactURL(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	q := u.Query()
+	if q.Has("key") {
+		q.Set("key", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// directoryCacheFile is the name of the cached Google Fonts directory, located in
+// the base font cache directory.
+const directoryCacheFile = "webfonts.json"
+
+// directoryCacheName returns the file name of the cached Google Fonts directory.
+// Directories fetched with capabilities (see capabilities) list different files,
+// and directories fetched in another sort order (see sortOrder) list them in a
+// different order. They are therefore cached separately, e.g. as
+// "webfonts-vf-woff2.json" or "webfonts-popularity.json".
+func directoryCacheName(conf schuko.Configuration) string {
+	parts := capabilities(conf)
+	if order := sortOrder(conf); order != defaultSortOrder {
+		parts = append(parts, order)
+	}
+	if len(parts) == 0 {
+		return directoryCacheFile
+	}
+	return "webfonts-" + strings.ToLower(strings.Join(parts, "-")) + ".json"
+}
+
+// defaultDirectoryTTL is the default freshness window of a cached Google Fonts directory.
+const defaultDirectoryTTL = 24 * time.Hour
+
+// directoryTTL returns the freshness window for the cached Google Fonts
+// directory, taken from configuration key "google-fonts-cache-ttl" (a duration
+// like "12h"). A TTL of zero disables use of a fresh cached directory; a directory
+// fetched is then kept for the lifetime of the process.
+func directoryTTL(conf schuko.Configuration) time.Duration {
+	if !conf.IsSet("google-fonts-cache-ttl") {
+		return defaultDirectoryTTL
+	}
+	ttl, err := time.ParseDuration(conf.GetString("google-fonts-cache-ttl"))
+	if err != nil {
+		tracer().Errorf("invalid google-fonts-cache-ttl, using default: %v", err)
+		return defaultDirectoryTTL
+	}
+	return ttl
+}
+
+// expiry returns the time a directory fetched now expires, given the TTL. A zero
+// time means it never expires.
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// loadCachedDirectory reads the Google Fonts directory from the font cache.
+// modTime is the time the cached directory has been written.
+func (svc *googleService) loadCachedDirectory(conf schuko.Configuration) (
+	list googleFontsList, modTime time.Time, err error) {
+	//
+	cache, err := svc.fontCache(conf)
+	if err != nil {
+		return
+	}
+	f, err := cache.Open(directoryCacheName(conf))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &list); err != nil {
+		tracer().Errorf("cached Google Fonts directory is corrupt: %v", err)
+		return googleFontsList{}, time.Time{}, err
+	}
+	return list, fi.ModTime(), nil
+}
+
+// storeCachedDirectory writes the Google Fonts directory to the font cache, which
+// replaces the cached directory atomically (see FontCache). Failing to do so is not
+// an error, as the directory may be fetched again.
+func (svc *googleService) storeCachedDirectory(conf schuko.Configuration, list googleFontsList) {
+	cache, err := svc.fontCache(conf)
+	if err != nil {
+		tracer().Infof("not caching Google Fonts directory: %v", err)
+		return
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		tracer().Errorf("cannot encode Google Fonts directory: %v", err)
+		return
+	}
+	if err = cache.Put(directoryCacheName(conf), bytes.NewReader(data)); err != nil {
+		tracer().Errorf("cannot cache Google Fonts directory: %v", err)
+	}
 }
 
 // cacheFontDirPath checks and possibly creates a folder in the user's font cache
@@ -0,0 +1,202 @@
+package googlefont
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/npillmayer/schuko"
+)
+
+// FontCache stores downloaded font files and the Google Fonts directory. Entries
+// are addressed by slash-separated names relative to the cache, e.g.
+// "I/Inconsolata-regular.ttf" or "webfonts.json".
+//
+// A FontCache is an fs.FS as well, and fonts loaded from a cache other than the
+// default one read their data from it. Implementations should therefore be pointer
+// types, keeping fontfind.ScalableFont comparable. Files returned by Open must
+// report their modification time with Stat, which is used to tell the age of a
+// cached directory.
+//
+// Put stores all of r as entry name, replacing an existing entry. If reading r fails,
+// Put must not store anything, so that an incomplete download is never mistaken for a
+// cached font. Replacing an entry must be atomic, as other processes may read the
+// entry (e.g. the cached directory) at the same time.
+type FontCache interface {
+	Has(name string) bool               // is an entry present?
+	Open(name string) (fs.File, error)  // open an entry for reading
+	Put(name string, r io.Reader) error // store an entry
+}
+
+var customCache struct {
+	sync.Mutex
+	cache FontCache
+}
+
+// SetFontCache replaces the cache of downloaded fonts, e.g. with a memory-backed store.
+// A nil cache selects the default cache, i.e. a folder of the host's cache directory
+// (see the configuration keys "fonts-cache-dir" and "app-key").
+func SetFontCache(cache FontCache) {
+	customCache.Lock()
+	defer customCache.Unlock()
+	customCache.cache = cache
+}
+
+// fontCache returns the cache to use for svc: a cache set for the service (by tests),
+// one set by SetFontCache, or the default directory cache.
+func (svc *googleService) fontCache(conf schuko.Configuration) (FontCache, error) {
+	if svc.cache != nil {
+		return svc.cache, nil
+	}
+	customCache.Lock()
+	cache := customCache.cache
+	customCache.Unlock()
+	if cache != nil {
+		return cache, nil
+	}
+	root, err := cacheFontDirPath(svc.io, conf, "")
+	if err != nil {
+		return nil, err
+	}
+	return &dirCache{io: svc.io, root: root}, nil
+}
+
+// dirCache is the default FontCache, located in a folder of the host's file system.
+// Fonts are kept in sub-folders named after the first letter of their family, with
+// the Google Fonts directory at the top level.
+type dirCache struct {
+	io   IO
+	root string
+}
+
+func (c *dirCache) Has(name string) bool {
+	_, err := c.io.Stat(c.file(name))
+	return err == nil
+}
+
+func (c *dirCache) Open(name string) (fs.File, error) {
+	return c.io.DirFS(c.root).Open(name)
+}
+
+// Put writes r to a temporary file "<name>.part", which is renamed to name only
+// after r has been read completely.
+func (c *dirCache) Put(name string, r io.Reader) error {
+	file := c.file(name)
+	if dir := path.Dir(file); dir != c.root {
+		if _, err := c.io.Stat(dir); err != nil {
+			if err = c.io.MkdirAll(dir, 0750); err != nil {
+				return err
+			}
+		}
+	}
+	partial := file + ".part"
+	out, err := c.io.Create(partial)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = rename(c.io, partial, file)
+	}
+	if err != nil {
+		if rmErr := remove(c.io, partial); rmErr != nil {
+			tracer().Errorf("cannot remove incomplete file %s: %v", partial, rmErr)
+		}
+	}
+	return err
+}
+
+// touchCached marks entry name of cache as used, if cache is the font cache directory.
+func touchCached(cache FontCache, name string) {
+	if dc, ok := cache.(*dirCache); ok {
+		dc.touch(name)
+	}
+}
+
+// touch marks entry name as used just now, if the IO allows (see Toucher).
+func (c *dirCache) touch(name string) {
+	t, ok := c.io.(Toucher)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if err := t.Chtimes(c.file(name), now, now); err != nil {
+		tracer().Debugf("cannot mark cached font %s as used: %v", name, err)
+	}
+}
+
+// file returns the path of entry name on the host's file system.
+func (c *dirCache) file(name string) string {
+	return path.Join(c.root, name)
+}
+
+// CachedFont describes a font file in the font cache directory.
+type CachedFont struct {
+	Family  string // e.g. "Noto Sans"
+	Variant string // e.g. "regular" or "700italic"
+	Path    string // path of the font file on the host's file system
+	Size    int64  // in bytes, of all files of the font, e.g. a web font and its decoded font
+}
+
+// ListCached lists the fonts in the font cache directory, sorted by path, e.g.
+// for letting users manage downloaded fonts. Family and variant are taken from the
+// file names, which follow the scheme "Family-variant.ext". Fonts downloaded as web
+// fonts are cached in both formats; they are listed once, with the path of the
+// decoded font. Checksums, incomplete downloads and the cached Google Fonts
+// directory are not listed.
+//
+// Caches set with SetFontCache cannot be listed.
+func ListCached(conf schuko.Configuration) ([]CachedFont, error) {
+	return defaultGoogleService.listCached(conf)
+}
+
+func (svc *googleService) listCached(conf schuko.Configuration) ([]CachedFont, error) {
+	cache, err := svc.fontCache(conf)
+	if err != nil {
+		return nil, err
+	}
+	dc, ok := cache.(*dirCache)
+	if !ok {
+		return nil, errors.New("cannot list fonts of a custom font cache")
+	}
+	var fonts []CachedFont
+	listed := make(map[string]int) // index into fonts by entry base, see entryBase
+	err = fs.WalkDir(dc.io.DirFS(dc.root), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.Contains(name, "/") ||
+			strings.HasSuffix(name, checksumExt) || strings.HasSuffix(name, ".part") {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil // removed in the meantime
+		}
+		base := entryBase(name)
+		if i, ok := listed[base]; ok { // web font and decoded font
+			fonts[i].Size += fi.Size()
+			if !isWebFont(path.Ext(name)) {
+				fonts[i].Path = dc.file(name)
+			}
+			return nil
+		}
+		family, variant := path.Base(base), ""
+		if i := strings.LastIndexByte(family, '-'); i > 0 {
+			family, variant = family[:i], family[i+1:]
+		}
+		listed[base] = len(fonts)
+		fonts = append(fonts, CachedFont{
+			Family:  family,
+			Variant: variant,
+			Path:    dc.file(name),
+			Size:    fi.Size(),
+		})
+		return nil
+	})
+	return fonts, err
+}
@@ -1,6 +1,8 @@
 package googlefont
 
 import (
+	"context"
+
 	"github.com/npillmayer/fontfind"
 	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/schuko"
@@ -19,13 +21,29 @@ var USE_SYSTEM_IO IO = nil
 
 // Find creates a FontLocator for Google Fonts using default host I/O.
 // hostio may be nil (USE_SYSTEM_IO) to use the OS-backed default implementation.
+// The locator does not see a context, so it downloads fonts even in a dry run of
+// locate.LocateFont; use FindWithContext there.
 func Find(conf schuko.Configuration, hostio IO) locate.FontLocator {
 	svc := newGoogleService(hostio)
 	return func(descr fontfind.Descriptor) (fontfind.ScalableFont, error) {
 		pattern := descr.Pattern
 		style := descr.Style
 		weight := descr.Weight
-		return svc.findGoogleFont(conf, pattern, style, weight)
+		return svc.findGoogleFont(context.Background(), conf, pattern, descr.Subset, descr.Category, style, weight,
+			descr.MinConfidence, descr.FuzzyFamily)
+	}
+}
+
+// FindWithContext creates a context-aware FontLocator for Google Fonts.
+// Cancelling the context aborts an in-flight font download; the locator then
+// returns the context's error. In a dry run of locate.LocateFont, fonts are
+// located without downloading them.
+// hostio may be nil (USE_SYSTEM_IO) to use the OS-backed default implementation.
+func FindWithContext(conf schuko.Configuration, hostio IO) locate.FontLocatorWithContext {
+	svc := newGoogleService(hostio)
+	return func(ctx context.Context, descr fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return svc.findGoogleFont(ctx, conf, descr.Pattern, descr.Subset, descr.Category, descr.Style, descr.Weight,
+			descr.MinConfidence, descr.FuzzyFamily)
 	}
 }
 
@@ -1,17 +1,28 @@
 package googlefont
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/schuko/schukonf/testconfig"
+	"github.com/npillmayer/schuko/tracing"
 	"golang.org/x/image/font"
 )
 
@@ -22,6 +33,8 @@ type fakeIO struct {
 	webfontsJSON []byte
 	fontBytes    []byte
 	requestedURL []string
+	dirStatus    int // if set, directory requests fail with this status
+	failures     int // number of requests to fail with a network error
 }
 
 func newFakeIO(t *testing.T) *fakeIO {
@@ -42,9 +55,21 @@ func (f *fakeIO) Getenv(k string) string {
 	return f.env[k]
 }
 
-func (f *fakeIO) HTTPGet(u string) (*http.Response, error) {
+func (f *fakeIO) HTTPGet(ctx context.Context, u string) (*http.Response, error) {
 	f.requestedURL = append(f.requestedURL, u)
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("connection reset by peer")
+	}
 	if strings.HasPrefix(u, defaultGoogleFontsAPI) {
+		if f.dirStatus != 0 {
+			return &http.Response{
+				StatusCode: f.dirStatus,
+				Status:     http.StatusText(f.dirStatus),
+				Body:       io.NopCloser(strings.NewReader("")),
+				Header:     make(http.Header),
+			}, nil
+		}
 		return &http.Response{
 			StatusCode: http.StatusOK,
 			Status:     "200 OK",
@@ -80,6 +105,18 @@ func (f *fakeIO) Create(path string) (io.WriteCloser, error) {
 	return os.Create(path)
 }
 
+func (f *fakeIO) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (f *fakeIO) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (f *fakeIO) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
 func TestGoogleRespDecode(t *testing.T) {
 	hostio := newFakeIO(t)
 	dec := json.NewDecoder(strings.NewReader(string(hostio.webfontsJSON)))
@@ -88,7 +125,43 @@ func TestGoogleRespDecode(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	listGoogleFonts(list, ".*")
+	fiList, err := queryGoogleFonts(list, ".*", MatchRegex, "")
+	if err != nil || len(fiList) != len(list.Items) {
+		t.Fatalf("expected all %d fonts to match, got %d (%v)", len(list.Items), len(fiList), err)
+	}
+	printGoogleFonts(fiList)
+}
+
+func TestQueryGoogleFonts(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	level := tracer().GetTraceLevel()
+	fiList, err := svc.queryGoogleFonts(conf, "noto s", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fiList) != 3 || fiList[0].Family != "Noto Sans" || fiList[2].Family != "Noto Serif" {
+		t.Errorf("expected 3 Noto fonts in directory order, got %v", fiList)
+	}
+	if tracer().GetTraceLevel() != level {
+		t.Errorf("expected query not to change the trace level")
+	}
+	if fiList, err = svc.queryGoogleFonts(conf, "zz-no-such-family", ""); err != nil || fiList == nil || len(fiList) != 0 {
+		t.Errorf("expected empty result without error, got %v (%v)", fiList, err)
+	}
+	page, total, err := svc.queryGoogleFontsPage(conf, "noto s", 1, 1)
+	if err != nil || total != 3 || len(page) != 1 || page[0].Family != "Noto Sans Devanagari" {
+		t.Errorf("expected second Noto font of 3, got %v of %d (%v)", page, total, err)
+	}
+	if page, total, _ = svc.queryGoogleFontsPage(conf, "noto s", 2, 0); total != 3 || len(page) != 1 {
+		t.Errorf("expected last page to hold the remaining font, got %v of %d", page, total)
+	}
+	if page, total, _ = svc.queryGoogleFontsPage(conf, "noto s", 5, 2); total != 3 || len(page) != 0 {
+		t.Errorf("expected empty page beyond the last font, got %v of %d", page, total)
+	}
 }
 
 func TestGoogleAPI(t *testing.T) {
@@ -97,7 +170,7 @@ func TestGoogleAPI(t *testing.T) {
 	conf := testconfig.Conf{
 		"app-key": "tyse-test",
 	}
-	err := svc.setupGoogleFontsDirectory(conf)
+	err := svc.setupGoogleFontsDirectory(context.Background(), conf)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,6 +184,403 @@ func TestGoogleAPI(t *testing.T) {
 	if !strings.Contains(url, "sort=alpha") {
 		t.Fatalf("expected sort=alpha in request URL, got %q", url)
 	}
+	if strings.Contains(url, "capability") {
+		t.Errorf("expected no capabilities requested by default, got %q", url)
+	}
+}
+
+func TestGoogleAPICapabilities(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key":                 "tyse-test",
+		"google-fonts-capability": "woff2, vf,bogus,VF",
+	}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 1 {
+		t.Fatalf("expected 1 API request, got %d", len(hostio.requestedURL))
+	}
+	if url := hostio.requestedURL[0]; !strings.Contains(url, "capability=VF&capability=WOFF2") ||
+		strings.Contains(url, "bogus") {
+		t.Errorf("expected validated capabilities VF and WOFF2 in request URL, got %q", url)
+	}
+	if name := directoryCacheName(conf); name != "webfonts-vf-woff2.json" {
+		t.Errorf("expected separate directory cache for capabilities, got %q", name)
+	}
+}
+
+func TestGoogleAPISortOrder(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key":           "tyse-test",
+		"google-fonts-sort": "Popularity",
+	}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 1 || !strings.Contains(hostio.requestedURL[0], "sort=popularity") {
+		t.Fatalf("expected sort=popularity in request URL, got %v", hostio.requestedURL)
+	}
+	if name := directoryCacheName(conf); name != "webfonts-popularity.json" {
+		t.Errorf("expected separate directory cache for sort order, got %q", name)
+	}
+	// changing the sort order loads the directory again
+	conf["google-fonts-sort"] = "alpha"
+	hostio.requestedURL = nil
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 1 || !strings.Contains(hostio.requestedURL[0], "sort=alpha") {
+		t.Errorf("expected directory to be fetched in alphabetical order, got %v", hostio.requestedURL)
+	}
+	// switching back takes the directory loaded before
+	conf["google-fonts-sort"] = "popularity"
+	hostio.requestedURL = nil
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 0 || len(svc.directory(conf).Items) == 0 {
+		t.Errorf("expected directory of each sort order to be loaded once, got %v", hostio.requestedURL)
+	}
+	// families of equal confidence are ranked by name, whatever the directory order
+	family := func(name string) GoogleFontInfo {
+		return GoogleFontInfo{FontVariantsLocation: fontfind.FontVariantsLocation{Family: name}}
+	}
+	ranked := byConfidence{
+		infos:       []GoogleFontInfo{family("Noto Serif"), family("Inconsolata"), family("Noto Sans")},
+		confidences: []fontfind.MatchConfidence{fontfind.HighConfidence, fontfind.PerfectConfidence, fontfind.HighConfidence},
+	}
+	sort.Stable(ranked)
+	if ranked.infos[0].Family != "Inconsolata" || ranked.infos[1].Family != "Noto Sans" {
+		t.Errorf("expected ties to be broken alphabetically, have %v", ranked.infos)
+	}
+	if order := sortOrder(testconfig.Conf{"google-fonts-sort": "random"}); order != defaultSortOrder {
+		t.Errorf("expected invalid sort order to fall back to %s, have %s", defaultSortOrder, order)
+	}
+}
+
+func TestGoogleAPIEndpointOverride(t *testing.T) {
+	webfonts, err := os.ReadFile(filepath.Join("testdata", "webfonts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(webfonts)
+	}))
+	defer srv.Close()
+	svc := newGoogleService(USE_SYSTEM_IO)
+	conf := testconfig.Conf{
+		"app-key":              "tyse-test",
+		"fonts-cache-dir":      t.TempDir(),
+		"google-fonts-api-key": "test-key",
+		"google-fonts-api-url": srv.URL + "/webfonts?prettyPrint=false",
+	}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || !strings.HasPrefix(requests[0], "/webfonts?") ||
+		!strings.Contains(requests[0], "key=test-key") || !strings.Contains(requests[0], "prettyPrint=false") {
+		t.Fatalf("expected one API request to the test server, got %v", requests)
+	}
+	if n := len(svc.directory(conf).Items); n != 6 {
+		t.Errorf("expected 6 fonts from test server, got %d", n)
+	}
+	if _, err := apiRequestURL("ftp://fonts.example.com", nil); err == nil {
+		t.Errorf("expected non-HTTP endpoint to be rejected")
+	}
+	if u, _ := apiRequestURL(defaultGoogleFontsAPI, map[string][]string{"sort": {"alpha"}}); u !=
+		"https://www.googleapis.com/webfonts/v1/webfonts?sort=alpha" {
+		t.Errorf("expected default endpoint to be unchanged, got %q", u)
+	}
+}
+
+func TestGoogleRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select { // hang until the client gives up
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	svc := newGoogleService(USE_SYSTEM_IO)
+	conf := testconfig.Conf{
+		"app-key":                   "tyse-test",
+		"fonts-cache-dir":           t.TempDir(),
+		"google-fonts-api-key":      "test-key",
+		"google-fonts-api-url":      srv.URL + "/webfonts",
+		"google-fonts-retries":      0,
+		"google-fonts-http-timeout": "50ms",
+	}
+	start := time.Now()
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err == nil {
+		t.Fatal("expected request to a hung endpoint to fail")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected request to time out after 50ms, took %v", d)
+	}
+	if d := httpTimeout(testconfig.Conf{}); d != defaultHTTPTimeout {
+		t.Errorf("expected default timeout of %v, have %v", defaultHTTPTimeout, d)
+	}
+}
+
+func TestGoogleDirectoryCached(t *testing.T) {
+	hostio := newFakeIO(t)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	if err := newGoogleService(hostio).setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	hostio.requestedURL = nil
+	svc := newGoogleService(hostio)
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 0 {
+		t.Errorf("expected cached directory to be used, got %d API requests", len(hostio.requestedURL))
+	}
+	if len(svc.directory(conf).Items) == 0 {
+		t.Errorf("expected cached directory to contain fonts")
+	}
+}
+
+func TestGoogleDirectoryCacheExpired(t *testing.T) {
+	hostio := newFakeIO(t)
+	conf := testconfig.Conf{
+		"app-key":                  "tyse-test",
+		"google-fonts-cache-ttl":   "0s",
+		"google-fonts-retry-delay": "1ms",
+	}
+	if err := newGoogleService(hostio).setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	hostio.requestedURL = nil
+	if err := newGoogleService(hostio).setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 1 {
+		t.Errorf("expected outdated directory to be fetched again, got %d API requests", len(hostio.requestedURL))
+	}
+	// offline: an outdated cached directory is better than none
+	hostio.dirStatus = http.StatusServiceUnavailable
+	svc := newGoogleService(hostio)
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatalf("expected outdated cached directory to be used when offline, got %v", err)
+	}
+	if len(svc.directory(conf).Items) == 0 {
+		t.Errorf("expected cached directory to contain fonts")
+	}
+}
+
+func TestGoogleDirectoryExpiresInProcess(t *testing.T) {
+	hostio := newFakeIO(t)
+	root := t.TempDir()
+	conf := testconfig.Conf{
+		"app-key":                "tyse-test",
+		"fonts-cache-dir":        root,
+		"google-fonts-cache-ttl": "1h",
+	}
+	svc := newGoogleService(hostio)
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	hostio.requestedURL = nil
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil || len(hostio.requestedURL) != 0 {
+		t.Fatalf("expected loaded directory to be used, got %d API requests (%v)", len(hostio.requestedURL), err)
+	}
+	// two hours later, the directory loaded and the cached one are outdated
+	outdated := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, directoryCacheFile), outdated, outdated); err != nil {
+		t.Fatal(err)
+	}
+	svc.dirLock.Lock()
+	dir := svc.googleFontsDirs[directoryCacheFile]
+	dir.expires = outdated.Add(time.Hour)
+	svc.googleFontsDirs[directoryCacheFile] = dir
+	svc.dirLock.Unlock()
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 1 {
+		t.Errorf("expected expired directory to be fetched again, got %d API requests", len(hostio.requestedURL))
+	}
+	if fi, err := os.Stat(filepath.Join(root, directoryCacheFile)); err != nil || !fi.ModTime().After(outdated) {
+		t.Errorf("expected cached directory to be replaced (%v)", err)
+	}
+}
+
+type transportErrorIO struct {
+	*fakeIO
+}
+
+func (f transportErrorIO) HTTPGet(ctx context.Context, u string) (*http.Response, error) {
+	return nil, &url.Error{Op: "Get", URL: u, Err: errors.New("connection refused")}
+}
+
+// recordingTrace records all trace output, see tracing.SetTraceSelector.
+type recordingTrace struct {
+	mu  sync.Mutex
+	out strings.Builder
+}
+
+func (r *recordingTrace) Select(string) tracing.Trace            { return r }
+func (r *recordingTrace) Errorf(msg string, args ...interface{}) { r.printf(msg, args...) }
+func (r *recordingTrace) Infof(msg string, args ...interface{})  { r.printf(msg, args...) }
+func (r *recordingTrace) Debugf(msg string, args ...interface{}) { r.printf(msg, args...) }
+func (r *recordingTrace) P(string, interface{}) tracing.Trace    { return r }
+func (r *recordingTrace) SetTraceLevel(tracing.TraceLevel)       {}
+func (r *recordingTrace) GetTraceLevel() tracing.TraceLevel      { return tracing.LevelDebug }
+func (r *recordingTrace) SetOutput(io.Writer)                    {}
+
+func (r *recordingTrace) printf(msg string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(&r.out, msg+"\n", args...)
+}
+
+func TestGoogleAPIKeyRedactedFromTransportErrors(t *testing.T) {
+	trace := &recordingTrace{}
+	tracing.SetTraceSelector(trace)
+	defer tracing.SetTraceSelector(nil)
+	svc := newGoogleService(transportErrorIO{newFakeIO(t)})
+	conf := testconfig.Conf{
+		"app-key":              "tyse-test",
+		"google-fonts-api-key": "secret-test-key",
+		"google-fonts-retries": 0,
+	}
+	err := svc.setupGoogleFontsDirectory(context.Background(), conf)
+	if !errors.Is(err, locate.ErrNetworkFailure) {
+		t.Fatalf("expected network failure, have %v", err)
+	}
+	if strings.Contains(err.Error(), "secret-test-key") {
+		t.Errorf("expected API key to be redacted from error, have %v", err)
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	if out := trace.out.String(); strings.Contains(out, "secret-test-key") || !strings.Contains(out, "REDACTED") {
+		t.Errorf("expected API key to be redacted from trace, have %q", out)
+	}
+}
+
+func TestGoogleRequestsRetried(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key":                  "tyse-test",
+		"google-fonts-retries":     2,
+		"google-fonts-retry-delay": "1ms",
+	}
+	hostio.failures = 3
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err == nil {
+		t.Fatal("expected directory setup to fail after retries are used up")
+	}
+	if len(hostio.requestedURL) != 3 {
+		t.Errorf("expected 1 attempt + 2 retries, got %d requests", len(hostio.requestedURL))
+	}
+	hostio.requestedURL, hostio.failures = nil, 2
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err == nil || len(hostio.requestedURL) != 0 {
+		t.Fatalf("expected failed setup to be remembered, got %d requests (%v)", len(hostio.requestedURL), err)
+	}
+	svc.retryAfter = 0
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatalf("expected failed setup to be retried later, got %v", err)
+	}
+	hostio.requestedURL, hostio.failures = nil, 1
+	if _, err := svc.findGoogleFont(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal,
+		font.WeightNormal, fontfind.NoConfidence, false); err != nil {
+		t.Fatalf("expected font download to be retried, got %v", err)
+	}
+	if len(hostio.requestedURL) != 2 {
+		t.Errorf("expected 2 download requests, got %d", len(hostio.requestedURL))
+	}
+	hostio.requestedURL, hostio.dirStatus = nil, http.StatusForbidden
+	err := svc.refreshDirectory(context.Background(), conf)
+	if !errors.Is(err, locate.ErrNetworkFailure) || len(hostio.requestedURL) != 1 {
+		t.Errorf("expected permanent failure not to be retried, got %d requests (%v)", len(hostio.requestedURL), err)
+	}
+	var apiErr *GoogleAPIError
+	if !errors.Is(err, ErrGoogleAPI) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected Google API error with status 403, got %v", err)
+	}
+}
+
+func TestGoogleSetupWaitsForLoadInProgress(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key":                  "tyse-test",
+		"google-fonts-retries":     1,
+		"google-fonts-retry-delay": "1h",
+	}
+	hostio.failures = 1
+	loading, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { // sleeps in the backoff of its retry until canceled
+		done <- svc.setupGoogleFontsDirectory(loading, conf)
+	}()
+	for {
+		svc.loadLock.Lock()
+		inProgress := svc.loading != nil
+		svc.loadLock.Unlock()
+		if inProgress {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, stop := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer stop()
+	if err := svc.setupGoogleFontsDirectory(ctx, conf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected waiting caller to give up with its context, got %v", err)
+	}
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("expected canceled load to fail")
+	}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Errorf("expected canceled load not to be remembered as failed, got %v", err)
+	}
+}
+
+func TestGoogleSetupRecoversFromMissingAPIKey(t *testing.T) {
+	hostio := newFakeIO(t)
+	delete(hostio.env, "GOOGLE_FONTS_API_KEY")
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); !errors.Is(err, locate.ErrMissingAPIKey) || errors.Is(err, ErrGoogleAPI) {
+		t.Fatalf("expected directory setup to fail with ErrMissingAPIKey, got %v", err)
+	}
+	hostio.env["GOOGLE_FONTS_API_KEY"] = "test-key"
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatalf("expected setup to succeed once the API key is set, got %v", err)
+	}
+	hostio.requestedURL = nil
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil || len(hostio.requestedURL) != 0 {
+		t.Errorf("expected successful load to be remembered, got %d requests (%v)", len(hostio.requestedURL), err)
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := retryPolicy{retries: 5, delay: time.Hour}
+	calls := 0
+	err := policy.retry(ctx, "test request", func() error {
+		calls++
+		cancel()
+		return errors.New("transient")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected retry to stop when context is cancelled, got %d calls (%v)", calls, err)
+	}
 }
 
 func TestMatchFontname(t *testing.T) {
@@ -130,19 +600,22 @@ func TestGoogleFindFont(t *testing.T) {
 	conf := testconfig.Conf{
 		"app-key": "tyse-test",
 	}
-	f, err := svc.findGoogleFont(conf, "Inconsolata", font.StyleNormal, font.WeightNormal)
+	f, err := svc.findGoogleFont(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if f.Path() != "Inconsolata-regular.ttf" {
 		t.Fatalf("unexpected cached font name %q", f.Path())
 	}
-	_, err = svc.findGoogleFont(conf, "Inconsolata", font.StyleItalic, font.WeightNormal)
+	if f.Source != fontfind.SourceGoogle {
+		t.Errorf("expected font source google, is %s", f.Source)
+	}
+	_, err = svc.findGoogleFont(context.Background(), conf, "Inconsolata", "", "", font.StyleItalic, font.WeightNormal, fontfind.NoConfidence, false)
 	if err == nil {
 		t.Error("expected search for Inconsolata Italic to fail, did not")
 	}
 
-	f, err = svc.findGoogleFont(conf, "Anonymous Pro", font.StyleNormal, font.WeightNormal)
+	f, err = svc.findGoogleFont(context.Background(), conf, "Anonymous Pro", "", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -150,13 +623,216 @@ func TestGoogleFindFont(t *testing.T) {
 		t.Fatalf("expected regular variant, got %q", f.Path())
 	}
 
-	f, err = svc.findGoogleFont(conf, "Anonymous Pro", font.StyleItalic, font.WeightNormal)
+	f, err = svc.findGoogleFont(context.Background(), conf, "Anonymous Pro", "", "", font.StyleItalic, font.WeightNormal, fontfind.NoConfidence, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if f.Path() != "Anonymous Pro-italic.ttf" {
 		t.Fatalf("expected italic variant, got %q", f.Path())
 	}
+
+	_, err = svc.findGoogleFont(context.Background(), conf, "Anonymous Pro", "", "", font.StyleItalic, font.WeightNormal,
+		fontfind.PerfectConfidence, false)
+	if err != nil {
+		t.Errorf("expected perfect match for Anonymous Pro Italic, have %v", err)
+	}
+	_, err = svc.findGoogleFont(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal, font.WeightBold,
+		fontfind.PerfectConfidence, false)
+	if err == nil {
+		t.Error("expected search for Inconsolata Bold to fail if a perfect match is required, did not")
+	}
+}
+
+func TestGoogleMatchFuzzyFamily(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	fiList, err := svc.matchGoogleFontInfo(context.Background(), conf, "Inconsolta", "", "", font.StyleNormal,
+		font.WeightNormal, fontfind.NoConfidence, true, 0)
+	if err != nil || fiList[0].Family != "Inconsolata" {
+		t.Fatalf("expected Inconsolata for a typo, got %v (%v)", fiList, err)
+	}
+	_, err = svc.matchGoogleFontInfo(context.Background(), conf, "Inconsolta", "", "", font.StyleNormal,
+		font.WeightNormal, fontfind.PerfectConfidence, true, 0)
+	if err == nil {
+		t.Error("expected fuzzy match to fail if a perfect match is required, did not")
+	}
+	// fuzzy matching is opt-in for font lookup
+	find := Find(conf, hostio)
+	if f, err := find(fontfind.Descriptor{Pattern: "Inconsolta"}); err == nil {
+		t.Errorf("expected no font for a typo without fuzzy matching, have %s", f.Name)
+	}
+	if _, err := find(fontfind.Descriptor{Pattern: "Inconsolta", FuzzyFamily: true}); err != nil {
+		t.Errorf("expected Inconsolata for a typo with fuzzy matching, have %v", err)
+	}
+}
+
+func TestGoogleOffline(t *testing.T) {
+	hostio := newFakeIO(t)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+		"offline": true,
+	}
+	_, err := Find(conf, hostio)(fontfind.Descriptor{Pattern: "Inconsolata"})
+	if !errors.Is(err, locate.ErrFontNotFound) {
+		t.Errorf("expected font not found in offline mode, have %v", err)
+	}
+	svc := newGoogleService(hostio)
+	if _, err = svc.matchGoogleFontInfo(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal, font.WeightNormal,
+		fontfind.NoConfidence, false, 0); err == nil {
+		t.Errorf("expected Google font matching to fail in offline mode")
+	}
+	if len(hostio.requestedURL) != 0 {
+		t.Errorf("expected no requests in offline mode, have %v", hostio.requestedURL)
+	}
+}
+
+func TestGoogleMatchReturnsAllCandidates(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	fiList, err := svc.matchGoogleFontInfo(context.Background(), conf, "Noto", "", "", font.StyleItalic, font.WeightNormal, fontfind.NoConfidence, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Noto Sans Devanagari has no italic variant
+	if len(fiList) != 2 || fiList[0].Family != "Noto Sans" || fiList[1].Family != "Noto Serif" {
+		t.Fatalf("expected Noto Sans and Noto Serif to match, got %d candidates", len(fiList))
+	}
+	fiList, err = svc.matchGoogleFontInfo(context.Background(), conf, "o", "", "", font.StyleNormal, font.WeightBold, fontfind.NoConfidence, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := fontfind.PerfectConfidence
+	for _, fi := range fiList {
+		_, confidence := selectVariant(fi.Variants, font.StyleNormal, font.WeightBold)
+		if confidence > last {
+			t.Errorf("expected candidates sorted by descending confidence, %s is out of order", fi.Family)
+		}
+		last = confidence
+	}
+	limited, err := svc.matchGoogleFontInfo(context.Background(), conf, "o", "", "", font.StyleNormal, font.WeightBold, fontfind.NoConfidence, false, 2)
+	if err != nil || len(fiList) <= 2 || len(limited) != 2 ||
+		limited[0].Family != fiList[0].Family || limited[1].Family != fiList[1].Family {
+		t.Errorf("expected the 2 best of %d candidates, got %v (%v)", len(fiList), limited, err)
+	}
+	if n := maxMatches(testconfig.Conf{"google-fonts-max-matches": 3}); n != 3 || maxMatches(conf) != defaultMaxMatches {
+		t.Errorf("expected google-fonts-max-matches to limit matches, is %d", n)
+	}
+	fi, err := svc.bestGoogleFontInfo(context.Background(), conf, "Noto", "", "", font.StyleItalic, font.WeightNormal, fontfind.NoConfidence, false)
+	if err != nil || fi.Family != "Noto Sans" {
+		t.Errorf("expected best match to be Noto Sans, got %q (%v)", fi.Family, err)
+	}
+}
+
+func TestGoogleCategory(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	fiList, err := svc.matchGoogleFontInfo(context.Background(), conf, "o", "", "Monospace", font.StyleNormal, font.WeightNormal,
+		fontfind.NoConfidence, false, 0)
+	if err != nil || len(fiList) != 2 || fiList[0].Family != "Anonymous Pro" || fiList[1].Family != "Inconsolata" {
+		t.Errorf("expected monospace fonts Anonymous Pro and Inconsolata, got %v (%v)", fiList, err)
+	}
+	if _, err = svc.findGoogleFont(context.Background(), conf, "Noto", "", "handwriting", font.StyleNormal,
+		font.WeightNormal, fontfind.NoConfidence, false); err == nil {
+		t.Errorf("expected no handwriting font to match Noto")
+	}
+	fiList, err = svc.queryGoogleFonts(conf, "n", "sans")
+	if err != nil || len(fiList) != 3 || fiList[0].Family != "Antic" {
+		t.Errorf("expected 3 sans-serif fonts, got %v (%v)", fiList, err)
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []string{"regular", "italic", "700", "700italic"}
+	for _, tc := range []struct {
+		style   font.Style
+		weight  font.Weight
+		variant string
+	}{
+		{font.StyleNormal, font.WeightNormal, "regular"},
+		{font.StyleItalic, font.WeightNormal, "italic"},
+		{font.StyleNormal, font.WeightBold, "700"},
+		{font.StyleItalic, font.WeightBold, "700italic"},
+		{font.StyleOblique, font.WeightBold, "700italic"},
+	} {
+		v, confidence := selectVariant(variants, tc.style, tc.weight)
+		if v != tc.variant {
+			t.Errorf("style=%d, weight=%d: expected variant %s, got %s", tc.style, tc.weight, tc.variant, v)
+		}
+		if confidence <= fontfind.LowConfidence {
+			t.Errorf("style=%d, weight=%d: expected confidence above low, is %d", tc.style, tc.weight, confidence)
+		}
+	}
+	// a variant matching one axis only must not beat one matching both
+	if v, _ := selectVariant([]string{"700", "700italic", "italic"}, font.StyleItalic, font.WeightBold); v != "700italic" {
+		t.Errorf("expected 700italic to win over single-axis matches, got %s", v)
+	}
+}
+
+func TestGoogleFindFontWithSubset(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	f, err := svc.findGoogleFont(context.Background(), conf, "Noto", "devanagari", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path() != "Noto Sans Devanagari-regular.ttf" {
+		t.Errorf("expected Noto Sans Devanagari, got %q", f.Path())
+	}
+	if _, err = svc.findGoogleFont(context.Background(), conf, "Noto", "hebrew", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false); err == nil {
+		t.Errorf("expected no Noto font to support hebrew")
+	}
+	f, err = svc.findGoogleFont(context.Background(), conf, "Noto", "", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false)
+	if err != nil || f.Path() != "Noto Sans-regular.ttf" {
+		t.Errorf("expected empty subset to match any font, got %q (%v)", f.Path(), err)
+	}
+}
+
+func TestGoogleLocateFontDryRun(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	locator := func(ctx context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return svc.findGoogleFont(ctx, conf, d.Pattern, d.Subset, d.Category, d.Style, d.Weight, d.MinConfidence, false)
+	}
+	desc := fontfind.Descriptor{Pattern: "Inconsolata"}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	hostio.requestedURL = nil
+	report, err := locate.LocateFont(context.Background(), desc, locator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostio.requestedURL) != 0 {
+		t.Errorf("expected dry run not to download, have requests %v", hostio.requestedURL)
+	}
+	if report.Resolver != 0 || !strings.HasSuffix(report.URL, ".ttf") || report.Font.Source != fontfind.SourceGoogle {
+		t.Errorf("expected report of a download from Google, have %+v", report)
+	}
+	if _, err := locator(context.Background(), desc); err != nil {
+		t.Fatal(err)
+	}
+	report, err = locate.LocateFont(context.Background(), desc, locator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.URL != "" || report.Font.Path() != "Inconsolata-regular.ttf" || report.Font.File() != "" {
+		t.Errorf("expected report of cached font, have %+v", report)
+	}
 }
 
 func TestGoogleCacheFont(t *testing.T) {
@@ -165,15 +841,15 @@ func TestGoogleCacheFont(t *testing.T) {
 	conf := testconfig.Conf{
 		"app-key": "tyse-test",
 	}
-	fi, err := svc.matchGoogleFontInfo(conf, "Inconsolata", font.StyleNormal, font.WeightNormal)
+	fi, err := svc.matchGoogleFontInfo(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	cachedir, file, err := svc.cacheGoogleFont(conf, fi[0], "regular")
+	cache, file, err := svc.cacheGoogleFont(context.Background(), conf, fi[0], "regular")
 	if err != nil {
 		t.Fatal(err)
 	}
-	p := filepath.Join(cachedir, file)
+	p := cache.(*dirCache).file(file)
 	b, err := os.ReadFile(p)
 	if err != nil {
 		t.Fatal(err)
@@ -182,3 +858,157 @@ func TestGoogleCacheFont(t *testing.T) {
 		t.Fatalf("cached bytes differ from downloaded bytes")
 	}
 }
+
+func TestGoogleRefreshKeepsLastGoodDirectory(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key":                  "tyse-test",
+		"google-fonts-retry-delay": "1ms",
+	}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	hostio.dirStatus = http.StatusBadGateway
+	if err := svc.refreshDirectory(context.Background(), conf); err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		t.Fatalf("expected service to stay usable after failed refresh, got %v", err)
+	}
+	f, err := svc.findGoogleFont(context.Background(), conf, "Inconsolata", "", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false)
+	if err != nil {
+		t.Fatalf("expected stale directory to resolve Inconsolata, got %v", err)
+	}
+	if f.Path() != "Inconsolata-regular.ttf" {
+		t.Fatalf("unexpected cached font name %q", f.Path())
+	}
+}
+
+func TestPatternSyntax(t *testing.T) {
+	hostio := newFakeIO(t)
+	dec := json.NewDecoder(strings.NewReader(string(hostio.webfontsJSON)))
+	var list googleFontsList
+	if err := dec.Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Noto Sans", "Noto Sans Devanagari", "Noto Serif"}
+	for _, tc := range []struct {
+		pattern string
+		mode    MatchMode
+	}{
+		{"Noto*", MatchGlob},
+		{"noto", MatchSubstring},
+		{"^noto", MatchRegex},
+		{"NOTO ", MatchPrefix},
+	} {
+		matches, err := compilePattern(tc.pattern, tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var families []string
+		for _, fi := range list.Items {
+			if matches(fi.Family) {
+				families = append(families, fi.Family)
+			}
+		}
+		if strings.Join(families, "|") != strings.Join(expected, "|") {
+			t.Errorf("pattern %q (mode %d) matched %v", tc.pattern, tc.mode, families)
+		}
+	}
+}
+
+func TestMatchLiteralPattern(t *testing.T) {
+	matches, err := compilePattern("M PLUS 1p", MatchLiteral)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matches("m plus 1p") || matches("M PLUS 1p Rounded") || matches("MaPLUS 1p") {
+		t.Errorf("expected literal pattern to match the family name only")
+	}
+	if _, err = compilePattern("M PLUS (1p", MatchLiteral); err != nil {
+		t.Errorf("expected literal pattern to accept regex metacharacters, got %v", err)
+	}
+	if _, err = compilePattern("M PLUS (1p", MatchRegex); err == nil {
+		t.Errorf("expected invalid regular expression to be rejected")
+	}
+	for _, mode := range []MatchMode{MatchRegex, MatchGlob, MatchSubstring, MatchLiteral, MatchPrefix} {
+		matches, err = compilePattern("amatico  sc", mode)
+		if err != nil || !matches("Amático SC") {
+			t.Errorf("expected pattern mode %d to ignore diacritics and spacing (%v)", mode, err)
+		}
+	}
+	r1, _ := compiledRegexps.compile("^noto")
+	r2, _ := compiledRegexps.compile("^noto")
+	if r1 != r2 {
+		t.Errorf("expected compiled regular expression to be re-used")
+	}
+}
+
+func TestGoogleFindFontGlob(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key":                     "tyse-test",
+		"google-fonts-pattern-syntax": "glob",
+	}
+	f, err := svc.findGoogleFont(context.Background(), conf, "Incon*", "", "", font.StyleNormal, font.WeightNormal, fontfind.NoConfidence, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path() != "Inconsolata-regular.ttf" {
+		t.Fatalf("unexpected cached font name %q", f.Path())
+	}
+}
+
+func TestMatchGoogleFontsGlobSelectsVariants(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key":                     "tyse-test",
+		"google-fonts-pattern-syntax": "glob",
+	}
+	// "*Sans*" is not a valid regular expression, so variants must be selected without
+	// re-matching the family name against the pattern
+	fiList, err := svc.matchGoogleFontInfo(context.Background(), conf, "*Sans*", "", "", font.StyleNormal,
+		font.WeightNormal, fontfind.NoConfidence, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var families []string
+	for _, fi := range fiList {
+		families = append(families, fi.Family)
+	}
+	if strings.Join(families, "|") != "Noto Sans|Noto Sans Devanagari" {
+		t.Fatalf("unexpected families for glob pattern: %v", families)
+	}
+}
+
+func TestGoogleFontVariants(t *testing.T) {
+	hostio := newFakeIO(t)
+	svc := newGoogleService(hostio)
+	conf := testconfig.Conf{
+		"app-key": "tyse-test",
+	}
+	variants, err := svc.findGoogleFontVariants(conf, "anonymous pro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fontfind.Variant{
+		{Name: "regular", Style: font.StyleNormal, Weight: font.WeightNormal},
+		{Name: "italic", Style: font.StyleItalic, Weight: font.WeightNormal},
+		{Name: "700", Style: font.StyleNormal, Weight: font.WeightBold},
+		{Name: "700italic", Style: font.StyleItalic, Weight: font.WeightBold},
+	}
+	if len(variants) != len(expected) {
+		t.Fatalf("expected %d variants, got %v", len(expected), variants)
+	}
+	for i, v := range variants {
+		if v != expected[i] {
+			t.Errorf("expected variant %v, got %v", expected[i], v)
+		}
+	}
+	if _, err = svc.findGoogleFontVariants(conf, "Anonymous"); err == nil {
+		t.Errorf("expected family lookup to require the full family name")
+	}
+}
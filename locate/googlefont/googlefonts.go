@@ -1,17 +1,22 @@
 package googlefont
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"path"
-	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/schuko"
 	"github.com/npillmayer/schuko/tracing"
 	font "golang.org/x/image/font"
@@ -20,9 +25,10 @@ import (
 // GoogleFontInfo describes a font entry in the Google Font Service.
 type GoogleFontInfo struct {
 	fontfind.FontVariantsLocation
-	Version string            `json:"version"`
-	Subsets []string          `json:"subsets"`
-	Files   map[string]string `json:"files"`
+	Version  string            `json:"version"`
+	Subsets  []string          `json:"subsets"`
+	Category string            `json:"category"` // e.g. "sans-serif" or "monospace"
+	Files    map[string]string `json:"files"`
 }
 
 type googleFontsList struct {
@@ -32,13 +38,27 @@ type googleFontsList struct {
 const defaultGoogleFontsAPI = `https://www.googleapis.com/webfonts/v1/webfonts?`
 
 type googleService struct {
-	io IO
+	io    IO
+	cache FontCache // if nil, see fontCache
 
 	api string
 
-	loadGoogleFontsDir sync.Once
-	googleFontsDir     googleFontsList
-	googleFontsLoadErr error
+	loadLock        sync.Mutex                 // guards the load state below, not held while loading
+	loading         chan struct{}              // closed when the load in progress is done; nil if none
+	failedAt        time.Time                  // time of the last failed load, see retryAfter
+	failedAs        string                     // cache name of the directory which failed to load
+	failErr         error                      // error of the last failed load
+	retryAfter      time.Duration              // how long a failed load is remembered
+	dirLock         sync.RWMutex               // guards googleFontsDirs
+	googleFontsDirs map[string]loadedDirectory // loaded directories by cache name, see directoryCacheName
+	pruneLock       sync.Mutex                 // guards prunedAt
+	prunedAt        time.Time                  // time the cache was last pruned after a download
+}
+
+// loadedDirectory is a Google Fonts directory loaded by the service.
+type loadedDirectory struct {
+	list    googleFontsList
+	expires time.Time // when to load the directory again; zero for never, see directoryTTL
 }
 
 func newGoogleService(hostio IO) *googleService {
@@ -46,219 +66,895 @@ func newGoogleService(hostio IO) *googleService {
 		hostio = systemIO{}
 	}
 	return &googleService{
-		io:  hostio,
-		api: defaultGoogleFontsAPI,
+		io:         hostio,
+		api:        defaultGoogleFontsAPI,
+		retryAfter: defaultRetryAfter,
 	}
 }
 
 var defaultGoogleService = newGoogleService(nil)
 
+// ErrGoogleAPI is wrapped by errors of requests the Google Fonts API answered with an
+// HTTP status other than 200 OK. Use errors.As with a *GoogleAPIError to get the status,
+// e.g. to tell an invalid API key (403 Forbidden) from a service outage. To tell a
+// missing API key, use errors.Is with locate.ErrMissingAPIKey.
+var ErrGoogleAPI = errors.New("Google Fonts API error")
+
+// GoogleAPIError carries the HTTP status of a failed request to the Google Fonts API.
+// It matches ErrGoogleAPI and locate.ErrNetworkFailure with errors.Is.
+type GoogleAPIError struct {
+	StatusCode int    // e.g. 403
+	Status     string // e.g. "403 Forbidden"
+}
+
+func (e *GoogleAPIError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrGoogleAPI, e.Status)
+}
+
+func (e *GoogleAPIError) Is(target error) bool {
+	return target == ErrGoogleAPI || target == locate.ErrNetworkFailure
+}
+
+// checkOnline returns an error wrapping locate.ErrFontNotFound if configuration key
+// "offline" is set, e.g. in sandboxed or air-gapped environments. In offline mode,
+// Google fonts are neither looked up nor downloaded, not even from the cache, and
+// resolution falls through to the next resolver without waiting for network timeouts.
+func checkOnline(conf schuko.Configuration) error {
+	if conf.GetBool("offline") {
+		return fmt.Errorf("%w: Google Fonts are not available in offline mode", locate.ErrFontNotFound)
+	}
+	return nil
+}
+
 func setupGoogleFontsDirectory(conf schuko.Configuration) error {
-	return defaultGoogleService.setupGoogleFontsDirectory(conf)
-}
-
-func (svc *googleService) setupGoogleFontsDirectory(conf schuko.Configuration) (err error) {
-	svc.loadGoogleFontsDir.Do(func() {
-		tracer().Infof("setting up Google Fonts service directory")
-		apikey := conf.GetString("google-fonts-api-key")
-		if apikey == "" {
-			if apikey = svc.io.Getenv("GOOGLE_FONTS_API_KEY"); apikey == "" {
-				tracer().Errorf("Google fonts API key not set")
-				svc.googleFontsLoadErr = fmt.Errorf(`Google Fonts API-key must be set in global configuration or as GOOGLE_FONTS_API_KEY in environment;
-      please refer to https://developers.google.com/fonts/docs/developer_api`)
-				return
-			}
+	return defaultGoogleService.setupGoogleFontsDirectory(context.Background(), conf)
+}
+
+// defaultRetryAfter is how long a failed load of the directory is remembered, so that
+// lookups in quick succession do not each wait for the service to fail again.
+const defaultRetryAfter = 30 * time.Second
+
+// setupGoogleFontsDirectory loads the directory of Google fonts, from the cache or
+// from the Google Fonts service, if not already done. Concurrent callers wait for a
+// load in progress, or until ctx is done. A network failure is remembered for a short
+// while (see defaultRetryAfter), then the next call will try again.
+func (svc *googleService) setupGoogleFontsDirectory(ctx context.Context, conf schuko.Configuration) error {
+	if err := checkOnline(conf); err != nil {
+		return err
+	}
+	name := directoryCacheName(conf)
+	done, err := svc.beginLoad(ctx, func() (bool, error) {
+		if svc.hasDirectory(name) {
+			return true, nil
+		}
+		if svc.failErr != nil && svc.failedAs == name && time.Since(svc.failedAt) < svc.retryAfter {
+			return true, svc.failErr
+		}
+		return false, nil
+	})
+	if done == nil {
+		return err
+	}
+	err = svc.loadGoogleFontsDirectory(ctx, conf)
+	done(func() {
+		switch {
+		case err == nil:
+			svc.failErr = nil
+		case errors.Is(err, locate.ErrNetworkFailure) && ctx.Err() == nil:
+			svc.failedAt, svc.failedAs, svc.failErr = time.Now(), name, err
 		}
-		values := url.Values{
-			"sort": []string{"alpha"},
-			"key":  []string{apikey},
+	})
+	return err
+}
+
+// beginLoad waits until no load of the directory is in progress, then asks settled
+// if there is nothing to load (returning settled's error). Otherwise it marks a load
+// as in progress and returns a function ending it, which calls record to update the
+// load state. The loading caller does not hold svc.loadLock, thus a caller sleeping
+// in the backoff of a retry does not block others beyond their ctx.
+func (svc *googleService) beginLoad(ctx context.Context, settled func() (bool, error)) (
+	func(record func()), error) {
+	//
+	for {
+		svc.loadLock.Lock()
+		if ok, err := settled(); ok {
+			svc.loadLock.Unlock()
+			return nil, err
 		}
-		resp, getErr := svc.io.HTTPGet(svc.api + values.Encode())
-		if getErr != nil || resp == nil {
-			tracer().Errorf("Google Fonts API request not OK, error = %v", getErr)
-			svc.googleFontsLoadErr = fmt.Errorf("could not get fonts-directory from Google font service")
-			return
+		if svc.loading == nil {
+			break
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			tracer().Errorf("Google Fonts API request not OK, status = %d", resp.StatusCode)
-			svc.googleFontsLoadErr = fmt.Errorf("could not get fonts-directory from Google font service")
-			return
+		wait := svc.loading
+		svc.loadLock.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	loading := make(chan struct{})
+	svc.loading = loading
+	svc.loadLock.Unlock()
+	return func(record func()) {
+		svc.loadLock.Lock()
+		defer svc.loadLock.Unlock()
+		record()
+		svc.loading = nil
+		close(loading)
+	}, nil
+}
+
+// loadGoogleFontsDirectory loads the directory, from the cache if it is fresh, and
+// makes it the service's directory. The directory expires when the cached directory
+// does (see directoryTTL), so that long-running processes load it again. An outdated
+// directory, used because the service cannot be reached, expires after svc.retryAfter.
+func (svc *googleService) loadGoogleFontsDirectory(ctx context.Context, conf schuko.Configuration) error {
+	tracer().Infof("setting up Google Fonts service directory")
+	ttl := directoryTTL(conf)
+	list, modTime, cacheErr := svc.loadCachedDirectory(conf)
+	expires := modTime.Add(ttl)
+	if cacheErr != nil || ttl <= 0 || !time.Now().Before(expires) {
+		fetched, loadErr := svc.fetchGoogleFontsDirectory(ctx, conf)
+		if loadErr == nil {
+			list, expires = fetched, expiry(ttl)
+			svc.storeCachedDirectory(conf, list)
+		} else if cacheErr == nil {
+			tracer().Infof("cannot fetch Google Fonts directory, using outdated cached list: %v", loadErr)
+			expires = time.Now().Add(svc.retryAfter)
+		} else {
+			return loadErr
 		}
-		var list googleFontsList
-		dec := json.NewDecoder(resp.Body)
-		if decErr := dec.Decode(&list); decErr != nil {
-			svc.googleFontsLoadErr = fmt.Errorf("could not decode fonts-list from Google font service")
-			return
+	} else {
+		tracer().Infof("using cached list of %d Google fonts", len(list.Items))
+	}
+	svc.setDirectory(directoryCacheName(conf), list, expires)
+	return nil
+}
+
+// RefreshDirectory re-fetches the list of available fonts from the Google Fonts
+// service. If the refresh fails, the previously loaded directory is kept and
+// lookups continue to resolve from it; the refresh error is returned.
+func RefreshDirectory(conf schuko.Configuration) error {
+	return defaultGoogleService.refreshDirectory(context.Background(), conf)
+}
+
+func (svc *googleService) refreshDirectory(ctx context.Context, conf schuko.Configuration) error {
+	done, err := svc.beginLoad(ctx, func() (bool, error) { return false, nil })
+	if done == nil {
+		return err
+	}
+	list, err := svc.fetchGoogleFontsDirectory(ctx, conf)
+	if err != nil {
+		tracer().Errorf("refreshing Google Fonts directory failed, keeping previous one: %v", err)
+		done(func() {}) // the previous directory stays loaded
+		return err
+	}
+	svc.storeCachedDirectory(conf, list)
+	// a refresh supersedes the initial load
+	svc.setDirectory(directoryCacheName(conf), list, expiry(directoryTTL(conf)))
+	done(func() { svc.failErr = nil })
+	return nil
+}
+
+// directory returns the loaded Google Fonts directory for conf. Directories of
+// different sort orders and capabilities are kept side by side, so that clients
+// alternating between configurations do not load them again. An expired directory
+// is returned as well, until it has been loaded again.
+func (svc *googleService) directory(conf schuko.Configuration) googleFontsList {
+	svc.dirLock.RLock()
+	defer svc.dirLock.RUnlock()
+	return svc.googleFontsDirs[directoryCacheName(conf)].list
+}
+
+// hasDirectory returns true if the directory with cache name has been loaded and
+// has not yet expired.
+func (svc *googleService) hasDirectory(name string) bool {
+	svc.dirLock.RLock()
+	defer svc.dirLock.RUnlock()
+	dir, ok := svc.googleFontsDirs[name]
+	return ok && (dir.expires.IsZero() || time.Now().Before(dir.expires))
+}
+
+// setDirectory makes list the loaded directory with cache name, to be loaded again
+// after expires.
+func (svc *googleService) setDirectory(name string, list googleFontsList, expires time.Time) {
+	svc.dirLock.Lock()
+	defer svc.dirLock.Unlock()
+	if svc.googleFontsDirs == nil {
+		svc.googleFontsDirs = make(map[string]loadedDirectory)
+	}
+	svc.googleFontsDirs[name] = loadedDirectory{list: list, expires: expires}
+}
+
+// apiEndpoint returns the URL of the Google Fonts API. Configuration key
+// "google-fonts-api-url" overrides the service's default endpoint, e.g. for
+// a mirror, a proxy or a local test server.
+func (svc *googleService) apiEndpoint(conf schuko.Configuration) string {
+	if api := strings.TrimSpace(conf.GetString("google-fonts-api-url")); api != "" {
+		return api
+	}
+	return svc.api
+}
+
+// apiRequestURL adds query parameters values to endpoint, keeping query
+// parameters already present in endpoint.
+func apiRequestURL(endpoint string, values url.Values) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid Google Fonts API endpoint %q", endpoint)
+	}
+	query := u.Query()
+	for k, v := range values {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// googleFontsCapabilities are the values accepted for the capability parameter of
+// the Google Fonts API. "VF" asks for variable fonts, "WOFF2" for WOFF 2.0 files.
+var googleFontsCapabilities = map[string]bool{
+	"VF":    true,
+	"WOFF2": true,
+}
+
+// capabilities reads the capabilities to request from the Google Fonts API from
+// configuration key "google-fonts-capability", a comma-separated list like "VF,WOFF2".
+// Invalid values are dropped. If the key is unset, no capabilities are requested and
+// the service answers with its default set of static TrueType files.
+func capabilities(conf schuko.Configuration) []string {
+	var caps []string
+	for _, c := range strings.Split(conf.GetString("google-fonts-capability"), ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			continue
 		}
-		svc.googleFontsDir = list
-		tracer().Infof("transfered list of %d fonts from Google Fonts service",
-			len(svc.googleFontsDir.Items))
+		if !googleFontsCapabilities[c] {
+			tracer().Errorf("invalid Google Fonts capability %q, ignoring it", c)
+			continue
+		}
+		caps = append(caps, c)
+	}
+	sort.Strings(caps)
+	for i := 1; i < len(caps); i++ {
+		if caps[i] == caps[i-1] {
+			caps = append(caps[:i], caps[i+1:]...)
+			i--
+		}
+	}
+	return caps
+}
+
+// googleFontsSortOrders are the values accepted for the sort parameter of the Google
+// Fonts API.
+var googleFontsSortOrders = map[string]bool{
+	"alpha":      true, // by family name
+	"date":       true, // most recently updated first
+	"popularity": true, // most used first
+	"trending":   true, // growing in use first
+	"style":      true, // by number of styles, most first
+}
+
+// defaultSortOrder is the order of the Google Fonts directory if no order is configured.
+const defaultSortOrder = "alpha"
+
+// sortOrder reads the order of the Google Fonts directory from configuration key
+// "google-fonts-sort", one of "alpha" (the default), "date", "popularity", "trending"
+// or "style". The order of the directory is the order in which QueryGoogleFonts
+// returns families. It does not decide between families matching equally well,
+// see byConfidence.
+func sortOrder(conf schuko.Configuration) string {
+	order := strings.ToLower(strings.TrimSpace(conf.GetString("google-fonts-sort")))
+	if order == "" {
+		return defaultSortOrder
+	}
+	if !googleFontsSortOrders[order] {
+		tracer().Errorf("invalid google-fonts-sort %q, using %s", order, defaultSortOrder)
+		return defaultSortOrder
+	}
+	return order
+}
+
+// fetchGoogleFontsDirectory downloads and decodes the list of available fonts from
+// the Google Fonts service. It does not modify the service's directory.
+func (svc *googleService) fetchGoogleFontsDirectory(ctx context.Context, conf schuko.Configuration) (
+	googleFontsList, error) {
+	//
+	var list googleFontsList
+	apikey := conf.GetString("google-fonts-api-key")
+	if apikey == "" {
+		if apikey = svc.io.Getenv("GOOGLE_FONTS_API_KEY"); apikey == "" {
+			tracer().Errorf("Google fonts API key not set")
+			return list, fmt.Errorf(`%w: Google Fonts API-key must be set in global configuration or as GOOGLE_FONTS_API_KEY in environment;
+      please refer to https://developers.google.com/fonts/docs/developer_api`, locate.ErrMissingAPIKey)
+		}
+	}
+	values := url.Values{
+		"sort": []string{sortOrder(conf)},
+		"key":  []string{apikey},
+	}
+	if caps := capabilities(conf); len(caps) > 0 {
+		values["capability"] = caps
+	}
+	requestURL, err := apiRequestURL(svc.apiEndpoint(conf), values)
+	if err != nil {
+		return list, err
+	}
+	err = retryConfig(conf).retry(ctx, "Google Fonts API request", func() error {
+		reqctx, cancel := withRequestTimeout(ctx, conf)
+		defer cancel()
+		list, err = svc.requestGoogleFontsDirectory(reqctx, requestURL)
+		return err
 	})
-	return svc.googleFontsLoadErr
+	if err != nil {
+		return googleFontsList{}, err
+	}
+	tracer().Infof("transfered list of %d fonts from Google Fonts service", len(list.Items))
+	return list, nil
+}
+
+// requestGoogleFontsDirectory makes a single request for the directory of Google fonts.
+// Errors which will not go away by retrying are marked as permanent.
+func (svc *googleService) requestGoogleFontsDirectory(ctx context.Context, requestURL string) (googleFontsList, error) {
+	var list googleFontsList
+	resp, err := svc.io.HTTPGet(ctx, requestURL)
+	if err != nil || resp == nil {
+		err = redactError(err) // the request URL carries the API key
+		tracer().Errorf("Google Fonts API request not OK, error = %v", err)
+		return list, fmt.Errorf("%w: could not get fonts-directory from Google font service: %v",
+			locate.ErrNetworkFailure, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		tracer().Errorf("Google Fonts API request not OK, status = %d", resp.StatusCode)
+		err = fmt.Errorf("could not get fonts-directory from Google font service: %w",
+			&GoogleAPIError{StatusCode: resp.StatusCode, Status: resp.Status})
+		if !isTransientStatus(resp.StatusCode) {
+			err = permanent(err)
+		}
+		return list, err
+	}
+	dec := json.NewDecoder(resp.Body)
+	if err = dec.Decode(&list); err != nil {
+		return googleFontsList{}, fmt.Errorf("%w: could not decode fonts-list from Google font service",
+			locate.ErrNetworkFailure)
+	}
+	return list, nil
 }
 
 // FindGoogleFont resolves and caches a Google font matching pattern, style, and weight.
 // It returns a ScalableFont whose file system points at the local cache directory.
 func FindGoogleFont(conf schuko.Configuration, pattern string, style font.Style, weight font.Weight) (
 	fontfind.ScalableFont, error) {
-	return defaultGoogleService.findGoogleFont(conf, pattern, style, weight)
+	return defaultGoogleService.findGoogleFont(context.Background(), conf, pattern, "", "", style, weight,
+		fontfind.NoConfidence, false)
 }
 
-func (svc *googleService) findGoogleFont(conf schuko.Configuration, pattern string, style font.Style, weight font.Weight) (
+// FindGoogleFontWithSubset is like FindGoogleFont, but considers only font families
+// supporting subset (e.g., "devanagari" or "cyrillic"). An empty subset matches any
+// font family.
+func FindGoogleFontWithSubset(conf schuko.Configuration, pattern, subset string, style font.Style, weight font.Weight) (
 	fontfind.ScalableFont, error) {
+	return defaultGoogleService.findGoogleFont(context.Background(), conf, pattern, subset, "", style, weight,
+		fontfind.NoConfidence, false)
+}
+
+// findGoogleFont accepts fonts with a match-confidence of at least minConfidence,
+// see fontfind.AcceptMatch. Non-empty subset and category restrict the font families
+// considered, see hasSubset and hasCategory. If fuzzy is set, families with a name
+// similar to pattern are considered if no family matches it (see matchGoogleFontInfo).
+func (svc *googleService) findGoogleFont(ctx context.Context, conf schuko.Configuration, pattern, subset, category string,
+	style font.Style, weight font.Weight, minConfidence fontfind.MatchConfidence, fuzzy bool) (fontfind.ScalableFont, error) {
 	//
-	fiList, err := svc.matchGoogleFontInfo(conf, pattern, style, weight)
-	if err != nil {
+	if err := checkOnline(conf); err != nil {
 		return fontfind.NullFont, err
 	}
-	if len(fiList) == 0 {
-		return fontfind.NullFont, fmt.Errorf("no matching Google font found")
+	fi, err := svc.bestGoogleFontInfo(ctx, conf, pattern, subset, category, style, weight, minConfidence, fuzzy)
+	if err != nil {
+		return fontfind.NullFont, err
 	}
-	fi := fiList[0]
 	variant, confidence := selectVariant(fi.Variants, style, weight)
-	if confidence < fontfind.LowConfidence {
-		return fontfind.NullFont, fmt.Errorf("no suitable variant for %s (confidence=%d)", fi.Family, confidence)
+	if !fontfind.AcceptMatch(confidence, minConfidence) {
+		return fontfind.NullFont, fmt.Errorf("%w: no suitable variant for %s (confidence=%d)",
+			locate.ErrFontNotFound, fi.Family, confidence)
+	}
+	if locate.DryRun(ctx) {
+		f, err := svc.locateGoogleFont(ctx, conf, fi, variant, style, weight)
+		f.Confidence = confidence
+		return f, err
 	}
-	cachedir, name, err := svc.cacheGoogleFont(conf, fi, variant)
+	cache, name, err := svc.cacheGoogleFont(ctx, conf, fi, variant)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fontfind.NullFont, ctxErr
+		}
 		return fontfind.NullFont, err
 	}
-	fsys := svc.io.DirFS(cachedir)
+	f, err := cachedScalableFont(cache, name, style, weight)
+	f.Confidence = confidence
+	return f, err
+}
+
+// cachedScalableFont returns the font of cache entry name. The font reads its data
+// through the cache, or through the IO of the default cache. Only fonts cached in the
+// host's file system by the system IO tell their file (see fontfind.ScalableFont.File).
+func cachedScalableFont(cache FontCache, name string, style font.Style, weight font.Weight) (
+	fontfind.ScalableFont, error) {
+	//
 	sfnt := fontfind.ScalableFont{
-		Name:   name,
+		Name:   path.Base(name),
 		Style:  style,
 		Weight: weight,
+		Source: fontfind.SourceGoogle,
+	}
+	if dc, ok := cache.(*dirCache); ok {
+		if _, host := dc.io.(systemIO); host {
+			sfnt.SetFile(dc.file(name))
+		} else {
+			sfnt.SetFS(dc.io.DirFS(path.Dir(dc.file(name))), path.Base(name))
+		}
+	} else if sub, err := fs.Sub(cache, path.Dir(name)); err == nil {
+		sfnt.SetFS(sub, path.Base(name))
+	} else {
+		return fontfind.NullFont, err
 	}
-	sfnt.SetFS(fsys, name)
 	return sfnt, nil
 }
 
+// locateGoogleFont is the dry run of caching a font (see locate.LocateFont). It
+// returns the cached font, if present, or a font without data otherwise, reporting
+// the URL it would be downloaded from.
+func (svc *googleService) locateGoogleFont(ctx context.Context, conf schuko.Configuration, fi GoogleFontInfo,
+	variant string, style font.Style, weight font.Weight) (fontfind.ScalableFont, error) {
+	//
+	fileurl, base, err := googleFontFile(fi, variant)
+	if err != nil {
+		return fontfind.NullFont, err
+	}
+	cache, err := svc.fontCache(conf)
+	if err != nil {
+		return fontfind.NullFont, fmt.Errorf("%w: %w", locate.ErrCacheFailure, err)
+	}
+	ext := path.Ext(fileurl)
+	candidates := []string{base + ext}
+	if isWebFont(ext) {
+		candidates = []string{base + ".ttf", base + ".otf"} // decoded
+	}
+	for _, name := range candidates {
+		if cache.Has(name) {
+			return cachedScalableFont(cache, name, style, weight)
+		}
+	}
+	locate.ReportDownload(ctx, fileurl)
+	return fontfind.ScalableFont{
+		Name:   path.Base(candidates[0]),
+		Style:  style,
+		Weight: weight,
+		Source: fontfind.SourceGoogle,
+	}, nil
+}
+
+// selectVariant returns the variant best matching style and weight, together with
+// its match-confidence, i.e. the mean of style and weight confidence.
+//
+// Variants are compared by the sum of both confidences, so that a variant matching
+// both style and weight (e.g. "700italic") always wins over a variant matching only
+// one of them, even if the mean is rounded down.
 func selectVariant(variants []string, style font.Style, weight font.Weight) (variant string, confidence fontfind.MatchConfidence) {
+	var best fontfind.MatchConfidence
 	for _, v := range variants {
 		s := fontfind.MatchStyle(v, style)
 		w := fontfind.MatchWeight(v, weight)
-		c := (s + w) / 2
-		if c > confidence {
-			confidence = c
+		if s+w > best {
+			best = s + w
+			confidence = (s + w) / 2
 			variant = v
 		}
 	}
 	return
 }
 
-// matchGoogleFontInfo scans the Google Font Service for fonts matching pattern and
-// having a given style and weight.
+// MatchGoogleFonts scans the Google Font Service for fonts matching pattern and
+// having a given style and weight. It returns the matching font families, sorted
+// by descending match-confidence. Font families with equal confidence keep the
+// order of the Google font directory (see "google-fonts-sort"). At most the number
+// of families configured as "google-fonts-max-matches" (default 10) are returned,
+// the best ones first.
 //
 // It includes only fonts with a match-confidence greater than fontfind.LowConfidence.
+// If no font family matches pattern, families with a similar name are considered
+// with lower confidence (see fontfind.FuzzyFamilyMatch).
+// The pattern is interpreted as a regular expression, unless configuration key
+// "google-fonts-pattern-syntax" selects "glob" or "substring".
 //
 // A prerequisite to looking for Google fonts is a valid API-key (refer to
 // https://developers.google.com/fonts/docs/developer_api). It has to be configured
 // either in the application setup or as an environment variable GOOGLE_FONTS_API_KEY.
-func matchGoogleFontInfo(conf schuko.Configuration, pattern string, style font.Style, weight font.Weight) (
+func MatchGoogleFonts(conf schuko.Configuration, pattern string, style font.Style, weight font.Weight) (
 	[]GoogleFontInfo, error) {
-	return defaultGoogleService.matchGoogleFontInfo(conf, pattern, style, weight)
+	return defaultGoogleService.matchGoogleFontInfo(context.Background(), conf, pattern, "", "", style, weight,
+		fontfind.NoConfidence, true, maxMatches(conf))
+}
+
+// defaultMaxMatches is the default number of font families returned by MatchGoogleFonts.
+const defaultMaxMatches = 10
+
+// maxMatches reads the maximum number of font families returned by MatchGoogleFonts
+// from configuration key "google-fonts-max-matches" (default 10; 0 for no limit).
+func maxMatches(conf schuko.Configuration) int {
+	if !conf.IsSet("google-fonts-max-matches") {
+		return defaultMaxMatches
+	}
+	n := conf.GetInt("google-fonts-max-matches")
+	if n < 0 {
+		tracer().Errorf("invalid google-fonts-max-matches %d, using default", n)
+		return defaultMaxMatches
+	}
+	return n
 }
 
-func (svc *googleService) matchGoogleFontInfo(conf schuko.Configuration, pattern string, style font.Style, weight font.Weight) (
+// matchGoogleFontInfo returns up to limit matching font families, best first. The
+// limit is applied after ranking; a limit of 0 returns all matches. If fuzzy is set
+// and no family matches pattern, families with a similar name are considered with
+// lower confidence (see fontfind.FuzzyFamilyMatch).
+func (svc *googleService) matchGoogleFontInfo(ctx context.Context, conf schuko.Configuration, pattern, subset, category string,
+	style font.Style, weight font.Weight, minConfidence fontfind.MatchConfidence, fuzzy bool, limit int) (
 	[]GoogleFontInfo, error) {
 	//
 	var fiList []GoogleFontInfo
-	if err := svc.setupGoogleFontsDirectory(conf); err != nil {
+	if err := svc.setupGoogleFontsDirectory(ctx, conf); err != nil {
 		return fiList, err
 	}
-	r, err := regexp.Compile(strings.ToLower(pattern))
+	syntax := patternSyntax(conf, MatchRegex)
+	matches, err := compilePattern(pattern, syntax)
 	if err != nil {
 		return fiList, fmt.Errorf("cannot match Google font: invalid font name pattern: %v", err)
 	}
 	tracer().Debugf("trying to match (%s)", strings.ToLower(pattern))
-	for _, finfo := range svc.googleFontsDir.Items {
-		if r.MatchString(strings.ToLower(finfo.Family)) {
-			tracer().Debugf("Google font name matches pattern: %s", finfo.Family)
-			_, _, confidence := fontfind.ClosestMatch([]fontfind.FontVariantsLocation{finfo.FontVariantsLocation}, pattern,
-				style, weight)
-			if confidence > fontfind.LowConfidence {
-				fiList = append(fiList, finfo)
-				break
+	var confidences []fontfind.MatchConfidence
+	found := false // some family matches the pattern
+	collect := func(matches func(string) bool, penalty fontfind.MatchConfidence) {
+		for _, finfo := range svc.directory(conf).Items {
+			if matches(finfo.Family) {
+				tracer().Debugf("Google font name matches pattern: %s", finfo.Family)
+				found = true
+				if !hasSubset(finfo, subset) {
+					tracer().Debugf("Google font %s does not support subset %s", finfo.Family, subset)
+					continue
+				}
+				if !hasCategory(finfo, category) {
+					tracer().Debugf("Google font %s is not of category %s", finfo.Family, category)
+					continue
+				}
+				// select like findGoogleFont does; the family already matched the pattern
+				_, confidence := selectVariant(finfo.Variants, style, weight)
+				if confidence -= penalty; confidence < fontfind.NoConfidence {
+					confidence = fontfind.NoConfidence
+				}
+				if fontfind.AcceptMatch(confidence, minConfidence) {
+					fiList = append(fiList, finfo)
+					confidences = append(confidences, confidence)
+				}
 			}
 		}
 	}
+	collect(matches, 0)
+	if !found && fuzzy && syntax != MatchLiteral { // try families with a similar name, e.g. for a typo
+		collect(func(family string) bool {
+			return fontfind.FuzzyFamilyMatch(pattern, family)
+		}, 1)
+	}
 	if len(fiList) == 0 {
-		return fiList, errors.New("no Google font matches pattern")
+		return fiList, fmt.Errorf("%w: no Google font matches pattern", locate.ErrFontNotFound)
+	}
+	sort.Stable(byConfidence{fiList, confidences})
+	if limit > 0 && len(fiList) > limit {
+		fiList = fiList[:limit]
 	}
-	tracer().Debugf("found Google font: %v", fiList[0])
+	tracer().Debugf("found %d Google fonts, best match: %v", len(fiList), fiList[0])
 	return fiList, nil
 }
 
+// bestGoogleFontInfo returns the best match of matchGoogleFontInfo.
+func (svc *googleService) bestGoogleFontInfo(ctx context.Context, conf schuko.Configuration, pattern, subset, category string,
+	style font.Style, weight font.Weight, minConfidence fontfind.MatchConfidence, fuzzy bool) (GoogleFontInfo, error) {
+	//
+	fiList, err := svc.matchGoogleFontInfo(ctx, conf, pattern, subset, category, style, weight, minConfidence, fuzzy, 1)
+	if err != nil {
+		return GoogleFontInfo{}, err
+	}
+	return fiList[0], nil
+}
+
+// hasSubset returns true if a font family supports subset. An empty subset is
+// supported by every font family.
+func hasSubset(fi GoogleFontInfo, subset string) bool {
+	if subset == "" {
+		return true
+	}
+	for _, s := range fi.Subsets {
+		if strings.EqualFold(s, subset) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCategory returns true if a font family is of category, e.g. "monospace". Categories
+// are compared case-insensitively, and "sans" is short for "sans-serif". An empty
+// category matches every font family.
+func hasCategory(fi GoogleFontInfo, category string) bool {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		return true
+	}
+	if category == "sans" {
+		category = "sans-serif"
+	}
+	return strings.EqualFold(fi.Category, category)
+}
+
+// byConfidence sorts font infos by descending match-confidence. Families of equal
+// confidence are sorted by name, so that the best match does not depend on the
+// sort order of the directory (see sortOrder).
+type byConfidence struct {
+	infos       []GoogleFontInfo
+	confidences []fontfind.MatchConfidence
+}
+
+func (b byConfidence) Len() int { return len(b.infos) }
+func (b byConfidence) Less(i, j int) bool {
+	if b.confidences[i] != b.confidences[j] {
+		return b.confidences[i] > b.confidences[j]
+	}
+	return b.infos[i].Family < b.infos[j].Family
+}
+func (b byConfidence) Swap(i, j int) {
+	b.infos[i], b.infos[j] = b.infos[j], b.infos[i]
+	b.confidences[i], b.confidences[j] = b.confidences[j], b.confidences[i]
+}
+
+// FindGoogleFontVariants returns all variants the Google Fonts service offers
+// for a font family. family must be a family name, not a pattern; it is compared
+// case-insensitively.
+func FindGoogleFontVariants(conf schuko.Configuration, family string) ([]fontfind.Variant, error) {
+	return defaultGoogleService.findGoogleFontVariants(conf, family)
+}
+
+func (svc *googleService) findGoogleFontVariants(conf schuko.Configuration, family string) ([]fontfind.Variant, error) {
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		return nil, err
+	}
+	for _, finfo := range svc.directory(conf).Items {
+		if strings.EqualFold(finfo.Family, family) {
+			variants := make([]fontfind.Variant, 0, len(finfo.Variants))
+			for _, v := range finfo.Variants {
+				variants = append(variants, fontfind.ParseVariant(v))
+			}
+			return variants, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no Google font family %s", locate.ErrFontNotFound, family)
+}
+
 // ---------------------------------------------------------------------------
 
 // cacheGoogleFont loads a font described by fi with a given variant.
-// The loaded font is cached in the user's cache directory.
-func (svc *googleService) cacheGoogleFont(conf schuko.Configuration, fi GoogleFontInfo, variant string) (
-	cachedir, name string, err error) {
+// The loaded font is stored in the font cache (see FontCache), in a sub-folder named
+// after the first letter of the family. Returns the cache and the name of the entry.
+func (svc *googleService) cacheGoogleFont(ctx context.Context, conf schuko.Configuration, fi GoogleFontInfo, variant string) (
+	cache FontCache, name string, err error) {
 	//
-	var fileurl string
+	fileurl, base, err := googleFontFile(fi, variant)
+	if err != nil {
+		return nil, "", err
+	}
+	if cache, err = svc.fontCache(conf); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", locate.ErrCacheFailure, err)
+	}
+	if dc, ok := cache.(*dirCache); ok {
+		defer startDownload(dc.file(base))() // protect from pruning
+	}
+	ext := path.Ext(fileurl)
+	if isWebFont(ext) {
+		name, err = svc.cacheWebFont(ctx, conf, cache, base, ext, fileurl)
+	} else {
+		name = base + ext
+		err = svc.cacheFile(ctx, conf, cache, name, fileurl)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot cache %s (%s): %w", fi.Family, variant, err)
+	}
+	return
+}
+
+// googleFontFile returns the URL of a variant of font fi, and the name of its cache
+// entry without extension, e.g. "I/Inconsolata-regular".
+func googleFontFile(fi GoogleFontInfo, variant string) (fileurl, base string, err error) {
 	for _, v := range fi.Variants {
 		if v == variant {
 			fileurl = fi.Files[v]
 		}
 	}
 	if fileurl == "" {
-		return "", "", fmt.Errorf("no variant equals %s, cannot cache %s", variant, fi.Family)
+		return "", "", fmt.Errorf("%w: no variant equals %s, cannot cache %s", locate.ErrFontNotFound, variant, fi.Family)
 	}
 	letter := strings.ToUpper(fi.Family[:1])
-	cachedir, err = cacheFontDirPath(svc.io, conf, letter)
+	return fileurl, path.Join(letter, fi.Family+"-"+variant), nil
+}
+
+// cacheFile downloads fileurl to cache entry name, if not already present. Failed
+// downloads are retried as configured (see retryConfig). A checksum of the download
+// is kept next to it, and a cached file not matching its checksum is downloaded again.
+// A cached file is marked as used, and after a download the font cache is pruned
+// as configured (see PruneCache).
+func (svc *googleService) cacheFile(ctx context.Context, conf schuko.Configuration, cache FontCache,
+	name, fileurl string) error {
+	//
+	tracer().Infof("caching font as %s", name)
+	if cache.Has(name) {
+		if verifyChecksum(cache, name) {
+			tracer().Infof("font already cached: %s", name)
+			touchCached(cache, name)
+			return nil
+		}
+		tracer().Errorf("cached font %s is corrupt, downloading it again", name)
+	}
+	err := retryConfig(conf).retry(ctx, "font download", func() error {
+		reqCtx, cancel := withRequestTimeout(ctx, conf)
+		defer cancel()
+		return downloadCachedFile(reqCtx, svc.io, cache, name, fileurl, downloadProgress(ctx))
+	})
+	if err == nil {
+		writeChecksum(cache, name)
+		if pruneErr := svc.prunePeriodically(conf); pruneErr != nil {
+			tracer().Errorf("cannot prune font cache: %v", pruneErr)
+		}
+	}
+	return err
+}
+
+// cacheWebFont caches a font delivered in a web font format (WOFF). The
+// downloaded file is decoded and stored as a TrueType/OpenType file next to it.
+// Returns the name of the decoded entry. If decoding fails, the downloaded file
+// is left in the cache, but no decoded file is created.
+func (svc *googleService) cacheWebFont(ctx context.Context, conf schuko.Configuration, cache FontCache,
+	base, ext, fileurl string) (string, error) {
+	//
+	for _, sfntExt := range []string{".ttf", ".otf"} {
+		decoded := base + sfntExt
+		if cache.Has(decoded) {
+			if verifyChecksum(cache, decoded) {
+				tracer().Infof("font already cached: %s", decoded)
+				touchCached(cache, decoded)
+				return decoded, nil
+			}
+			tracer().Errorf("cached font %s is corrupt, decoding it again", decoded)
+		}
+	}
+	webfont := base + ext
+	if err := svc.cacheFile(ctx, conf, cache, webfont, fileurl); err != nil {
+		return "", err
+	}
+	data, err := fs.ReadFile(cache, webfont)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
-	ext := path.Ext(fileurl)
-	name = fi.Family + "-" + variant + ext
-	filepath := path.Join(cachedir, name)
-	tracer().Infof("caching font %s as %s", fi.Family, filepath)
-	if _, err := svc.io.Stat(filepath); err == nil {
-		tracer().Infof("font already cached: %s", filepath)
-	} else {
-		err = downloadCachedFile(svc.io, filepath, fileurl)
+	decoded, sfntExt, err := decodeWebFont(data, ext)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode web font %s: %w", webfont, err)
 	}
-	return
+	name := base + sfntExt
+	tracer().Infof("decoded web font %s to %s", webfont, name)
+	// Put writes to a temporary file which is renamed when complete (see dirCache.Put),
+	// so an interrupted write never leaves a truncated font
+	if err = cache.Put(name, bytes.NewReader(decoded)); err != nil {
+		return "", err
+	}
+	writeChecksum(cache, name)
+	return name, nil
 }
 
 // ---------------------------------------------------------------------------
 
-// ListGoogleFonts produces a listing of available fonts from the Google webfont
-// service, with font-family names matching a given pattern.
-// Output goes into the trace file with log-level info.
+// QueryGoogleFonts returns the entries of the Google webfont service's directory
+// with font-family names matching a given pattern, in directory order. If no
+// family matches, an empty list is returned without error.
+//
+// The pattern is matched as a case-insensitive substring, unless configuration key
+// "google-fonts-pattern-syntax" selects "glob" or "regex".
 //
 // If not already done, the list of available fonts will be downloaded from Google.
-func ListGoogleFonts(conf schuko.Configuration, pattern string) {
-	defaultGoogleService.listGoogleFonts(conf, pattern)
+func QueryGoogleFonts(conf schuko.Configuration, pattern string) ([]GoogleFontInfo, error) {
+	return defaultGoogleService.queryGoogleFonts(conf, pattern, "")
 }
 
-func (svc *googleService) listGoogleFonts(conf schuko.Configuration, pattern string) {
-	level := tracer().GetTraceLevel()
-	tracer().SetTraceLevel(tracing.LevelInfo)
-	if err := svc.setupGoogleFontsDirectory(conf); err != nil {
-		tracer().Errorf("unable to list Google fonts: %v", err)
-	} else {
-		listGoogleFonts(svc.googleFontsDir, pattern)
+func (svc *googleService) queryGoogleFonts(conf schuko.Configuration, pattern, category string) ([]GoogleFontInfo, error) {
+	if err := svc.setupGoogleFontsDirectory(context.Background(), conf); err != nil {
+		return nil, err
 	}
-	tracer().SetTraceLevel(level)
+	return queryGoogleFonts(svc.directory(conf), pattern, patternSyntax(conf, MatchSubstring), category)
 }
 
-func listGoogleFonts(list googleFontsList, pattern string) {
-	r, err := regexp.Compile(pattern)
+// QueryGoogleFontsInCategory is like QueryGoogleFonts, but returns only font families
+// of a category, e.g. "monospace". Categories are compared case-insensitively, and
+// "sans" is short for "sans-serif". An empty category matches every font family.
+func QueryGoogleFontsInCategory(conf schuko.Configuration, pattern, category string) ([]GoogleFontInfo, error) {
+	return defaultGoogleService.queryGoogleFonts(conf, pattern, category)
+}
+
+// QueryGoogleFontsPage returns a page of the result of QueryGoogleFonts, e.g. for a UI
+// paging through the Google Fonts catalog: up to limit entries, starting at entry offset.
+// total is the number of all matching entries. A limit of 0 returns all entries from
+// offset on; an offset beyond the last entry returns an empty page.
+//
+// Entries keep the order of the Google Fonts directory (alphabetical, unless configured
+// otherwise with "google-fonts-sort"), so pages are stable as long as the directory is
+// not refreshed.
+func QueryGoogleFontsPage(conf schuko.Configuration, pattern string, offset, limit int) (
+	page []GoogleFontInfo, total int, err error) {
+	//
+	return defaultGoogleService.queryGoogleFontsPage(conf, pattern, offset, limit)
+}
+
+func (svc *googleService) queryGoogleFontsPage(conf schuko.Configuration, pattern string, offset, limit int) (
+	[]GoogleFontInfo, int, error) {
+	//
+	fiList, err := svc.queryGoogleFonts(conf, pattern, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(fiList)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(offset+limit, total)
+	}
+	return fiList[offset:end], total, nil
+}
+
+func queryGoogleFonts(list googleFontsList, pattern string, mode MatchMode, category string) ([]GoogleFontInfo, error) {
+	matches, err := compilePattern(pattern, mode)
 	if err != nil {
-		tracer().Errorf("cannot list Google fonts: invalid pattern: %v", err)
+		return nil, fmt.Errorf("cannot query Google fonts: invalid pattern: %v", err)
+	}
+	fiList := []GoogleFontInfo{}
+	for _, finfo := range list.Items {
+		if matches(finfo.Family) && hasCategory(finfo, category) {
+			fiList = append(fiList, finfo)
+		}
 	}
-	tracer().Infof("%d fonts in Google font list", len(list.Items))
+	return fiList, nil
+}
+
+// ListGoogleFonts produces a listing of available fonts from the Google webfont
+// service, with font-family names matching a given pattern.
+// Output goes into the trace file with log-level info.
+//
+// ListGoogleFonts prints the result of QueryGoogleFonts. Clients who want to
+// process the list should call QueryGoogleFonts instead.
+func ListGoogleFonts(conf schuko.Configuration, pattern string) {
+	fiList, err := QueryGoogleFonts(conf, pattern)
+	if err != nil {
+		tracer().Errorf("unable to list Google fonts: %v", err)
+		return
+	}
+	printGoogleFonts(fiList)
+}
+
+// printGoogleFonts writes a list of Google fonts to the trace. The trace level is
+// raised to info while printing only.
+func printGoogleFonts(fiList []GoogleFontInfo) {
+	level := tracer().GetTraceLevel()
+	tracer().SetTraceLevel(tracing.LevelInfo)
+	defer tracer().SetTraceLevel(level)
+	tracer().Infof("%d fonts in Google font list match", len(fiList))
 	tracer().Infof("======================================")
-	for i, finfo := range list.Items {
-		if r.MatchString(finfo.Family) {
-			tracer().Infof("[%4d] %-20s: %s", i, finfo.Family, finfo.Version)
-			tracer().Infof("       subsets: %v", finfo.Subsets)
-			for k, v := range finfo.Files {
-				tracer().Infof("       - %-18s: %s", k, v[len(v)-4:])
-			}
+	for i, finfo := range fiList {
+		tracer().Infof("[%4d] %-20s: %s", i, finfo.Family, finfo.Version)
+		tracer().Infof("       subsets: %v", finfo.Subsets)
+		for k, v := range finfo.Files {
+			tracer().Infof("       - %-18s: %s", k, v[len(v)-4:])
 		}
 	}
 }
@@ -1,17 +1,21 @@
 package googlefont
 
 import (
+	"context"
 	"io"
 	"io/fs"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/npillmayer/schuko"
 )
 
 // IO abstracts host environment access for Google-font lookup and caching.
 // It allows tests to replace OS and network interactions with deterministic fakes.
 type IO interface {
 	Getenv(string) string
-	HTTPGet(string) (*http.Response, error)
+	HTTPGet(context.Context, string) (*http.Response, error)
 	UserCacheDir() (string, error)
 	DirFS(string) fs.FS
 	Stat(string) (os.FileInfo, error)
@@ -25,8 +29,12 @@ func (systemIO) Getenv(k string) string {
 	return os.Getenv(k)
 }
 
-func (systemIO) HTTPGet(u string) (*http.Response, error) {
-	return http.Get(u)
+func (systemIO) HTTPGet(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
 }
 
 func (systemIO) UserCacheDir() (string, error) {
@@ -48,3 +56,81 @@ func (systemIO) MkdirAll(path string, perm fs.FileMode) error {
 func (systemIO) Create(path string) (io.WriteCloser, error) {
 	return os.Create(path)
 }
+
+// Toucher is an optional interface of IO, setting the modification time of a file.
+// The font cache directory uses it to mark cached fonts as used (see PruneCache).
+// If an IO does not implement it, fonts age from the time they were downloaded.
+type Toucher interface {
+	Chtimes(path string, atime, mtime time.Time) error
+}
+
+func (systemIO) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+// Remover is an optional interface of IO, removing a file. The font cache directory
+// uses it to discard incomplete downloads and pruned fonts. If an IO does not
+// implement it, files are removed from the host's file system with os.Remove.
+type Remover interface {
+	Remove(path string) error
+}
+
+func (systemIO) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// remove removes file path, using fsys if it implements Remover.
+func remove(fsys IO, path string) error {
+	if r, ok := fsys.(Remover); ok {
+		return r.Remove(path)
+	}
+	return os.Remove(path)
+}
+
+// Renamer is an optional interface of IO, renaming a file. The font cache directory
+// uses it to move completed downloads into place. If an IO does not implement it,
+// files are renamed on the host's file system with os.Rename.
+type Renamer interface {
+	Rename(oldpath, newpath string) error
+}
+
+func (systemIO) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// rename renames file oldpath to newpath, using fsys if it implements Renamer.
+func rename(fsys IO, oldpath, newpath string) error {
+	if r, ok := fsys.(Renamer); ok {
+		return r.Rename(oldpath, newpath)
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+// defaultHTTPTimeout limits a single request to the Google Fonts service, including
+// reading the response.
+const defaultHTTPTimeout = 30 * time.Second
+
+// httpTimeout reads the timeout of requests to the Google Fonts service from
+// configuration key "google-fonts-http-timeout" (a duration, default 30s; 0 disables
+// the timeout).
+func httpTimeout(conf schuko.Configuration) time.Duration {
+	if !conf.IsSet("google-fonts-http-timeout") {
+		return defaultHTTPTimeout
+	}
+	d, err := time.ParseDuration(conf.GetString("google-fonts-http-timeout"))
+	if err != nil || d < 0 {
+		tracer().Errorf("invalid google-fonts-http-timeout, using default: %v", err)
+		return defaultHTTPTimeout
+	}
+	return d
+}
+
+// withRequestTimeout derives a context for a single request from ctx, which is
+// cancelled after the configured HTTP timeout (see httpTimeout). This keeps a hung
+// endpoint from blocking a lookup forever, even if ctx has no deadline.
+func withRequestTimeout(ctx context.Context, conf schuko.Configuration) (context.Context, context.CancelFunc) {
+	if timeout := httpTimeout(conf); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
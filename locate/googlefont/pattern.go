@@ -0,0 +1,145 @@
+package googlefont
+
+import (
+	"container/list"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/schuko"
+)
+
+// MatchMode selects how a font-family pattern is interpreted.
+// All modes match case-insensitively.
+type MatchMode int
+
+const (
+	MatchRegex     MatchMode = iota // pattern is an RE2 regular expression
+	MatchGlob                       // pattern is a glob, e.g. "Noto*"
+	MatchSubstring                  // pattern is a plain substring of the family name
+	MatchLiteral                    // pattern is the family name, e.g. "M PLUS 1p"
+	MatchPrefix                     // pattern is a plain prefix of the family name
+)
+
+var matchModeNames = map[string]MatchMode{
+	"regex":     MatchRegex,
+	"glob":      MatchGlob,
+	"substring": MatchSubstring,
+	"literal":   MatchLiteral,
+	"prefix":    MatchPrefix,
+}
+
+// patternSyntax reads the match mode from configuration key
+// "google-fonts-pattern-syntax" (one of "regex", "glob", "substring", "literal", "prefix").
+// If the key is unset or invalid, dflt is returned.
+func patternSyntax(conf schuko.Configuration, dflt MatchMode) MatchMode {
+	name := strings.ToLower(strings.TrimSpace(conf.GetString("google-fonts-pattern-syntax")))
+	if name == "" {
+		return dflt
+	}
+	if mode, ok := matchModeNames[name]; ok {
+		return mode
+	}
+	tracer().Errorf("invalid font pattern syntax %q, using default", name)
+	return dflt
+}
+
+// familyMatcher is a predicate on font-family names.
+type familyMatcher func(family string) bool
+
+// compilePattern creates a family-name matcher for pattern, interpreted according to mode.
+// Plain names (substring, literal and prefix patterns, and globs or regular
+// expressions without operators) are compared in normalized form, ignoring
+// diacritics, spacing and punctuation (see fontfind.NormalizeFamily).
+func compilePattern(pattern string, mode MatchMode) (familyMatcher, error) {
+	switch mode {
+	case MatchGlob:
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			pattern = fontfind.NormalizeFamily(pattern)
+		}
+		return func(family string) bool {
+			ok, _ := path.Match(pattern, strings.ToLower(family))
+			if !ok {
+				ok, _ = path.Match(pattern, fontfind.NormalizeFamily(family))
+			}
+			return ok
+		}, nil
+	case MatchSubstring:
+		pattern = fontfind.NormalizeFamily(pattern)
+		return func(family string) bool {
+			return strings.Contains(fontfind.NormalizeFamily(family), pattern)
+		}, nil
+	case MatchLiteral:
+		pattern = fontfind.NormalizeFamily(pattern)
+		return func(family string) bool {
+			return fontfind.NormalizeFamily(family) == pattern
+		}, nil
+	case MatchPrefix:
+		pattern = fontfind.NormalizeFamily(pattern)
+		return func(family string) bool {
+			return strings.HasPrefix(fontfind.NormalizeFamily(family), pattern)
+		}, nil
+	}
+	if regexp.QuoteMeta(pattern) == pattern {
+		pattern = fontfind.NormalizeFamily(pattern)
+	} else {
+		pattern = strings.ToLower(pattern)
+	}
+	r, err := compiledRegexps.compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+	}
+	return func(family string) bool {
+		return r.MatchString(strings.ToLower(family)) || r.MatchString(fontfind.NormalizeFamily(family))
+	}, nil
+}
+
+// regexpCacheSize is the number of compiled regular expressions kept for re-use.
+const regexpCacheSize = 32
+
+// regexpCache is a size-bounded LRU cache for compiled font-family patterns, as
+// clients tend to ask for the same few patterns over and over.
+type regexpCache struct {
+	sync.Mutex
+	order   *list.List // of *regexpEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type regexpEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+var compiledRegexps = &regexpCache{
+	order:   list.New(),
+	entries: make(map[string]*list.Element),
+}
+
+// compile returns the compiled regular expression for pattern, compiling it
+// if not found in the cache.
+func (c *regexpCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*regexpEntry).re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[pattern] = c.order.PushFront(&regexpEntry{pattern: pattern, re: re})
+	for c.order.Len() > regexpCacheSize {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*regexpEntry).pattern)
+	}
+	return re, nil
+}
@@ -0,0 +1,233 @@
+package googlefont
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/fontregistry"
+	"github.com/npillmayer/schuko"
+)
+
+// PruneOptions limit the font cache directory. Zero values do not limit the cache.
+type PruneOptions struct {
+	MaxBytes int64         // maximum total size of cached fonts
+	MaxAge   time.Duration // maximum age of a cached font
+}
+
+// PruneCache removes fonts from the font cache directory (see SetFontCache) which
+// have not been used for longer than opts.MaxAge, and then the least recently used
+// fonts, until the cached fonts take up no more than opts.MaxBytes. Fonts are removed
+// together with their checksums. The last use of a font is taken from its
+// modification time, which is set when the font is downloaded and whenever it is
+// found in the cache (if the IO implements Toucher). Fonts currently being
+// downloaded are never removed, nor are fonts in the global font registry
+// (see fontregistry.GlobalRegistry) or the cached Google Fonts directory, which
+// expires by itself.
+//
+// After downloads, the cache is pruned with options taken from configuration keys
+// "fonts-cache-max-bytes" (a number of bytes) and "fonts-cache-max-age" (a duration,
+// e.g. "720h"), at most once per "fonts-cache-prune-interval" (a duration, default
+// 1h). Caches set with SetFontCache are not pruned.
+func PruneCache(conf schuko.Configuration, opts PruneOptions) error {
+	return defaultGoogleService.pruneCache(conf, opts)
+}
+
+// pruneConfig reads the options for pruning the cache after downloads from
+// configuration keys "fonts-cache-max-bytes" and "fonts-cache-max-age".
+func pruneConfig(conf schuko.Configuration) PruneOptions {
+	var opts PruneOptions
+	if conf.IsSet("fonts-cache-max-bytes") {
+		if n := conf.GetInt("fonts-cache-max-bytes"); n >= 0 {
+			opts.MaxBytes = int64(n)
+		} else {
+			tracer().Errorf("invalid fonts-cache-max-bytes %d, not limiting cache size", n)
+		}
+	}
+	if conf.IsSet("fonts-cache-max-age") {
+		d, err := time.ParseDuration(conf.GetString("fonts-cache-max-age"))
+		if err != nil || d < 0 {
+			tracer().Errorf("invalid fonts-cache-max-age, not limiting age of cached fonts: %v", err)
+		} else {
+			opts.MaxAge = d
+		}
+	}
+	return opts
+}
+
+// defaultPruneInterval is the minimum time between prunings of the font cache
+// after downloads.
+const defaultPruneInterval = time.Hour
+
+// pruneInterval reads the minimum time between prunings of the font cache after
+// downloads from configuration key "fonts-cache-prune-interval" (a duration,
+// default 1h; 0 prunes after every download).
+func pruneInterval(conf schuko.Configuration) time.Duration {
+	if !conf.IsSet("fonts-cache-prune-interval") {
+		return defaultPruneInterval
+	}
+	d, err := time.ParseDuration(conf.GetString("fonts-cache-prune-interval"))
+	if err != nil || d < 0 {
+		tracer().Errorf("invalid fonts-cache-prune-interval, using %v: %v", defaultPruneInterval, err)
+		return defaultPruneInterval
+	}
+	return d
+}
+
+// prunePeriodically prunes the font cache as configured (see pruneConfig), unless it
+// has been pruned less than the prune interval ago, so that a series of downloads
+// does not walk the cache directory each time.
+func (svc *googleService) prunePeriodically(conf schuko.Configuration) error {
+	opts := pruneConfig(conf)
+	if opts.MaxBytes == 0 && opts.MaxAge == 0 {
+		return nil
+	}
+	svc.pruneLock.Lock()
+	if !svc.prunedAt.IsZero() && time.Since(svc.prunedAt) < pruneInterval(conf) {
+		svc.pruneLock.Unlock()
+		return nil
+	}
+	svc.prunedAt = time.Now()
+	svc.pruneLock.Unlock()
+	return svc.pruneCache(conf, opts)
+}
+
+// fontGroup is a font in the font cache directory, together with its checksum and
+// other files of the same font (see entryBase).
+type fontGroup struct {
+	base    string   // see entryBase
+	files   []string // paths relative to the cache directory
+	size    int64
+	modTime time.Time // of the most recent file
+}
+
+func (svc *googleService) pruneCache(conf schuko.Configuration, opts PruneOptions) error {
+	if opts.MaxBytes == 0 && opts.MaxAge == 0 {
+		return nil
+	}
+	cache, err := svc.fontCache(conf)
+	if err != nil {
+		return err
+	}
+	dc, ok := cache.(*dirCache)
+	if !ok {
+		return nil
+	}
+	fonts, total, err := dc.fontGroups()
+	if err != nil {
+		return err
+	}
+	registered := registeredFonts()
+	sort.Slice(fonts, func(i, j int) bool { // least recently used first
+		return fonts[i].modTime.Before(fonts[j].modTime)
+	})
+	for _, f := range fonts {
+		expired := opts.MaxAge > 0 && time.Since(f.modTime) > opts.MaxAge
+		if !expired && (opts.MaxBytes == 0 || total <= opts.MaxBytes) {
+			continue
+		}
+		if isDownloading(dc.file(f.base)) || registered[path.Base(f.base)] {
+			continue
+		}
+		tracer().Infof("pruning cached font %s", f.base)
+		for _, name := range f.files {
+			if err = remove(dc.io, dc.file(name)); err != nil {
+				tracer().Errorf("cannot prune %s: %v", name, err)
+			}
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// fontGroups lists the fonts in the sub-folders of the cache directory, and returns
+// their total size. Incomplete downloads ("*.part") are skipped.
+func (c *dirCache) fontGroups() ([]fontGroup, int64, error) {
+	byBase := make(map[string]*fontGroup)
+	var fonts []*fontGroup
+	var total int64
+	err := fs.WalkDir(c.io.DirFS(c.root), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.Contains(name, "/") || strings.HasSuffix(name, ".part") {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil // removed in the meantime
+		}
+		base := entryBase(name)
+		f := byBase[base]
+		if f == nil {
+			f = &fontGroup{base: base}
+			byBase[base] = f
+			fonts = append(fonts, f)
+		}
+		f.files = append(f.files, name)
+		f.size += fi.Size()
+		if fi.ModTime().After(f.modTime) {
+			f.modTime = fi.ModTime()
+		}
+		total += fi.Size()
+		return nil
+	})
+	list := make([]fontGroup, len(fonts))
+	for i, f := range fonts {
+		list[i] = *f
+	}
+	return list, total, err
+}
+
+// registeredFonts returns the file names of Google fonts in the global font registry,
+// without extension (see entryBase), e.g. "Inconsolata-regular".
+func registeredFonts() map[string]bool {
+	names := make(map[string]bool)
+	for _, f := range fontregistry.GlobalRegistry().List() {
+		if f.Source == fontfind.SourceGoogle {
+			names[entryBase(f.Name)] = true
+		}
+	}
+	return names
+}
+
+// entryBase strips the extensions from the name of a cache entry, so that a font,
+// its checksum and its web font download share the same base,
+// e.g. "I/Inconsolata-regular".
+func entryBase(name string) string {
+	name = strings.TrimSuffix(name, checksumExt)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// downloads holds the cache files of fonts currently being downloaded, by their base
+// (see entryBase), which must not be pruned.
+var downloads struct {
+	sync.Mutex
+	active map[string]int
+}
+
+// startDownload marks a font as being downloaded to file (without extension). The
+// returned function ends the mark.
+func startDownload(file string) func() {
+	downloads.Lock()
+	defer downloads.Unlock()
+	if downloads.active == nil {
+		downloads.active = make(map[string]int)
+	}
+	downloads.active[file]++
+	return func() {
+		downloads.Lock()
+		defer downloads.Unlock()
+		if downloads.active[file]--; downloads.active[file] == 0 {
+			delete(downloads.active, file)
+		}
+	}
+}
+
+// isDownloading returns true if a font is being downloaded to file (without extension).
+func isDownloading(file string) bool {
+	downloads.Lock()
+	defer downloads.Unlock()
+	return downloads.active[file] > 0
+}
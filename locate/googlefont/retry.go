@@ -0,0 +1,94 @@
+package googlefont
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/npillmayer/schuko"
+)
+
+// Requests to the Google Fonts service are retried with exponential backoff, as a
+// single transient network failure should not fail a font lookup.
+
+const (
+	defaultRetries    = 3                      // retries after the first attempt
+	defaultRetryDelay = 500 * time.Millisecond // delay before the first retry
+	maxRetryDelay     = 30 * time.Second
+)
+
+// retryPolicy tells how often and how fast failed requests are retried.
+type retryPolicy struct {
+	retries int
+	delay   time.Duration
+}
+
+// retryConfig reads the retry policy from configuration keys "google-fonts-retries"
+// (the number of retries after a failed attempt, default 3; 0 disables retries) and
+// "google-fonts-retry-delay" (the delay before the first retry, default 500ms; it
+// doubles with every retry).
+func retryConfig(conf schuko.Configuration) retryPolicy {
+	policy := retryPolicy{retries: defaultRetries, delay: defaultRetryDelay}
+	if conf.IsSet("google-fonts-retries") {
+		if n := conf.GetInt("google-fonts-retries"); n >= 0 {
+			policy.retries = n
+		} else {
+			tracer().Errorf("invalid google-fonts-retries %d, using default", n)
+		}
+	}
+	if conf.IsSet("google-fonts-retry-delay") {
+		d, err := time.ParseDuration(conf.GetString("google-fonts-retry-delay"))
+		if err != nil || d < 0 {
+			tracer().Errorf("invalid google-fonts-retry-delay, using default: %v", err)
+		} else {
+			policy.delay = d
+		}
+	}
+	return policy
+}
+
+// permanentError marks an error which will not go away by retrying, e.g. an
+// HTTP status 404.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// permanent marks err as not worth retrying.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// retry calls request until it succeeds, fails permanently, or the retries of the
+// policy are used up. It waits between attempts, doubling the delay each time, and
+// stops waiting if ctx is done. The error of the last attempt is returned.
+func (policy retryPolicy) retry(ctx context.Context, what string, request func() error) error {
+	delay := policy.delay
+	for attempt := 0; ; attempt++ {
+		err := request()
+		var perm permanentError
+		if err == nil || errors.As(err, &perm) || attempt >= policy.retries {
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		tracer().Infof("%s failed, retrying in %v: %v", what, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
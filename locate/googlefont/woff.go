@@ -0,0 +1,130 @@
+package googlefont
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+)
+
+// Google Fonts may deliver fonts in web font formats, which package
+// golang.org/x/image/font/sfnt is unable to parse. Web fonts are therefore
+// converted to plain SFNT before they are handed out from the cache.
+//
+// WOFF 1.0 files are decoded here. WOFF 2.0 would require a Brotli decompressor
+// (and glyf/loca reconstruction), which we do not have, so it is reported as an error.
+
+// isWebFont returns true for file extensions of web font formats.
+func isWebFont(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".woff", ".woff2":
+		return true
+	}
+	return false
+}
+
+// decodeWebFont converts web font data to SFNT data. It returns the file
+// extension (".ttf" or ".otf") matching the outline flavor of the font.
+func decodeWebFont(data []byte, ext string) ([]byte, string, error) {
+	switch strings.ToLower(ext) {
+	case ".woff":
+		return decodeWOFF(data)
+	case ".woff2":
+		return nil, "", errors.New("WOFF2 fonts are not supported (Brotli decompression unavailable)")
+	}
+	return nil, "", fmt.Errorf("not a web font format: %q", ext)
+}
+
+const (
+	woffHeaderSize   = 44
+	woffDirEntrySize = 20
+	sfntHeaderSize   = 12
+	sfntDirEntrySize = 16
+)
+
+// Limits of decoded WOFF data, guarding against table sizes in corrupt or hostile
+// files which would make us allocate or inflate far more than any real font needs.
+const (
+	maxWOFFTableSize = 64 << 20  // of a single decompressed table
+	maxWOFFSfntSize  = 256 << 20 // of the decoded font
+)
+
+// decodeWOFF converts WOFF 1.0 data to SFNT data, decompressing tables as
+// necessary. Metadata and private data blocks of the WOFF file are dropped.
+func decodeWOFF(data []byte) ([]byte, string, error) {
+	if len(data) < woffHeaderSize || string(data[:4]) != "wOFF" {
+		return nil, "", errors.New("invalid WOFF header")
+	}
+	flavor := binary.BigEndian.Uint32(data[4:])
+	numTables := int(binary.BigEndian.Uint16(data[12:]))
+	if numTables == 0 || len(data) < woffHeaderSize+numTables*woffDirEntrySize {
+		return nil, "", errors.New("invalid WOFF table directory")
+	}
+	ext := ".ttf"
+	if flavor == 0x4f54544f { // 'OTTO' = CFF outlines
+		ext = ".otf"
+	}
+	// SFNT offset table
+	out := make([]byte, sfntHeaderSize+numTables*sfntDirEntrySize)
+	binary.BigEndian.PutUint32(out[0:], flavor)
+	binary.BigEndian.PutUint16(out[4:], uint16(numTables))
+	entrySelector := bits.Len(uint(numTables)) - 1
+	searchRange := (1 << entrySelector) * 16
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(numTables*16-searchRange))
+	for i := 0; i < numTables; i++ {
+		entry := data[woffHeaderSize+i*woffDirEntrySize:]
+		tag := entry[0:4]
+		offset := binary.BigEndian.Uint32(entry[4:])
+		compLength := binary.BigEndian.Uint32(entry[8:])
+		origLength := binary.BigEndian.Uint32(entry[12:])
+		checksum := binary.BigEndian.Uint32(entry[16:])
+		if uint64(offset)+uint64(compLength) > uint64(len(data)) || compLength > origLength {
+			return nil, "", fmt.Errorf("invalid WOFF table entry %q", tag)
+		}
+		if origLength > maxWOFFTableSize || len(out)+int(origLength) > maxWOFFSfntSize {
+			return nil, "", fmt.Errorf("WOFF table %q too large: %d bytes", tag, origLength)
+		}
+		table := data[offset : offset+compLength]
+		if compLength < origLength {
+			var err error
+			if table, err = inflateTable(table, origLength); err != nil {
+				return nil, "", fmt.Errorf("cannot decompress WOFF table %q: %w", tag, err)
+			}
+		}
+		rec := out[sfntHeaderSize+i*sfntDirEntrySize:]
+		copy(rec[0:4], tag)
+		binary.BigEndian.PutUint32(rec[4:], checksum)
+		binary.BigEndian.PutUint32(rec[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(rec[12:], origLength)
+		out = append(out, table...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out, ext, nil
+}
+
+// inflateTable decompresses a zlib-compressed WOFF table of size length. Reading
+// stops after length+1 bytes, so a table inflating to more than its declared size
+// is detected without decompressing it completely.
+func inflateTable(table []byte, length uint32) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(table))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf, err := io.ReadAll(io.LimitReader(r, int64(length)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != int(length) {
+		return nil, fmt.Errorf("table does not inflate to its declared size of %d bytes", length)
+	}
+	return buf, nil
+}
@@ -5,13 +5,39 @@ package locate
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/schuko/tracing"
 )
 
+// tracer writes to trace with key 'tyse.font'
+func tracer() tracing.Trace {
+	return tracing.Select("tyse.font")
+}
+
 // FontLocator resolves a scalable font for a descriptor.
 type FontLocator func(fontfind.Descriptor) (fontfind.ScalableFont, error)
 
 // FontLocatorWithContext is a context-aware variant of FontLocator.
 // Implementations should respect cancellation/deadlines of ctx if possible.
 type FontLocatorWithContext func(context.Context, fontfind.Descriptor) (fontfind.ScalableFont, error)
+
+// WithTimeout wraps a resolver to give up after d, e.g. to allow a local scan a
+// few milliseconds, but a download from a font service a few seconds. Within a
+// chain of resolvers, the next resolver is tried after a timeout of r. The error
+// of a timed out resolver wraps ErrResolverTimeout.
+//
+// r has to respect the cancellation of its context for the timeout to take effect.
+func WithTimeout(r FontLocatorWithContext, d time.Duration) FontLocatorWithContext {
+	return func(ctx context.Context, desc fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		subctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		f, err := r(subctx, desc)
+		if err != nil && ctx.Err() == nil && subctx.Err() != nil {
+			return fontfind.NullFont, fmt.Errorf("%w after %v: %w", ErrResolverTimeout, d, err)
+		}
+		return f, err
+	}
+}
@@ -2,16 +2,22 @@ package locate_test
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
 	"time"
 
 	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/fontregistry"
 	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/fontfind/locate/fallbackfont"
 	"github.com/npillmayer/fontfind/locate/googlefont"
@@ -19,6 +25,7 @@ import (
 	"github.com/npillmayer/schuko/schukonf/testconfig"
 	"github.com/npillmayer/schuko/tracing/gotestingadapter"
 	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
 )
 
 func TestLoadPackagedFont(t *testing.T) {
@@ -92,6 +99,24 @@ func TestFCFind(t *testing.T) {
 	}
 }
 
+func TestFCFindCollection(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	desc := fontfind.Descriptor{
+		Pattern: "Noto Serif Myanmar Light",
+		Style:   font.StyleNormal,
+		Weight:  font.WeightNormal,
+	}
+	system := systemfont.Find("tyse-test", newIO())
+	f, err := locate.ResolveFontLoc(desc, system).Font()
+	if err != nil {
+		t.Fatalf("expected fixture-based systemfont hit, got error: %v", err)
+	}
+	if f.Path() != "NotoSerifMyanmar.ttc" {
+		t.Fatalf("expected path NotoSerifMyanmar.ttc, got %q", f.Path())
+	}
+}
+
 func TestResolveTypefaceUsesRegistryCache(t *testing.T) {
 	teardown := gotestingadapter.QuickConfig(t, "resources")
 	defer teardown()
@@ -215,6 +240,92 @@ func TestResolveTypefaceReturnsFallbackOnMiss(t *testing.T) {
 	}
 }
 
+func TestResolveStrictReturnsNoFallback(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	desc := fontfind.Descriptor{
+		Pattern: "zz-no-such-font-strict",
+		Style:   font.StyleNormal,
+		Weight:  font.WeightNormal,
+	}
+	f, err := locate.ResolveStrict(desc).Font()
+	if !errors.Is(err, locate.ErrFontNotFound) {
+		t.Fatalf("expected ErrFontNotFound, got %v", err)
+	}
+	if f != fontfind.NullFont {
+		t.Fatalf("expected null font on strict miss, got %q", f.Name)
+	}
+}
+
+func TestResolveKeepsResolverErrors(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	desc := fontfind.Descriptor{Pattern: "zz-resolver-errors"}
+	offline := func(fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.NullFont, fmt.Errorf("%w: no connection", locate.ErrNetworkFailure)
+	}
+	unconfigured := func(fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.NullFont, fmt.Errorf("%w: no key", locate.ErrMissingAPIKey)
+	}
+	for _, promise := range []locate.FontPromise{
+		locate.ResolveStrict(desc, offline, unconfigured),
+		locate.ResolveFontLocParallel(desc, offline, unconfigured),
+	} {
+		_, err := promise.Font()
+		if !errors.Is(err, locate.ErrFontNotFound) || !errors.Is(err, locate.ErrNetworkFailure) ||
+			!errors.Is(err, locate.ErrMissingAPIKey) {
+			t.Errorf("expected not-found error wrapping the resolver errors, got %v", err)
+		}
+	}
+}
+
+func TestResolveRejectsInvalidDescriptor(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	called := false
+	locator := func(fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		called = true
+		return fontfind.NullFont, errors.New("not reached")
+	}
+	f, err := locate.ResolveFontLoc(fontfind.Descriptor{Pattern: " "}, locator).Font()
+	if !errors.Is(err, fontfind.ErrInvalidDescriptor) {
+		t.Fatalf("expected ErrInvalidDescriptor, got %v", err)
+	}
+	if f != fontfind.NullFont || called {
+		t.Errorf("expected invalid descriptor to short-circuit without fallback")
+	}
+}
+
+func TestResolveRecoversFromPanickingResolver(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	desc := fontfind.Descriptor{
+		Pattern: "zz-panic-probe",
+		Style:   font.StyleNormal,
+		Weight:  font.WeightNormal,
+	}
+	panicking := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		panic("buggy resolver")
+	}
+	good := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		sfnt := fontfind.ScalableFont{Name: "good.ttf"}
+		sfnt.SetFS(fstest.MapFS{"good.ttf": &fstest.MapFile{Data: []byte("dummy")}}, "good.ttf")
+		return sfnt, nil
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), panicking, good)
+	f, err := pipeline.Resolve(context.Background(), desc).Font()
+	if err != nil {
+		t.Fatalf("expected second resolver to succeed, got %v", err)
+	}
+	if f.Name != "good.ttf" {
+		t.Fatalf("expected good.ttf, got %q", f.Name)
+	}
+}
+
 func TestResolveTypefaceContextCanceledBeforeStart(t *testing.T) {
 	teardown := gotestingadapter.QuickConfig(t, "resources")
 	defer teardown()
@@ -265,6 +376,784 @@ func TestResolveTypefaceContextDeadlineExceeded(t *testing.T) {
 	}
 }
 
+func TestResolveFontLocWithTimeout(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	desc := fontfind.Descriptor{Pattern: "zz-resolve-with-timeout"}
+	release := make(chan struct{})
+	defer close(release)
+	blocking := func(_ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		<-release
+		return fontfind.NullFont, errors.New("unexpected resolver completion")
+	}
+	var nextCalled atomic.Bool
+	next := func(_ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		nextCalled.Store(true)
+		return fontfind.FallbackFont(), nil
+	}
+	start := time.Now()
+	f, err := locate.ResolveFontLocWithTimeout(10*time.Millisecond, desc, blocking, next).Font()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline exceeded, got %v", err)
+	}
+	if f != fontfind.NullFont {
+		t.Errorf("expected null font on deadline exceeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected promise to give up after timeout, waited %v", elapsed)
+	}
+	if nextCalled.Load() {
+		t.Errorf("expected resolvers after the timeout not to be called")
+	}
+	desc.Pattern = "zz-resolve-within-timeout"
+	if f, err = locate.ResolveFontLocWithTimeout(time.Second, desc, next).Font(); err != nil || f.Name == "" {
+		t.Errorf("expected font resolved within timeout, have %q, %v", f.Name, err)
+	}
+}
+
+func TestResolverSubTimeout(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	desc := fontfind.Descriptor{Pattern: "zz-resolver-sub-timeout"}
+	var slowCalls atomic.Int32
+	slow := func(ctx context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		slowCalls.Add(1)
+		select {
+		case <-ctx.Done():
+			return fontfind.NullFont, ctx.Err()
+		case <-time.After(time.Second):
+			return fontfind.NullFont, errors.New("unexpected resolver completion")
+		}
+	}
+	packaged := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		d.Pattern = "Go"
+		return fallbackfont.Find()(d)
+	}
+	_, err := locate.WithTimeout(slow, 10*time.Millisecond)(context.Background(), desc)
+	if !errors.Is(err, locate.ErrResolverTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected resolver timeout, got %v", err)
+	}
+	// sub-timeout, then success on the next resolver
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), locate.WithTimeout(slow, 10*time.Millisecond),
+		packaged).Strict()
+	f, err := pipeline.Resolve(context.Background(), desc).Font()
+	if err != nil {
+		t.Fatalf("expected next resolver to succeed after sub-timeout, got %v", err)
+	}
+	if f.Source != fontfind.SourcePackaged {
+		t.Errorf("expected packaged font, have %v", f.Source)
+	}
+	// a timeout is not remembered as a miss
+	misses := fontregistry.NewNegativeCache(time.Minute)
+	pipeline = locate.NewResolverPipeline(newMemoryRegistry(), locate.WithTimeout(slow, 10*time.Millisecond)).
+		Strict().WithNegativeCache(misses)
+	slowCalls.Store(0)
+	for i := 0; i < 2; i++ {
+		_, err = pipeline.Resolve(context.Background(), desc).Font()
+		if !errors.Is(err, locate.ErrFontNotFound) || !errors.Is(err, locate.ErrResolverTimeout) {
+			t.Errorf("expected not-found error telling about the timeout, got %v", err)
+		}
+	}
+	if n := slowCalls.Load(); n != 2 {
+		t.Errorf("expected timed out resolver to be tried again, have %d calls", n)
+	}
+	// the parent context being done ends the resolution
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	pipeline = locate.NewResolverPipeline(newMemoryRegistry(), locate.WithTimeout(slow, time.Second), packaged)
+	if _, err = pipeline.Resolve(ctx, desc).Font(); !errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, locate.ErrResolverTimeout) {
+		t.Errorf("expected context deadline exceeded, got %v", err)
+	}
+}
+
+func TestResolveStats(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	before := locate.Stats() // counters are process-wide, compare deltas
+	failing := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.NullFont, errors.New("not found")
+	}
+	succeeding := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.FallbackFont(), nil
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), failing, succeeding)
+	desc := fontfind.Descriptor{Pattern: "zz-stats-probe"}
+	if _, err := pipeline.Resolve(context.Background(), desc).Font(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pipeline.Resolve(context.Background(), desc).Font(); err != nil {
+		t.Fatal(err)
+	}
+	missing := fontfind.Descriptor{Pattern: "zz-stats-missing"}
+	if _, err := locate.NewResolverPipeline(newMemoryRegistry(), failing).Resolve(context.Background(), missing).Font(); err == nil {
+		t.Fatal("expected lookup error for missing font")
+	}
+	s := locate.Stats()
+	if d := s.RegistryHits - before.RegistryHits; d != 1 {
+		t.Errorf("expected 1 registry hit, got %d", d)
+	}
+	hits := make([]uint64, len(s.ResolverHits))
+	for i, n := range s.ResolverHits {
+		hits[i] = n
+		if i < len(before.ResolverHits) {
+			hits[i] -= before.ResolverHits[i]
+		}
+	}
+	if len(hits) < 2 || hits[0] != 0 || hits[1] != 1 {
+		t.Errorf("expected 1 hit for second resolver, got %v", hits)
+	}
+	if d := s.Fallbacks - before.Fallbacks; d != 1 {
+		t.Errorf("expected 1 fallback, got %d", d)
+	}
+}
+
+func TestOnResolveHook(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	type outcome struct {
+		source fontfind.Source
+		err    error
+	}
+	var outcomes []outcome // hooks of resolutions finished before Font() returns
+	var unsubscribe []func()
+	for i := 0; i < 2; i++ { // multiple subscribers
+		hook := func(desc fontfind.Descriptor, source fontfind.Source, err error) {
+			if strings.HasPrefix(desc.Pattern, "zz-hook-") {
+				outcomes = append(outcomes, outcome{source, err})
+			}
+		}
+		unsubscribe = append(unsubscribe, locate.OnResolve(hook))
+	}
+	found := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		if d.Pattern != "zz-hook-found" {
+			return fontfind.NullFont, errors.New("not found")
+		}
+		f := fontfind.FallbackFont()
+		f.Source = fontfind.SourceSystem
+		return f, nil
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), found).Strict()
+	pipeline.Resolve(context.Background(), fontfind.Descriptor{Pattern: "zz-hook-found"}).Font()
+	pipeline.Resolve(context.Background(), fontfind.Descriptor{Pattern: "zz-hook-missing"}).Font()
+	if len(outcomes) != 4 {
+		t.Fatalf("expected 2 subscribers to see 2 resolutions, have %d calls", len(outcomes))
+	}
+	if outcomes[0].source != fontfind.SourceSystem || outcomes[0].err != nil {
+		t.Errorf("expected hook to see system font, have %+v", outcomes[0])
+	}
+	if outcomes[2].source != fontfind.SourceUnknown || !errors.Is(outcomes[2].err, locate.ErrFontNotFound) {
+		t.Errorf("expected hook to see missing font, have %+v", outcomes[2])
+	}
+	unsubscribe[0]()
+	unsubscribe[0]()
+	pipeline.Resolve(context.Background(), fontfind.Descriptor{Pattern: "zz-hook-found"}).Font()
+	if len(outcomes) != 5 {
+		t.Errorf("expected only the remaining subscriber to see the resolution, have %d calls", len(outcomes))
+	}
+	unsubscribe[1]()
+}
+
+func TestLocateFont(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	failing := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.NullFont, errors.New("not found")
+	}
+	downloading := func(ctx context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		if !locate.DryRun(ctx) {
+			t.Error("expected resolver to be called for a dry run")
+		}
+		locate.ReportDownload(ctx, "https://fonts.example/"+d.Pattern+".ttf")
+		return fontfind.ScalableFont{Name: d.Pattern + ".ttf", Source: fontfind.SourceGoogle}, nil
+	}
+	report, err := locate.LocateFont(context.Background(), fontfind.Descriptor{Pattern: "zz-locate"},
+		failing, downloading)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resolver != 1 || report.URL != "https://fonts.example/zz-locate.ttf" || report.Font.Name != "zz-locate.ttf" {
+		t.Errorf("unexpected location report %+v", report)
+	}
+	if _, err := locate.LocateFont(context.Background(), fontfind.Descriptor{Pattern: "zz-locate"},
+		failing); !errors.Is(err, locate.ErrFontNotFound) {
+		t.Errorf("expected ErrFontNotFound, got %v", err)
+	}
+	if locate.DryRun(context.Background()) {
+		t.Errorf("expected no dry run by default")
+	}
+}
+
+func TestResolveParallelFirstSuccessWins(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	cancelled := make(chan struct{})
+	slow := func(ctx context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return fontfind.NullFont, ctx.Err()
+	}
+	fast := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		sfnt := fontfind.ScalableFont{Name: "fast.ttf"}
+		sfnt.SetFS(fstest.MapFS{"fast.ttf": &fstest.MapFile{Data: []byte("dummy")}}, "fast.ttf")
+		return sfnt, nil
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), slow, fast).Parallel()
+	desc := fontfind.Descriptor{Pattern: "zz-parallel-probe"}
+	f, err := pipeline.Resolve(context.Background(), desc).Font()
+	if err != nil {
+		t.Fatalf("expected fast resolver to succeed, got %v", err)
+	}
+	if f.Name != "fast.ttf" {
+		t.Fatalf("expected fast.ttf, got %q", f.Name)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected slow resolver to be cancelled")
+	}
+}
+
+func TestResolveFontLocParallelFallsBack(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	failing := func(_ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.NullFont, errors.New("not found")
+	}
+	desc := fontfind.Descriptor{Pattern: "zz-parallel-missing"}
+	f, err := locate.ResolveFontLocParallel(desc, failing, failing, failing).Font()
+	if !errors.Is(err, locate.ErrFontNotFound) {
+		t.Fatalf("expected ErrFontNotFound, got %v", err)
+	}
+	if f.Name != "Go-Regular.otf" {
+		t.Fatalf("expected fallback Go-Regular.otf, got %q", f.Name)
+	}
+}
+
+func TestDiscardedPromiseDoesNotLeak(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	resolver := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.FallbackFont(), nil
+	}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		desc := fontfind.Descriptor{Pattern: fmt.Sprintf("zz-discarded-%d", i)}
+		_ = locate.NewResolverPipeline(newMemoryRegistry(), resolver).Resolve(context.Background(), desc)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected search goroutines to finish, %d of them still running",
+				runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResolveRemembersMisses(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	calls := 0
+	failing := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		calls++
+		return fontfind.NullFont, errors.New("not found")
+	}
+	misses := fontregistry.NewNegativeCache(time.Minute)
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), failing).WithNegativeCache(misses)
+	desc := fontfind.Descriptor{Pattern: "zz-negative-probe"}
+	for i := 0; i < 3; i++ {
+		if _, err := pipeline.Resolve(context.Background(), desc).Font(); !errors.Is(err, locate.ErrFontNotFound) {
+			t.Fatalf("expected ErrFontNotFound, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected resolver to be called once, was called %d times", calls)
+	}
+	misses.Clear()
+	pipeline.Resolve(context.Background(), desc).Font()
+	if calls != 2 {
+		t.Errorf("expected resolver to be called again after clearing misses")
+	}
+	// a miss of one chain does not block another chain sharing the cache
+	found := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fontfind.ScalableFont{Name: d.Pattern}, nil
+	}
+	other := locate.NewResolverPipeline(newMemoryRegistry(), failing, found).WithNegativeCache(misses)
+	if f, err := other.Resolve(context.Background(), desc).Font(); err != nil || f.Name != desc.Pattern {
+		t.Errorf("expected other chain to resolve font, got %q (%v)", f.Name, err)
+	}
+	// network failures are not remembered
+	offlineCalls := 0
+	offline := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		offlineCalls++
+		return fontfind.NullFont, fmt.Errorf("%w: no connection", locate.ErrNetworkFailure)
+	}
+	pipeline = locate.NewResolverPipeline(newMemoryRegistry(), offline).WithNegativeCache(misses)
+	for i := 0; i < 2; i++ {
+		pipeline.Resolve(context.Background(), desc).Font()
+	}
+	if offlineCalls != 2 {
+		t.Errorf("expected network failure not to be remembered, resolver called %d times", offlineCalls)
+	}
+}
+
+func TestResolveFontLocRemembersMisses(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	misses := fontregistry.GlobalNegativeCache()
+	misses.Clear()
+	defer misses.Clear()
+	calls := 0
+	failing := func(_ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		calls++
+		return fontfind.NullFont, errors.New("not found")
+	}
+	desc := fontfind.Descriptor{Pattern: "zz-render-loop-probe"}
+	for i := 0; i < 2; i++ { // e.g. a render loop
+		if _, err := locate.ResolveFontLoc(desc, failing).Font(); !errors.Is(err, locate.ErrFontNotFound) {
+			t.Fatalf("expected ErrFontNotFound, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected resolver to be called once, was called %d times", calls)
+	}
+	// pipelines of their own do not share the misses of the default pipeline
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), func(_ context.Context, d fontfind.Descriptor) (
+		fontfind.ScalableFont, error) {
+		return failing(d)
+	}).WithNegativeCache(misses)
+	pipeline.Resolve(context.Background(), desc).Font()
+	if calls != 2 {
+		t.Errorf("expected another pipeline to call its resolver, have %d calls", calls)
+	}
+}
+
+func TestResolvedFontKeepsSource(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	desc := fontfind.Descriptor{Pattern: "Go Mono", Style: font.StyleNormal, Weight: font.WeightNormal}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), func(_ context.Context, d fontfind.Descriptor) (
+		fontfind.ScalableFont, error) {
+		return fallbackfont.Find()(d)
+	})
+	for i := 0; i < 2; i++ { // second lookup is served by the registry
+		f, err := pipeline.Resolve(context.Background(), desc).Font()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Source != fontfind.SourcePackaged {
+			t.Errorf("lookup #%d: expected font source packaged, is %s", i+1, f.Source)
+		}
+	}
+}
+
+func TestResolveReportsConfidence(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	var opened atomic.Int32
+	fsys := openCountingFS{FS: fstest.MapFS{"substitute.ttf": &fstest.MapFile{Data: []byte("dummy")}},
+		opened: &opened}
+	substitute := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		f := fontfind.ScalableFont{Name: "substitute.ttf", Style: d.Style, Weight: d.Weight,
+			Confidence: fontfind.HighConfidence}
+		f.SetFS(fsys, "substitute.ttf")
+		return f, nil
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), substitute)
+	desc := fontfind.Descriptor{Pattern: "zz-confidence-probe", Weight: font.WeightBold}
+	// the font data is not even a font, thus could not be judged by reading it
+	var resolved int32
+	for i := 0; i < 2; i++ { // second lookup is served by the registry
+		r := pipeline.Resolve(context.Background(), desc).Result()
+		if r.Err != nil || r.Confidence != fontfind.HighConfidence {
+			t.Errorf("lookup #%d: expected confidence of locator, have %d (%v)", i+1, r.Confidence, r.Err)
+		}
+		if i == 0 {
+			resolved = opened.Load()
+		}
+	}
+	if n := opened.Load(); n != resolved {
+		t.Errorf("expected registry hit not to read font data, was opened %d more times", n-resolved)
+	}
+	// locators which do not report a confidence are judged by the font binary
+	packaged := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		f, err := fallbackfont.Find()(d)
+		f.Confidence = fontfind.NoConfidence
+		return f, err
+	}
+	desc = fontfind.Descriptor{Pattern: "Go", Style: font.StyleItalic, Weight: font.WeightBold}
+	r := locate.NewResolverPipeline(newMemoryRegistry(), packaged).Resolve(context.Background(), desc).Result()
+	if r.Confidence != fontfind.PerfectConfidence {
+		t.Errorf("expected perfect confidence for Go Bold Italic, have %d (%v)", r.Confidence, r.Err)
+	}
+	// the fallback font has no confidence
+	r = locate.NewResolverPipeline(newMemoryRegistry()).Resolve(context.Background(),
+		fontfind.Descriptor{Pattern: "zz-no-such-font"}).Result()
+	if r.Font.Name == "" || r.Confidence != fontfind.NoConfidence {
+		t.Errorf("expected fallback font without confidence, have %q with %d", r.Font.Name, r.Confidence)
+	}
+}
+
+func TestRegistryDoesNotKeepRetainedData(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	fsys := fstest.MapFS{"retained.ttf": &fstest.MapFile{Data: []byte("dummy")}}
+	retaining := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		f := fontfind.ScalableFont{Name: "retained.ttf", Style: d.Style, Weight: d.Weight}
+		f.SetFS(fsys, "retained.ttf")
+		return f, f.Retain()
+	}
+	registry := newMemoryRegistry()
+	r := locate.NewResolverPipeline(registry, retaining).Resolve(context.Background(),
+		fontfind.Descriptor{Pattern: "zz-retained-probe"}).Result()
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	delete(fsys, "retained.ttf")
+	if data, err := r.Font.ReadFontData(); err != nil || string(data) != "dummy" {
+		t.Errorf("expected resolved font to keep its retained data, have %q (%v)", data, err)
+	}
+	for name, f := range registry.fonts {
+		if _, err := f.ReadFontData(); err == nil {
+			t.Errorf("expected registry entry %s not to keep retained data", name)
+		}
+	}
+}
+
+// openCountingFS counts the files opened.
+type openCountingFS struct {
+	fs.FS
+	opened *atomic.Int32
+}
+
+func (c openCountingFS) Open(name string) (fs.File, error) {
+	c.opened.Add(1)
+	return c.FS.Open(name)
+}
+
+func TestResolveBatch(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	descs := []fontfind.Descriptor{
+		{Pattern: "Go", Style: font.StyleNormal, Weight: font.WeightNormal},
+		{Pattern: "zz-batch-missing", Style: font.StyleNormal, Weight: font.WeightNormal},
+		{Pattern: "Go", Style: font.StyleItalic, Weight: font.WeightBold},
+	}
+	found := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		if d.Pattern != "Go" {
+			return fontfind.NullFont, errors.New("not found")
+		}
+		return fallbackfont.Find()(d)
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), found).Strict()
+	results, err := pipeline.ResolveBatch(context.Background(), descs).FontsWithContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(descs) {
+		t.Fatalf("expected %d results, got %d", len(descs), len(results))
+	}
+	if results[0].Err != nil || results[0].Font.Name != "Go-Regular.otf" {
+		t.Errorf("expected Go-Regular.otf for first descriptor, got %q (%v)", results[0].Font.Name, results[0].Err)
+	}
+	if !errors.Is(results[1].Err, locate.ErrFontNotFound) {
+		t.Errorf("expected ErrFontNotFound for second descriptor, got %v", results[1].Err)
+	}
+	if results[2].Err != nil || results[2].Font.Name != "Go-Bold-Italic.otf" {
+		t.Errorf("expected Go-Bold-Italic.otf for third descriptor, got %q (%v)", results[2].Font.Name, results[2].Err)
+	}
+}
+
+func TestResolveBatchCancelled(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	blocking := func(ctx context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		<-ctx.Done()
+		return fontfind.NullFont, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	descs := []fontfind.Descriptor{{Pattern: "zz-batch-a"}, {Pattern: "zz-batch-b"}}
+	promise := locate.NewResolverPipeline(newMemoryRegistry(), blocking).ResolveBatch(ctx, descs)
+	cancel()
+	results := promise.Fonts()
+	for i, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("expected descriptor #%d to be cancelled, got %v", i, r.Err)
+		}
+	}
+}
+
+func TestResolveRejectsFontLackingSample(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	packaged := func(name string) locate.FontLocatorWithContext {
+		return func(context.Context, fontfind.Descriptor) (fontfind.ScalableFont, error) {
+			f := fontfind.ScalableFont{Name: name}
+			f.SetFS(os.DirFS("fallbackfont/packaged"), name)
+			return f, nil
+		}
+	}
+	desc := fontfind.Descriptor{Pattern: "zz-ipa-probe", Sample: "fəˈnɛtɪks"}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(),
+		packaged("Go-Regular.otf"), packaged("GentiumPlus-R.ttf"))
+	f, err := pipeline.Resolve(context.Background(), desc).Font()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "GentiumPlus-R.ttf" {
+		t.Errorf("expected Go-Regular to be skipped for missing IPA glyphs, got %s", f.Name)
+	}
+	desc.Sample = ""
+	desc.Pattern = "zz-no-sample-probe"
+	if f, err = pipeline.Resolve(context.Background(), desc).Font(); err != nil || f.Name != "Go-Regular.otf" {
+		t.Errorf("expected first resolver to win without sample, got %s (%v)", f.Name, err)
+	}
+	desc.Sample = "नमस्ते"
+	desc.Pattern = "zz-devanagari-probe"
+	f, err = pipeline.Resolve(context.Background(), desc).Font()
+	if err == nil || f.Name != fontfind.FallbackFont().Name {
+		t.Errorf("expected fallback font with error if no font covers sample, got %s (%v)", f.Name, err)
+	}
+}
+
+func TestResolveSampleFromRegistry(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	calls := 0
+	packaged := func(name string) locate.FontLocatorWithContext {
+		return func(context.Context, fontfind.Descriptor) (fontfind.ScalableFont, error) {
+			calls++
+			f := fontfind.ScalableFont{Name: name}
+			f.SetFS(os.DirFS("fallbackfont/packaged"), name)
+			return f, nil
+		}
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(),
+		packaged("Go-Regular.otf"), packaged("GentiumPlus-R.ttf"))
+	desc := fontfind.Descriptor{Pattern: "zz-sample-key-probe"}
+	if f, err := pipeline.Resolve(context.Background(), desc).Font(); err != nil || f.Name != "Go-Regular.otf" {
+		t.Fatalf("expected first resolver to win without sample, got %s (%v)", f.Name, err)
+	}
+	desc.Sample = "fəˈnɛtɪks"
+	for i := 0; i < 2; i++ {
+		f, err := pipeline.Resolve(context.Background(), desc).Font()
+		if err != nil || f.Name != "GentiumPlus-R.ttf" {
+			t.Fatalf("expected font covering the sample, got %s (%v)", f.Name, err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected font for sample to be taken from the registry the second time, have %d resolver calls",
+			calls)
+	}
+}
+
+func TestResolveGenericFamily(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+
+	defaults := locate.GenericFamily("monospace")
+	if len(defaults) == 0 || defaults[len(defaults)-1] != "Go Mono" {
+		t.Errorf("expected packaged Go Mono to be the last resort for monospace, have %v", defaults)
+	}
+	defer locate.SetGenericFamily("sans-serif", locate.GenericFamily("sans-serif")...)
+	locate.SetGenericFamily("Sans-Serif", "zz-not-installed", "zz-installed")
+	var tried []string
+	resolver := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		tried = append(tried, d.Pattern)
+		if d.Category != "sans-serif" {
+			t.Errorf("expected category of generic family to be requested, is %q", d.Category)
+		}
+		if d.Pattern != "zz-installed" {
+			return fontfind.NullFont, errors.New("not installed")
+		}
+		return fontfind.ScalableFont{Name: d.Pattern}, nil
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), resolver)
+	f, err := pipeline.Strict().Resolve(context.Background(), fontfind.Descriptor{Pattern: "SANS-SERIF"}).Font()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "zz-installed" || len(tried) != 2 {
+		t.Errorf("expected candidates to be tried in order, got %s after %v", f.Name, tried)
+	}
+}
+
+func TestConcurrentIdenticalLookupsResolveOnce(t *testing.T) {
+	// no test tracer: gotestingadapter is not safe for concurrent use
+	var calls atomic.Int32
+	release := make(chan struct{})
+	resolver := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		calls.Add(1)
+		<-release
+		return fontfind.ScalableFont{Name: d.Pattern}, nil
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), resolver)
+	desc := fontfind.Descriptor{Pattern: "zz-single-flight", Weight: font.WeightBold}
+	promises := make([]locate.FontPromise, 32)
+	for i := range promises {
+		promises[i] = pipeline.Resolve(context.Background(), desc)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for _, p := range promises {
+		if f, err := p.Font(); err != nil || f.Name != desc.Pattern {
+			t.Errorf("expected shared resolution, got %q (%v)", f.Name, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected resolver to run once for concurrent identical lookups, ran %d times", n)
+	}
+}
+
+func TestConcurrentLookupsOfDifferentPipelines(t *testing.T) {
+	// no test tracer: gotestingadapter is not safe for concurrent use
+	release := make(chan struct{})
+	failing := func(_ context.Context, _ fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		<-release
+		return fontfind.NullFont, errors.New("not found")
+	}
+	found := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		<-release
+		return fontfind.ScalableFont{Name: d.Pattern}, nil
+	}
+	registry := newMemoryRegistry()
+	desc := fontfind.Descriptor{Pattern: "zz-different-pipelines"}
+	first := locate.NewResolverPipeline(registry, failing).Strict().Resolve(context.Background(), desc)
+	time.Sleep(20 * time.Millisecond)
+	second := locate.NewResolverPipeline(registry, failing, found).Strict().Resolve(context.Background(), desc)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if _, err := first.Font(); !errors.Is(err, locate.ErrFontNotFound) {
+		t.Errorf("expected first pipeline to miss, got %v", err)
+	}
+	if f, err := second.Font(); err != nil || f.Name != desc.Pattern {
+		t.Errorf("expected second pipeline to resolve on its own, got %q (%v)", f.Name, err)
+	}
+}
+
+func TestConcurrentSfntOfResolvedFont(t *testing.T) {
+	// no test tracer: gotestingadapter is not safe for concurrent use
+	// run with -race
+	packaged := func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		return fallbackfont.Find()(d)
+	}
+	pipeline := locate.NewResolverPipeline(newMemoryRegistry(), packaged)
+	desc := fontfind.Descriptor{Pattern: "Go", Weight: font.WeightBold}
+	shared, err := pipeline.Resolve(context.Background(), desc).Font()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := pipeline.Resolve(context.Background(), desc).Font()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, font := range []*fontfind.ScalableFont{&f, &shared} {
+				sf, err := font.Sfnt()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := sf.GlyphIndex(new(sfnt.Buffer), 'x'); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFSLocator(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	testFS := fstest.MapFS{
+		"fonts/ReadMe.txt":   &fstest.MapFile{Data: []byte("not a font")},
+		"fonts/Broken.ttf":   &fstest.MapFile{Data: []byte("dummy")},
+		"other/Go-Bold.otf":  &fstest.MapFile{Data: []byte("dummy")},
+		"fonts/app/b.otf":    &fstest.MapFile{},
+		"fonts/app/a.otf":    &fstest.MapFile{},
+		"fonts/Gentium.ttf":  &fstest.MapFile{},
+		"fonts/app/Mono.otf": &fstest.MapFile{},
+	}
+	for name, packaged := range map[string]string{
+		"fonts/app/a.otf":    "Go-Regular.otf",
+		"fonts/app/b.otf":    "Go-Italic.otf",
+		"fonts/Gentium.ttf":  "GentiumPlus-R.ttf",
+		"fonts/app/Mono.otf": "Go-Mono.otf",
+	} {
+		data, err := os.ReadFile("fallbackfont/packaged/" + packaged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testFS[name].Data = data
+	}
+	testFS["fonts/app/c.otf"] = &fstest.MapFile{Data: withWidthClass(t, testFS["fonts/app/a.otf"].Data, 3)}
+	fsLocator := locate.FSLocator(testFS, "fonts")
+	f, err := locate.ResolveStrict(fontfind.Descriptor{Pattern: "Go", Style: font.StyleItalic},
+		fsLocator).Font()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path() != "fonts/app/b.otf" || f.Style != font.StyleItalic {
+		t.Errorf("expected italic Go font fonts/app/b.otf, have %q", f.Path())
+	}
+	if f, err = fsLocator(fontfind.Descriptor{Pattern: "Go Mono"}); err != nil || f.Path() != "fonts/app/Mono.otf" {
+		t.Errorf("expected Go Mono, have %q, %v", f.Path(), err)
+	}
+	// width is read from the OS/2 table
+	if f, err = fsLocator(fontfind.Descriptor{Pattern: "Go", Width: font.StretchCondensed}); err != nil ||
+		f.Path() != "fonts/app/c.otf" {
+		t.Errorf("expected condensed Go font fonts/app/c.otf, have %q, %v", f.Path(), err)
+	}
+	if _, err = fsLocator(fontfind.Descriptor{Pattern: "Roboto"}); !errors.Is(err, locate.ErrFontNotFound) {
+		t.Errorf("expected ErrFontNotFound for font not in file system, have %v", err)
+	}
+	if _, err = locate.FSLocator(testFS, "missing")(fontfind.Descriptor{Pattern: "Go"}); err == nil ||
+		errors.Is(err, locate.ErrFontNotFound) {
+		t.Errorf("expected error for missing root folder, have %v", err)
+	}
+}
+
+// withWidthClass returns a copy of the SFNT font data with OS/2 width class set to class.
+func withWidthClass(t *testing.T, data []byte, class uint16) []byte {
+	t.Helper()
+	data = append([]byte(nil), data...)
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		rec := data[12+16*i:]
+		if string(rec[:4]) == "OS/2" {
+			binary.BigEndian.PutUint16(data[binary.BigEndian.Uint32(rec[8:])+6:], class)
+			return data
+		}
+	}
+	t.Fatal("font has no OS/2 table")
+	return nil
+}
+
 type memoryRegistry struct {
 	mu    sync.Mutex
 	fonts map[string]fontfind.ScalableFont
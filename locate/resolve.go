@@ -2,7 +2,13 @@ package locate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"github.com/npillmayer/fontfind"
 	"github.com/npillmayer/fontfind/fontregistry"
@@ -10,21 +16,38 @@ import (
 
 // notFound returns an application error for a missing resource.
 func notFound(res string) error {
-	return fmt.Errorf("font not found: %v", res)
+	return fmt.Errorf("%w: %v", ErrFontNotFound, res)
+}
+
+// resolversFailed is the error of a resolution in which no resolver succeeded. It
+// wraps ErrFontNotFound together with the errors of the resolvers, so that callers
+// may tell e.g. a network failure of a resolver with errors.Is.
+func resolversFailed(res string, errs []error) error {
+	return errors.Join(append([]error{notFound(res)}, errs...)...)
 }
 
 // fontPlusErr is a helper struct to exchange through channels.
 type fontPlusErr struct {
-	font fontfind.ScalableFont
-	err  error
+	font       fontfind.ScalableFont
+	err        error
+	confidence fontfind.MatchConfidence
+}
+
+func (r fontPlusErr) result() FontResult {
+	return FontResult{Font: r.font, Err: r.err, Confidence: r.confidence}
 }
 
 // FontPromise runs font searching asynchronously in the background.
 // Font blocks until completion, and FontWithContext allows waiting with
 // caller-controlled cancellation and deadlines.
+//
+// Result and ResultWithContext return the match confidence together with the
+// font, e.g. for a UI to warn that a close substitute is used.
 type FontPromise interface {
 	Font() (fontfind.ScalableFont, error)
 	FontWithContext(ctx context.Context) (fontfind.ScalableFont, error)
+	Result() FontResult
+	ResultWithContext(ctx context.Context) FontResult
 }
 
 // FontRegistry is the cache contract required by ResolverPipeline.
@@ -38,10 +61,22 @@ type FontRegistry interface {
 type ResolverPipeline struct {
 	registry  FontRegistry
 	resolvers []FontLocatorWithContext
+	id        uint64 // identity of the resolvers, see identity
+	strict    bool   // never answer with the registry fallback font
+	parallel  bool   // run resolvers concurrently, first success wins
+	misses    *fontregistry.NegativeCache
 }
 
+// pipelineIDs counts the pipelines created by NewResolverPipeline. ID 0 is the
+// identity of the default pipeline, see defaultPipeline.
+var pipelineIDs atomic.Uint64
+
 // NewResolverPipeline constructs a resolver driver with an optional custom registry.
-// If reg is nil, the global registry singleton is used.
+// If reg is nil, the global registry singleton is used. Failed lookups are not
+// remembered, unless a negative cache is set with WithNegativeCache.
+//
+// Every pipeline created has an identity of its own (see WithNegativeCache), which
+// is kept by the copies returned by Strict, Parallel, etc.
 func NewResolverPipeline(reg FontRegistry, resolvers ...FontLocatorWithContext) ResolverPipeline {
 	if reg == nil {
 		reg = fontregistry.GlobalRegistry()
@@ -51,11 +86,72 @@ func NewResolverPipeline(reg FontRegistry, resolvers ...FontLocatorWithContext)
 	return ResolverPipeline{
 		registry:  reg,
 		resolvers: rs,
+		id:        pipelineIDs.Add(1),
+	}
+}
+
+// defaultPipeline creates the pipeline used by the package-level functions, e.g.
+// ResolveFontLoc. It uses the global registry and the global negative cache, thus a
+// client calling ResolveFontLoc for an unavailable font, e.g. in a render loop, does
+// not run the resolvers on every call. As clients create their locators anew for
+// every call, default pipelines share a single identity, whatever their resolvers.
+func defaultPipeline(resolvers ...FontLocatorWithContext) ResolverPipeline {
+	pipeline := NewResolverPipeline(nil, resolvers...)
+	pipeline.id = 0
+	pipeline.misses = fontregistry.GlobalNegativeCache()
+	return pipeline
+}
+
+// adaptedPipeline creates the default pipeline for FontLocators (see adaptLocator).
+func adaptedPipeline(resolvers []FontLocator) ResolverPipeline {
+	ctxResolvers := make([]FontLocatorWithContext, 0, len(resolvers))
+	for _, r := range resolvers {
+		ctxResolvers = append(ctxResolvers, adaptLocator(r))
 	}
+	return defaultPipeline(ctxResolvers...)
+}
+
+// identity tells pipelines apart which may resolve the same descriptor differently,
+// i.e. pipelines with different resolvers or different handling of failures.
+// Resolutions and misses are shared between pipelines of the same identity only.
+func (pipeline ResolverPipeline) identity() string {
+	return fmt.Sprintf("%d|strict=%t|parallel=%t", pipeline.id, pipeline.strict, pipeline.parallel)
+}
+
+// WithNegativeCache returns a copy of the pipeline which remembers failed lookups in
+// misses. While a miss is remembered, lookups for the same font skip the resolvers and
+// fail immediately. A nil cache disables remembering misses, which is the default for
+// pipelines created by NewResolverPipeline. The package-level functions, e.g.
+// ResolveFontLoc, use fontregistry.GlobalNegativeCache.
+//
+// Misses are remembered per pipeline identity, thus different pipelines may share a
+// cache. Only fonts which could not be found are remembered, not failures which may
+// be transient, e.g. network failures or timeouts.
+func (pipeline ResolverPipeline) WithNegativeCache(misses *fontregistry.NegativeCache) ResolverPipeline {
+	pipeline.misses = misses
+	return pipeline
+}
+
+// Strict returns a copy of the pipeline which never returns the registry fallback font.
+// If no resolver succeeds, the strict pipeline's promise yields NullFont together with
+// an error wrapping ErrFontNotFound.
+func (pipeline ResolverPipeline) Strict() ResolverPipeline {
+	pipeline.strict = true
+	return pipeline
+}
+
+// Parallel returns a copy of the pipeline which runs all resolvers concurrently.
+// The first resolver to succeed wins and the other resolvers are cancelled via
+// their context. Resolvers ignoring their context will run to completion, but
+// their results are discarded. If all resolvers fail, the pipeline falls back as
+// usual.
+func (pipeline ResolverPipeline) Parallel() ResolverPipeline {
+	pipeline.parallel = true
+	return pipeline
 }
 
 type fontLoader struct {
-	await func(ctx context.Context) (fontfind.ScalableFont, error)
+	await func(ctx context.Context) FontResult
 }
 
 func (loader fontLoader) Font() (fontfind.ScalableFont, error) {
@@ -63,95 +159,391 @@ func (loader fontLoader) Font() (fontfind.ScalableFont, error) {
 }
 
 func (loader fontLoader) FontWithContext(ctx context.Context) (fontfind.ScalableFont, error) {
+	r := loader.await(ctx)
+	return r.Font, r.Err
+}
+
+func (loader fontLoader) Result() FontResult {
+	return loader.ResultWithContext(context.Background())
+}
+
+func (loader fontLoader) ResultWithContext(ctx context.Context) FontResult {
 	return loader.await(ctx)
 }
 
 // ResolveFontLoc resolves a scalable font using the given resolver chain.
 //
-// It first checks the global font registry cache. On a cache miss, resolvers are
-// tried in the given order until one succeeds. A successful resolution is stored
-// in the registry cache. If all resolvers fail, it returns the registry fallback
-// font together with a not-found error.
+// desc is validated first (see fontfind.Descriptor.Validate). An invalid descriptor
+// yields NullFont and the validation error, without trying any resolver.
+//
+// It then checks the global font registry cache. On a cache miss, resolvers are
+// tried in the given order until one succeeds (see package locate/resolvers for
+// the standard chain). A successful resolution is stored in the registry cache.
+// If all resolvers fail, it returns the registry fallback font together with an
+// error wrapping ErrFontNotFound and the errors of the resolvers (see errors.Is).
+// Fonts not found are remembered in fontregistry.GlobalNegativeCache for a short
+// while, for all calls of the package-level functions: until the miss expires,
+// lookups for the font fail without calling the resolvers again. Clear the cache
+// to look for fonts installed in the meantime.
 //
 // The search runs asynchronously and returns a FontPromise.
 func ResolveFontLoc(desc fontfind.Descriptor, resolvers ...FontLocator) FontPromise {
-	ctxResolvers := make([]FontLocatorWithContext, 0, len(resolvers))
-	for _, r := range resolvers {
-		ctxResolvers = append(ctxResolvers, adaptLocator(r))
-	}
-	return NewResolverPipeline(nil, ctxResolvers...).Resolve(context.Background(), desc)
+	return adaptedPipeline(resolvers).Resolve(context.Background(), desc)
+}
+
+// ResolveStrict resolves a scalable font like ResolveFontLoc, but never falls back
+// to the registry fallback font. This is useful for clients who want a hard failure
+// on a miss, e.g., validators. On a miss, the promise yields NullFont and an error
+// wrapping ErrFontNotFound.
+func ResolveStrict(desc fontfind.Descriptor, resolvers ...FontLocator) FontPromise {
+	return adaptedPipeline(resolvers).Strict().Resolve(context.Background(), desc)
+}
+
+// ResolveFontLocParallel resolves a scalable font like ResolveFontLoc, but runs all
+// resolvers concurrently instead of one after another. The first resolver to succeed
+// wins, regardless of its position in the chain. This keeps slow resolvers (e.g.,
+// downloads) from delaying fast ones.
+func ResolveFontLocParallel(desc fontfind.Descriptor, resolvers ...FontLocator) FontPromise {
+	return adaptedPipeline(resolvers).Parallel().Resolve(context.Background(), desc)
 }
 
 // ResolveFontLocWithContext is the context-aware variant of ResolveFontLoc.
 // The search goroutine and resolver calls receive ctx.
 func ResolveFontLocWithContext(ctx context.Context, desc fontfind.Descriptor, resolvers ...FontLocatorWithContext) FontPromise {
-	return NewResolverPipeline(nil, resolvers...).Resolve(ctx, desc)
+	return defaultPipeline(resolvers...).Resolve(ctx, desc)
+}
+
+// ResolveFontLocWithTimeout resolves a scalable font like ResolveFontLoc, but gives
+// up after d. If no font has been resolved by then, the promise yields NullFont and
+// context.DeadlineExceeded, without waiting for the resolver still running.
+// Resolvers not yet called are skipped; a resolver already running is not
+// interrupted, as FontLocators cannot be cancelled.
+func ResolveFontLocWithTimeout(d time.Duration, desc fontfind.Descriptor, resolvers ...FontLocator) FontPromise {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	return adaptedPipeline(resolvers).resolve(ctx, desc, cancel)
 }
 
 // Resolve resolves a font request asynchronously using this pipeline's registry and resolvers.
+// If ctx is done before the font has been resolved, the promise yields NullFont and
+// the context's error.
 func (pipeline ResolverPipeline) Resolve(ctx context.Context, desc fontfind.Descriptor) FontPromise {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	registry := pipeline.registry
-	if registry == nil {
-		registry = fontregistry.GlobalRegistry()
+	return pipeline.resolve(ctx, desc, func() {})
+}
+
+// resolve starts the search for a font and calls done when the search has finished,
+// e.g. to release the resources of ctx.
+func (pipeline ResolverPipeline) resolve(ctx context.Context, desc fontfind.Descriptor, done func()) FontPromise {
+	if pipeline.registry == nil {
+		pipeline.registry = fontregistry.GlobalRegistry()
 	}
-	ch := make(chan fontPlusErr)
+	// buffered, so that the search goroutine can finish even if the promise is
+	// never awaited
+	ch := make(chan fontPlusErr, 1)
 	go func(ch chan<- fontPlusErr) {
-		result := searchScalableFont(ctx, registry, desc, pipeline.resolvers)
+		result := searchScalableFont(ctx, pipeline, desc)
 		ch <- result
 		close(ch)
+		done()
 	}(ch)
 	loader := fontLoader{}
 	// waitCtx is supplied by the caller when awaiting the promise.
-	loader.await = func(waitCtx context.Context) (fontfind.ScalableFont, error) {
+	loader.await = func(waitCtx context.Context) FontResult {
 		select {
 		case <-waitCtx.Done():
-			return fontfind.NullFont, waitCtx.Err()
+			return FontResult{Font: fontfind.NullFont, Err: waitCtx.Err()}
 		case r := <-ch:
-			return r.font, r.err
+			return r.result()
+		case <-ctx.Done():
+			select { // the search may have finished just in time
+			case r := <-ch:
+				return r.result()
+			default:
+				return FontResult{Font: fontfind.NullFont, Err: ctx.Err()}
+			}
 		}
 	}
 	return loader
 }
 
+// adaptLocator turns a FontLocator into a FontLocatorWithContext. The wrapped
+// locator cannot be interrupted, but it is not called at all if ctx is already done.
 func adaptLocator(r FontLocator) FontLocatorWithContext {
-	return func(_ context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+	return func(ctx context.Context, d fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		if err := ctx.Err(); err != nil {
+			return fontfind.NullFont, err
+		}
 		return r(d)
 	}
 }
 
-func searchScalableFont(ctx context.Context, registry FontRegistry, desc fontfind.Descriptor, resolvers []FontLocatorWithContext) (result fontPlusErr) {
+// callResolver calls a resolver, converting a panic of the resolver into an error
+// (see callSafely), and checks the font returned against desc.
+func callResolver(ctx context.Context, resolver FontLocatorWithContext, desc fontfind.Descriptor) (
+	fontfind.ScalableFont, error) {
+	//
+	f, err := callSafely(ctx, resolver, desc)
+	if err != nil {
+		return fontfind.NullFont, err
+	}
+	if err = checkCoverage(&f, desc); err != nil {
+		return fontfind.NullFont, err
+	}
+	if f.Instance == nil {
+		if _, err := f.SelectInstance(desc.Style, desc.Weight); err != nil {
+			tracer().Debugf("cannot read named instances of font %s: %v", f.Name, err)
+		}
+	}
+	return f, nil
+}
+
+// callSafely calls a resolver, converting a panic of the resolver into an error.
+// This protects the resolver chain against faulty third-party resolvers.
+func callSafely(ctx context.Context, resolver FontLocatorWithContext, desc fontfind.Descriptor) (
+	f fontfind.ScalableFont, err error) {
+	//
+	defer func() {
+		if r := recover(); r != nil {
+			tracer().Errorf("font resolver panicked for %q: %v", desc.Pattern, r)
+			f, err = fontfind.NullFont, fmt.Errorf("font resolver panicked: %v", r)
+		}
+	}()
+	if f, err = resolver(ctx, desc); err != nil {
+		return fontfind.NullFont, err
+	}
+	return f, nil
+}
+
+// checkCoverage returns an error if font f lacks glyphs for the sample text of desc.
+func checkCoverage(f *fontfind.ScalableFont, desc fontfind.Descriptor) error {
+	if desc.Sample == "" {
+		return nil
+	}
+	missing, err := f.Covers([]rune(desc.Sample))
+	if err != nil {
+		return fmt.Errorf("cannot check coverage of font %s: %w", f.Name, err)
+	}
+	if len(missing) > 0 {
+		tracer().Debugf("font %s lacks glyphs for %q", f.Name, string(missing))
+		return fmt.Errorf("font %s lacks glyphs for %q", f.Name, string(missing))
+	}
+	return nil
+}
+
+// registryKey returns the name under which a font for desc is cached in a registry.
+// The key includes the scripts of desc.Sample (see sampleScripts), so that a font
+// found for a sample does not compete with fonts found by name only, or for a
+// sample in other scripts. Fonts taken from the registry are still checked for
+// coverage of the sample.
+func registryKey(desc fontfind.Descriptor) string {
+	name := fontregistry.NormalizeFontnameWithWidth(desc.Pattern, desc.Style, desc.Weight, desc.Width)
+	if desc.Subset != "" {
+		name += "-" + strings.ToLower(desc.Subset)
+	}
+	if desc.Category != "" {
+		name += "-" + strings.ToLower(desc.Category)
+	}
+	if desc.MinConfidence != fontfind.NoConfidence { // lenient matches must not be reused
+		name += fmt.Sprintf("-c%d", desc.MinConfidence)
+	}
+	if desc.FuzzyFamily { // a fuzzy match must not answer an exact lookup
+		name += "-fuzzy"
+	}
+	if scripts := sampleScripts(desc.Sample); len(scripts) > 0 {
+		name += "-" + strings.ToLower(strings.Join(scripts, "+"))
+	}
+	return name
+}
+
+// sampleScripts returns the sorted names of the Unicode scripts of the letters in
+// sample, e.g. ["Devanagari", "Latin"]. Characters common to scripts, like digits
+// and punctuation, are not counted.
+func sampleScripts(sample string) []string {
+	seen := make(map[string]bool)
+	var scripts []string
+	for _, r := range sample {
+		for name, table := range unicode.Scripts {
+			if name == "Common" || name == "Inherited" || !unicode.Is(table, r) {
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				scripts = append(scripts, name)
+			}
+			break
+		}
+	}
+	sort.Strings(scripts)
+	return scripts
+}
+
+func searchScalableFont(ctx context.Context, pipeline ResolverPipeline, desc fontfind.Descriptor) (result fontPlusErr) {
+	defer func() { notifyResolve(desc, result) }()
+	if err := desc.Validate(); err != nil { // a caller's mistake, no fallback
+		stats.failures.Add(1)
+		result.err = err
+		return
+	}
 	if err := ctx.Err(); err != nil {
+		stats.failures.Add(1)
 		result.err = err
 		return
 	}
+	registry := pipeline.registry
 	if registry == nil {
 		registry = fontregistry.GlobalRegistry()
 	}
-	name := fontregistry.NormalizeFontname(desc.Pattern, desc.Style, desc.Weight)
-	if t, err := registry.GetFont(name); err == nil {
-		result.font = t
+	name := registryKey(desc)
+	if t, err := registry.GetFont(name); err == nil && checkCoverage(&t, desc) == nil {
+		stats.registryHits.Add(1)
+		result.font, result.confidence = t, confidenceOf(t, desc)
 		return
 	}
-	for _, resolver := range resolvers {
-		if err := ctx.Err(); err != nil {
-			result.err = err
-			return
+	lookup := name
+	if desc.Sample != "" { // fonts found by name may still lack glyphs for the sample
+		lookup += "|" + desc.Sample
+	}
+	missKey := pipeline.identity() + "|" + lookup
+	resolve := chainResolvers
+	if pipeline.parallel {
+		resolve = raceResolvers
+	}
+	resolution := func() (fontfind.ScalableFont, int, error) {
+		// a resolution for the same font may have finished in the meantime
+		if t, err := registry.GetFont(name); err == nil && checkCoverage(&t, desc) == nil {
+			return t, -1, nil
 		}
-		if f, err := resolver(ctx, desc); err == nil {
-			registry.StoreFont(name, f)
-			result.font = f
-			return
-		} else if ctxErr := ctx.Err(); ctxErr != nil {
-			result.err = ctxErr
-			return
+		f, i, err := resolveGeneric(ctx, resolve, pipeline.resolvers, desc)
+		if err == nil {
+			f.Confidence = confidenceOf(f, desc)
+			stored := f
+			stored.Close() // the registry does not keep font data retained, see fontfind.ScalableFont.Retain
+			registry.StoreFont(name, stored)
+		}
+		return f, i, err
+	}
+	if pipeline.misses.Contains(missKey) {
+		tracer().Debugf("font %s has recently not been found, skipping resolvers", name)
+		result.err = notFound(name)
+	} else if f, i, err := inFlight.resolveOnce(ctx, registry, pipeline.identity(), lookup, resolution); err == nil {
+		if i < 0 {
+			stats.registryHits.Add(1)
+		} else {
+			stats.resolverHit(i)
 		}
+		result.font, result.confidence = f, f.Confidence
+		return
+	} else if ctxErr := ctx.Err(); ctxErr != nil {
+		stats.failures.Add(1)
+		result.err = ctxErr
+		return
+	} else {
+		result.err = fmt.Errorf("%s: %w", name, err)
+		if isMiss(err) {
+			pipeline.misses.Add(missKey)
+		}
+	}
+	if pipeline.strict {
+		stats.failures.Add(1)
+		return result
 	}
-	result.err = notFound(name)
 	if f, err := registry.FallbackFont(); err == nil {
+		stats.fallbacks.Add(1)
 		result.font = f
+	} else {
+		stats.failures.Add(1)
 	}
 	return result
 }
+
+// isMiss returns true if err of a failed resolution tells that a font does not exist.
+// Failures which may be transient or caused by configuration, e.g. network failures
+// or timeouts, are not misses.
+func isMiss(err error) bool {
+	for _, transient := range []error{ErrNetworkFailure, ErrResolverTimeout, ErrMissingAPIKey,
+		ErrCacheFailure, context.Canceled, context.DeadlineExceeded} {
+		if errors.Is(err, transient) {
+			return false
+		}
+	}
+	return true
+}
+
+// confidenceOf tells how well a resolved font f matches desc. This is the confidence
+// of the locator which found f. Only for locators which do not tell, e.g. third-party
+// ones, or fonts stored into the registry by clients, the font binary is read to
+// judge the match (see fontfind.MatchFont).
+func confidenceOf(f fontfind.ScalableFont, desc fontfind.Descriptor) fontfind.MatchConfidence {
+	if f.Confidence != fontfind.NoConfidence {
+		return f.Confidence
+	}
+	confidence, err := fontfind.MatchFont(f, desc.Style, desc.Weight, desc.Width)
+	if err != nil {
+		tracer().Debugf("cannot tell match confidence of font %s: %v", f.Name, err)
+	}
+	return confidence
+}
+
+// resolveFunc is the signature of chainResolvers and raceResolvers.
+type resolveFunc func(context.Context, []FontLocatorWithContext, fontfind.Descriptor) (
+	fontfind.ScalableFont, int, error)
+
+// chainResolvers calls resolvers one after another and returns the first successful
+// result, together with the position of the resolver in the chain.
+func chainResolvers(ctx context.Context, resolvers []FontLocatorWithContext, desc fontfind.Descriptor) (
+	fontfind.ScalableFont, int, error) {
+	//
+	var errs []error
+	for i, resolver := range resolvers {
+		if err := ctx.Err(); err != nil {
+			return fontfind.NullFont, -1, err
+		}
+		f, err := callResolver(ctx, resolver, desc)
+		if err == nil {
+			return f, i, nil
+		} else if ctxErr := ctx.Err(); ctxErr != nil {
+			return fontfind.NullFont, -1, ctxErr
+		}
+		tracer().Debugf("resolver %d failed: %v", i, err)
+		errs = append(errs, err)
+	}
+	return fontfind.NullFont, -1, resolversFailed("no resolver succeeded", errs)
+}
+
+// raceResolvers calls all resolvers concurrently and returns the first successful
+// result, together with the position of the resolver in the chain. Resolvers still
+// running are cancelled.
+func raceResolvers(ctx context.Context, resolvers []FontLocatorWithContext, desc fontfind.Descriptor) (
+	fontfind.ScalableFont, int, error) {
+	//
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type answer struct {
+		font     fontfind.ScalableFont
+		position int
+		err      error
+	}
+	// buffered, so that resolvers finishing after the race never block
+	answers := make(chan answer, len(resolvers))
+	for i, resolver := range resolvers {
+		go func(i int, resolver FontLocatorWithContext) {
+			f, err := callResolver(ctx, resolver, desc)
+			answers <- answer{font: f, position: i, err: err}
+		}(i, resolver)
+	}
+	var errs []error
+	for range resolvers {
+		select {
+		case a := <-answers:
+			if a.err == nil {
+				return a.font, a.position, nil
+			}
+			errs = append(errs, a.err)
+		case <-ctx.Done():
+			return fontfind.NullFont, -1, ctx.Err()
+		}
+	}
+	return fontfind.NullFont, -1, resolversFailed("no resolver succeeded", errs)
+}
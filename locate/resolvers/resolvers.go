@@ -0,0 +1,98 @@
+/*
+Package resolvers assembles the standard chain of font locators.
+
+Package locate does not know about the locators in its sub-packages, which
+import it, so clients assemble chains themselves or use the chain of Default:
+
+	promise := locate.ResolveFontLoc(desc, resolvers.Default("myapp", conf)...)
+
+DefaultWithContext returns the same chain for context-aware resolution and for
+dry runs with locate.LocateFont.
+*/
+package resolvers
+
+import (
+	"context"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
+	"github.com/npillmayer/fontfind/locate/fallbackfont"
+	"github.com/npillmayer/fontfind/locate/googlefont"
+	"github.com/npillmayer/fontfind/locate/systemfont"
+	"github.com/npillmayer/schuko"
+)
+
+// Default returns the standard chain of font locators, in this order:
+//
+//   - fonts packaged with this module (see fallbackfont.Find)
+//   - fonts installed on the host (see systemfont.FindWithConfig)
+//   - fonts from the Google Fonts service (see googlefont.Find)
+//
+// Packaged fonts are cheapest to load, and downloads are tried last. Fonts not
+// found by any locator resolve to the registry fallback font.
+//
+// appkey identifies the application, e.g. for the location of the font cache. It
+// overrides configuration key "app-key" of conf, unless it is empty. conf may be
+// nil, using the default configuration.
+//
+// The Google Fonts locator of this chain does not see a context, and therefore
+// downloads fonts even in a dry run. Use DefaultWithContext for locate.LocateFont.
+func Default(appkey string, conf schuko.Configuration) []locate.FontLocator {
+	conf = defaultConfig(appkey, conf)
+	return []locate.FontLocator{
+		fallbackfont.Find(),
+		systemfont.FindWithConfig(conf, systemfont.USE_SYSTEM_IO),
+		googlefont.Find(conf, googlefont.USE_SYSTEM_IO),
+	}
+}
+
+// DefaultWithContext returns the chain of Default for context-aware resolution,
+// e.g. locate.ResolveFontLocWithContext. Fonts from the Google Fonts service are
+// located with googlefont.FindWithContext, which cancels downloads with the context
+// and honors dry runs of locate.LocateFont.
+func DefaultWithContext(appkey string, conf schuko.Configuration) []locate.FontLocatorWithContext {
+	conf = defaultConfig(appkey, conf)
+	return []locate.FontLocatorWithContext{
+		withContext(fallbackfont.Find()),
+		withContext(systemfont.FindWithConfig(conf, systemfont.USE_SYSTEM_IO)),
+		googlefont.FindWithContext(conf, googlefont.USE_SYSTEM_IO),
+	}
+}
+
+// defaultConfig returns the configuration of the Default chain, see there.
+func defaultConfig(appkey string, conf schuko.Configuration) schuko.Configuration {
+	if conf == nil {
+		return googlefont.SimpleConfig(appkey)
+	} else if appkey != "" {
+		return appConfig{Configuration: conf, appkey: appkey}
+	}
+	return conf
+}
+
+// withContext adapts a locator which neither downloads fonts nor blocks for long,
+// checking the context before calling it.
+func withContext(r locate.FontLocator) locate.FontLocatorWithContext {
+	return func(ctx context.Context, desc fontfind.Descriptor) (fontfind.ScalableFont, error) {
+		if err := ctx.Err(); err != nil {
+			return fontfind.NullFont, err
+		}
+		return r(desc)
+	}
+}
+
+// appConfig overrides the "app-key" of a configuration.
+type appConfig struct {
+	schuko.Configuration
+	appkey string
+}
+
+func (c appConfig) IsSet(key string) bool {
+	return key == "app-key" || c.Configuration.IsSet(key)
+}
+
+func (c appConfig) GetString(key string) string {
+	if key == "app-key" {
+		return c.appkey
+	}
+	return c.Configuration.GetString(key)
+}
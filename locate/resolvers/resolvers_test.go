@@ -0,0 +1,54 @@
+package resolvers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
+	"github.com/npillmayer/schuko/schukonf/testconfig"
+	"github.com/npillmayer/schuko/tracing/gotestingadapter"
+)
+
+func TestDefaultChain(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	conf := testconfig.Conf{
+		"app-key": "other",
+		"offline": true,
+	}
+	chain := Default("tyse-test", conf)
+	if len(chain) != 3 {
+		t.Fatalf("expected chain of 3 locators, have %d", len(chain))
+	}
+	if c := (appConfig{Configuration: conf, appkey: "tyse-test"}); c.GetString("app-key") != "tyse-test" ||
+		!c.GetBool("offline") {
+		t.Errorf("expected app-key to be overridden only")
+	}
+	f, err := locate.ResolveStrict(fontfind.Descriptor{Pattern: "Go"}, chain...).Font()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Source != fontfind.SourcePackaged {
+		t.Errorf("expected packaged font to be found first, have source %v", f.Source)
+	}
+}
+
+func TestDefaultChainDryRun(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	conf := testconfig.Conf{"offline": true}
+	chain := DefaultWithContext("tyse-test", conf)
+	if len(chain) != 3 {
+		t.Fatalf("expected chain of 3 locators, have %d", len(chain))
+	}
+	report, err := locate.LocateFont(context.Background(), fontfind.Descriptor{Pattern: "Go"}, chain...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resolver != 0 || report.Font.Source != fontfind.SourcePackaged || report.URL != "" {
+		t.Errorf("expected packaged font to be located first, have %+v", report)
+	}
+}
@@ -0,0 +1,107 @@
+package locate
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/npillmayer/fontfind"
+)
+
+// ResolveStats is a snapshot of process-wide resolution counters.
+type ResolveStats struct {
+	RegistryHits uint64   // lookups satisfied by the registry cache
+	ResolverHits []uint64 // lookups satisfied by a resolver, indexed by position in the chain
+	Fallbacks    uint64   // lookups answered with the registry fallback font
+	Failures     uint64   // lookups without any result, e.g. cancelled ones
+}
+
+// resolveCounters holds the process-wide counters behind ResolveStats.
+type resolveCounters struct {
+	registryHits atomic.Uint64
+	fallbacks    atomic.Uint64
+	failures     atomic.Uint64
+	mu           sync.Mutex // guards growing resolverHits
+	resolverHits []*atomic.Uint64
+}
+
+var stats resolveCounters
+
+func (c *resolveCounters) resolverHit(position int) {
+	c.mu.Lock()
+	for len(c.resolverHits) <= position {
+		c.resolverHits = append(c.resolverHits, new(atomic.Uint64))
+	}
+	counter := c.resolverHits[position]
+	c.mu.Unlock()
+	counter.Add(1)
+}
+
+// Stats returns a snapshot of counters telling how often font resolution was
+// satisfied by the registry cache, by each resolver in a chain, or by the fallback font.
+// Counters are process-wide and cover all resolver pipelines.
+func Stats() ResolveStats {
+	s := ResolveStats{
+		RegistryHits: stats.registryHits.Load(),
+		Fallbacks:    stats.fallbacks.Load(),
+		Failures:     stats.failures.Load(),
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	s.ResolverHits = make([]uint64, len(stats.resolverHits))
+	for i, c := range stats.resolverHits {
+		s.ResolverHits[i] = c.Load()
+	}
+	return s
+}
+
+// ResetStats sets all resolution counters to zero.
+func ResetStats() {
+	stats.registryHits.Store(0)
+	stats.fallbacks.Store(0)
+	stats.failures.Store(0)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.resolverHits = nil
+}
+
+// ResolveHook is called with the outcome of a font resolution, see OnResolve.
+// source is the source of the font resolved, i.e. fontfind.SourcePackaged for the
+// fallback font, and fontfind.SourceUnknown if there is no font at all.
+type ResolveHook func(desc fontfind.Descriptor, source fontfind.Source, err error)
+
+var resolveHooks struct {
+	sync.Mutex
+	hooks []*ResolveHook // pointers tell subscriptions apart, see OnResolve
+}
+
+// OnResolve subscribes hook to the outcome of every font resolution of any resolver
+// pipeline, including lookups satisfied by the registry cache, e.g. for logging or
+// metering font usage. Hooks are called synchronously from the resolving goroutine,
+// without holding any lock. The returned function cancels the subscription; calling
+// it more than once does nothing.
+func OnResolve(hook ResolveHook) (unsubscribe func()) {
+	resolveHooks.Lock()
+	defer resolveHooks.Unlock()
+	h := &hook
+	resolveHooks.hooks = append(resolveHooks.hooks, h)
+	return func() {
+		resolveHooks.Lock()
+		defer resolveHooks.Unlock()
+		hooks := make([]*ResolveHook, 0, len(resolveHooks.hooks)) // keep snapshots intact
+		for _, other := range resolveHooks.hooks {
+			if other != h {
+				hooks = append(hooks, other)
+			}
+		}
+		resolveHooks.hooks = hooks
+	}
+}
+
+func notifyResolve(desc fontfind.Descriptor, result fontPlusErr) {
+	resolveHooks.Lock()
+	hooks := resolveHooks.hooks // appending never changes this snapshot
+	resolveHooks.Unlock()
+	for _, hook := range hooks {
+		(*hook)(desc, result.font.Source, result.err)
+	}
+}
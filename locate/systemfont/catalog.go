@@ -0,0 +1,172 @@
+package systemfont
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"runtime"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+	"golang.org/x/image/font/sfnt"
+)
+
+// CatalogEntry is a scanned font, enriched with metadata read from the font binary.
+type CatalogEntry struct {
+	fontfind.FontVariantsLocation
+	FontFamily string   // family name as found in the font's name table
+	FullName   string   // full font name as found in the font's name table
+	Scripts    []string // scripts with glyph coverage, see CoverageSamples
+	Monospace  bool     // font is flagged as fixed-pitch
+	Color      bool     // font contains color glyph tables (COLR, CBDT, sbix, SVG)
+}
+
+// Catalog is a list of scanned fonts together with errors encountered during parsing.
+// Fonts which could not be parsed are not included in Entries.
+type Catalog struct {
+	Entries []CatalogEntry
+	Errors  []error
+}
+
+// CoverageSamples maps script names to a representative rune. A font covers a
+// script if it has a glyph for the script's sample rune.
+var CoverageSamples = []struct {
+	Script string
+	Sample rune
+}{
+	{"latin", 'A'},
+	{"greek", 'Ω'},
+	{"cyrillic", 'Ж'},
+	{"hebrew", 'א'},
+	{"arabic", 'ب'},
+	{"devanagari", 'क'},
+	{"thai", 'ก'},
+	{"hangul", '한'},
+	{"kana", 'あ'},
+	{"han", '中'},
+}
+
+// BuildCatalog parses a list of fonts concurrently and extracts family names,
+// script coverage and monospace/color flags. At most workers fonts are parsed
+// in parallel; if workers is not positive, runtime.NumCPU() is used.
+//
+// Parse errors for single fonts are collected in the catalog and are not fatal.
+// If ctx is cancelled, BuildCatalog stops dispatching fonts and returns the
+// context's error together with the entries parsed so far.
+func BuildCatalog(ctx context.Context, fonts []fontfind.FontVariantsLocation, workers int) (Catalog, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	type parseResult struct {
+		entry CatalogEntry
+		err   error
+		done  bool
+	}
+	results := make([]parseResult, len(fonts))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				entry, err := parseCatalogEntry(fonts[i])
+				results[i] = parseResult{entry: entry, err: err, done: true}
+			}
+		}()
+	}
+	var ctxErr error
+dispatch:
+	for i := range fonts {
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+			break dispatch
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	var catalog Catalog
+	for _, r := range results {
+		if !r.done {
+			continue
+		}
+		if r.err != nil {
+			catalog.Errors = append(catalog.Errors, r.err)
+			continue
+		}
+		catalog.Entries = append(catalog.Entries, r.entry)
+	}
+	tracer().Debugf("catalog contains %d fonts, %d parse errors", len(catalog.Entries), len(catalog.Errors))
+	return catalog, ctxErr
+}
+
+func parseCatalogEntry(loc fontfind.FontVariantsLocation) (CatalogEntry, error) {
+	entry := CatalogEntry{FontVariantsLocation: loc}
+	fsys, name, err := wrapDirFS(loc.Path)
+	if err != nil {
+		return entry, fmt.Errorf("cannot open font %s: %w", loc.Path, err)
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return entry, fmt.Errorf("cannot read font %s: %w", loc.Path, err)
+	}
+	coll, err := sfnt.ParseCollection(data) // single fonts are collections of one face
+	if err != nil {
+		return entry, fmt.Errorf("cannot parse font %s: %w", loc.Path, err)
+	}
+	if loc.FaceIndex < 0 || loc.FaceIndex >= coll.NumFonts() {
+		return entry, fmt.Errorf("cannot parse font %s: face index %d out of range", loc.Path, loc.FaceIndex)
+	}
+	f, err := coll.Font(loc.FaceIndex)
+	if err != nil {
+		return entry, fmt.Errorf("cannot parse face %d of font %s: %w", loc.FaceIndex, loc.Path, err)
+	}
+	var buf sfnt.Buffer
+	if entry.FontFamily, err = f.Name(&buf, sfnt.NameIDTypographicFamily); err != nil {
+		entry.FontFamily, _ = f.Name(&buf, sfnt.NameIDFamily)
+	}
+	entry.FullName, _ = f.Name(&buf, sfnt.NameIDFull)
+	for _, s := range CoverageSamples {
+		if gid, err := f.GlyphIndex(&buf, s.Sample); err == nil && gid != 0 {
+			entry.Scripts = append(entry.Scripts, s.Script)
+		}
+	}
+	if post := f.PostTable(); post != nil {
+		entry.Monospace = post.IsFixedPitch
+	}
+	tags := tableTags(data, loc.FaceIndex)
+	entry.Color = tags["COLR"] || tags["CBDT"] || tags["sbix"] || tags["SVG "]
+	return entry, nil
+}
+
+// tableTags reads the table directory of face number index of an SFNT font or font
+// collection and returns the set of table tags present.
+func tableTags(data []byte, index int) map[string]bool {
+	tags := make(map[string]bool)
+	offset := 0
+	if len(data) >= 12 && string(data[:4]) == "ttcf" {
+		if rec := 12 + 4*index; index >= 0 && rec+4 <= len(data) {
+			offset = int(binary.BigEndian.Uint32(data[rec:]))
+		} else {
+			return tags
+		}
+	}
+	if offset < 0 || offset+12 > len(data) {
+		return tags
+	}
+	numTables := int(binary.BigEndian.Uint16(data[offset+4:]))
+	for i := 0; i < numTables; i++ {
+		rec := offset + 12 + 16*i
+		if rec+4 > len(data) {
+			break
+		}
+		tags[string(data[rec:rec+4])] = true
+	}
+	return tags
+}
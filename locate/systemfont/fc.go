@@ -6,9 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"math"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/npillmayer/fontfind"
 	"golang.org/x/image/font"
@@ -29,8 +32,9 @@ func findFontListConfigDir(appkey string, io IO) (fs.FS, error) {
 	return fs.Sub(io.DirFS(fontListConfigDir), "fontconfig")
 }
 
+const listfile = "fontlist.txt"
+
 func findFontList(appkey string, io IO) (list []byte, err error) {
-	const listfile = "fontlist.txt"
 	var configFS fs.FS
 	configFS, err = findFontListConfigDir(appkey, io)
 	if err != nil {
@@ -39,6 +43,38 @@ func findFontList(appkey string, io IO) (list []byte, err error) {
 	return readFile(configFS, listfile, io)
 }
 
+// variantName creates a variant name from a CSS weight and a style, e.g. "300",
+// "700italic", "regular" or "oblique".
+func variantName(css int, style font.Style) string {
+	variant := strconv.Itoa(css)
+	switch style {
+	case font.StyleItalic:
+		variant += "italic"
+	case font.StyleOblique:
+		variant += "oblique"
+	}
+	if css == 400 && style != font.StyleNormal {
+		variant = variant[3:]
+	} else if css == 400 {
+		variant = "regular"
+	}
+	return variant
+}
+
+// fontListModTime returns the modification time of the font list file. If there is
+// no font list file, ok is false.
+func fontListModTime(appkey string, io IO) (mtime time.Time, ok bool) {
+	configFS, err := findFontListConfigDir(appkey, io)
+	if err != nil {
+		return
+	}
+	fi, err := fs.Stat(configFS, listfile)
+	if err != nil {
+		return
+	}
+	return fi.ModTime(), true
+}
+
 func readFile(fsys fs.FS, name string, io IO) ([]byte, error) {
 	if readFS, ok := fsys.(fs.ReadFileFS); ok {
 		// Fast file reading within the sandboxed config area.
@@ -56,85 +92,306 @@ func readFile(fsys fs.FS, name string, io IO) ([]byte, error) {
 var noFonts = []fontfind.FontVariantsLocation{}
 
 // loadFontConfigList searches the user's configuration directory for a font list file,
-// then reads the file and parses it into a list of font variants.
-// This list of font variants is then stored globally.
-func loadFontConfigList(appkey string, io IO) ([]fontfind.FontVariantsLocation, bool) {
+// then reads the file and parses it into a list of font variants. If there is no
+// font list file and running fc-list is configured, the list is created by fc-list.
+//
+// fcList is the path of fc-list, or empty.
+func loadFontConfigList(appkey string, io IO, fcList string) ([]fontfind.FontVariantsLocation, bool) {
 	fclist, err := findFontList(appkey, io)
 	if err != nil {
-		return noFonts, false
+		cmd, runner, ok := fontConfigCommand(io, fcList)
+		if !ok {
+			return noFonts, false
+		}
+		if fclist, err = runFcList(cmd, runner); err != nil {
+			tracer().Infof("cannot create font list: %v", err)
+			return noFonts, false
+		}
 	}
+	var descriptors []fontfind.FontVariantsLocation
 	r := bytes.NewReader(fclist)
 	scanner := bufio.NewScanner(r)
-	ttc := 0
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
 		}
-		fields := strings.Split(line, ":")
-		if len(fields) < 3 {
-			continue
+		descriptors = append(descriptors, parseFontConfigLine(line)...)
+	}
+	if err = scanner.Err(); err != nil {
+		tracer().Errorf("encountered a problem during reading of fontconfig font list: %v", err)
+		return descriptors, false
+	}
+	return descriptors, true
+}
+
+// parseFontConfigLine parses a line of fc-list output of the form
+//
+//	/path/to/font.ttc: Family A,Family B:style=Light,Regular:index=1
+//
+// Family names and styles are comma-separated lists of alternate names of the same
+// face, e.g. the typographic and the legacy family name. A font variant location is
+// created for each family name, all of them with the variant of the first style name
+// we recognize.
+// An optional "index" field determines the face index within a font collection.
+//
+// If fc-list has been asked for numeric "weight", "slant" and "width" fields
+// (e.g., with format "%{file}: %{family}:style=%{style}:weight=%{weight}:slant=%{slant}:width=%{width}\n"),
+// variants are derived from these, e.g. "300italic". Otherwise variants are guessed
+// from the style names.
+func parseFontConfigLine(line string) []fontfind.FontVariantsLocation {
+	fields := strings.Split(line, ":")
+	if len(fields) < 3 {
+		return nil
+	}
+	fontpath := strings.TrimSpace(fields[0])
+	families := strings.Split(fields[1], ",")
+	var styles []string
+	faceIndex := 0
+	var fcfields fcNumericFields
+	for _, field := range fields[2:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch strings.ToLower(key) {
+		case "style":
+			styles = strings.Split(value, ",")
+		case "index":
+			if n, err := strconv.Atoi(value); err == nil {
+				faceIndex = n
+			}
+		case "weight":
+			fcfields.weight, fcfields.hasWeight = parseFcNumber(value)
+		case "slant":
+			fcfields.slant, fcfields.hasSlant = parseFcNumber(value)
+		case "width":
+			fcfields.width, fcfields.hasWidth = parseFcNumber(value)
+		}
+	}
+	var descriptors []fontfind.FontVariantsLocation
+	var variants []string
+	for _, style := range styles { // alternate names, some of them may be unknown to us
+		if variants = variantFromStyle(style); variants != nil {
+			break
 		}
-		fontpath := strings.TrimSpace(fields[0])
-		fontname := strings.TrimSpace(fields[1])
-		fontname = strings.TrimPrefix(fontname, ".")
-		fontvari := strings.ToLower(fields[2])
-		if strings.HasSuffix(fontpath, ".ttc") {
-			ttc++
+	}
+	if fcfields.present() {
+		variants = []string{fcfields.variant(variants)}
+	}
+	for _, family := range families {
+		family = strings.TrimPrefix(strings.TrimSpace(family), ".")
+		if family == "" {
 			continue
 		}
-		desc := fontfind.FontVariantsLocation{
-			Family: fontname,
-			Path:   fontpath,
+		descriptors = append(descriptors, fontfind.FontVariantsLocation{
+			Family:    family,
+			Variants:  variants,
+			Path:      fontpath,
+			FaceIndex: faceIndex,
+		})
+	}
+	return descriptors
+}
+
+// variantFromStyle maps a fontconfig style name to a variant name.
+func variantFromStyle(style string) []string {
+	style = strings.ToLower(style)
+	if strings.Contains(style, "regular") {
+		return []string{"regular"}
+	} else if strings.Contains(style, "text") {
+		return []string{"regular"}
+	} else if strings.Contains(style, "light") {
+		return []string{"light"}
+	} else if strings.Contains(style, "italic") {
+		return []string{"italic"}
+	} else if strings.Contains(style, "bold") {
+		return []string{"bold"}
+	} else if strings.Contains(style, "black") {
+		return []string{"bold"}
+	}
+	return nil
+}
+
+// fcNumericFields are the numeric weight, slant and width values of a fontconfig font.
+type fcNumericFields struct {
+	weight, slant, width          float64
+	hasWeight, hasSlant, hasWidth bool
+}
+
+func (fc fcNumericFields) present() bool {
+	return fc.hasWeight || fc.hasSlant || fc.hasWidth
+}
+
+// parseFcNumber parses a numeric fontconfig value. Ranges, as reported for variable
+// fonts ("[0 210]"), are not supported.
+func parseFcNumber(value string) (float64, bool) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return n, err == nil
+}
+
+// fontconfig weights and their CSS counterparts, see fontconfig.h
+var fcWeights = []struct {
+	fc  float64
+	css int
+}{
+	{0, 100},   // FC_WEIGHT_THIN
+	{40, 200},  // FC_WEIGHT_EXTRALIGHT
+	{50, 300},  // FC_WEIGHT_LIGHT
+	{80, 400},  // FC_WEIGHT_REGULAR (book = 75)
+	{100, 500}, // FC_WEIGHT_MEDIUM
+	{180, 600}, // FC_WEIGHT_DEMIBOLD
+	{200, 700}, // FC_WEIGHT_BOLD
+	{205, 800}, // FC_WEIGHT_EXTRABOLD
+	{210, 900}, // FC_WEIGHT_BLACK
+}
+
+// fontconfig widths, indexed by font.Stretch+4, see fontconfig.h
+var fcWidths = []float64{50, 63, 75, 87, 100, 113, 125, 150, 200}
+
+// variant creates a variant name in the style of Google Fonts ("300", "700italic")
+// from numeric fontconfig fields, followed by a width name for non-normal widths,
+// e.g. "700italic condensed". Values missing are taken from variants guessed from
+// the style name.
+func (fc fcNumericFields) variant(guessed []string) string {
+	guess := fontfind.ParseVariant("regular")
+	if len(guessed) > 0 {
+		guess = fontfind.ParseVariant(guessed[0])
+	}
+	css := (int(guess.Weight) + 4) * 100
+	if fc.hasWeight {
+		best := -1.0
+		for _, w := range fcWeights {
+			if d := math.Abs(w.fc - fc.weight); best < 0 || d < best {
+				best, css = d, w.css
+			}
 		}
-		if strings.Contains(fontvari, "regular") {
-			desc.Variants = []string{"regular"}
-		} else if strings.Contains(fontvari, "text") {
-			desc.Variants = []string{"regular"}
-		} else if strings.Contains(fontvari, "light") {
-			desc.Variants = []string{"light"}
-		} else if strings.Contains(fontvari, "italic") {
-			desc.Variants = []string{"italic"}
-		} else if strings.Contains(fontvari, "bold") {
-			desc.Variants = []string{"bold"}
-		} else if strings.Contains(fontvari, "black") {
-			desc.Variants = []string{"bold"}
+	}
+	style := guess.Style
+	if fc.hasSlant {
+		switch {
+		case fc.slant >= 110: // FC_SLANT_OBLIQUE
+			style = font.StyleOblique
+		case fc.slant >= 100: // FC_SLANT_ITALIC
+			style = font.StyleItalic
+		default:
+			style = font.StyleNormal
 		}
-		fontConfigDescriptors = append(fontConfigDescriptors, desc)
 	}
-	if err = scanner.Err(); err != nil {
-		err = fmt.Errorf("encountered a problem during reading of fontconfig font list: %s", fclist)
-		return fontConfigDescriptors, false
+	variant := variantName(css, style)
+	if fc.hasWidth {
+		width, best := "", -1.0
+		for i, w := range fcWidths {
+			if d := math.Abs(w - fc.width); best < 0 || d < best {
+				best, width = d, fontfind.WidthName(font.Stretch(i-4))
+			}
+		}
+		if width != "" {
+			variant += " " + width
+		}
+	}
+	return variant
+}
+
+// fontConfigList is a fontconfig list, loaded on first use.
+type fontConfigList struct {
+	active      bool                            // is fontconfig configured?
+	descriptors []fontfind.FontVariantsLocation // never modified
+	fromFile    bool                            // has the list been read from a font list file?
+	mtime       time.Time                       // modification time of the font list file
+}
+
+// fontConfig holds the fontconfig lists and fc-match answers of previous lookups.
+var fontConfig struct {
+	sync.Mutex
+	fcList  string                                     // path of fc-list binary, see SetFontConfigCommand
+	lists   map[string]*fontConfigList                 // by path of fc-list creating the list, see ensureFontConfigList
+	matches map[string][]fontfind.FontVariantsLocation // fc-match answers, see runFcMatch
+}
+
+// Refresh drops the fontconfig list loaded by previous lookups. The next lookup
+// will re-read the list, e.g., after the user installed new fonts.
+//
+// A font list file is re-read automatically if its modification time changes, so
+// calling Refresh is necessary only if the list is created by fc-list, or if fonts
+// are found by scanning font folders (see ScanFontDirs).
+func Refresh() {
+	invalidateFontDirs()
+	fontConfig.Lock()
+	defer fontConfig.Unlock()
+	tracer().Infof("dropping fontconfig list")
+	fontConfig.lists = nil
+	fontConfig.matches = nil
+}
+
+// ensureFontConfigList loads the fontconfig list, if not already done or if the
+// font list file has changed since it has been loaded. Without a font list file,
+// the list is created by fc-list, if fcList is not empty; lists are kept for every
+// fcList, so that locators with different settings do not replace each other's list.
+// It returns the list of font variants and true if fontconfig is active.
+func ensureFontConfigList(appkey string, io IO, fcList string) ([]fontfind.FontVariantsLocation, bool) {
+	mtime, fromFile := fontListModTime(appkey, io)
+	key := fcList
+	if fromFile { // the same list for every fcList
+		key = ""
 	}
-	if ttc > 0 {
-		tracer().Infof("skipping %d platform fonts: TTC not yet supported", ttc)
+	fontConfig.Lock()
+	defer fontConfig.Unlock()
+	list := fontConfig.lists[key]
+	if list != nil && (fromFile != list.fromFile || !mtime.Equal(list.mtime)) {
+		tracer().Infof("font list file has changed, reloading")
+		list = nil
+	}
+	if list == nil {
+		list = &fontConfigList{fromFile: fromFile, mtime: mtime}
+		list.descriptors, list.active = loadFontConfigList(appkey, io, fcList)
+		if fontConfig.lists == nil {
+			fontConfig.lists = make(map[string]*fontConfigList)
+		}
+		fontConfig.lists[key] = list
+		tracer().Infof("loaded fontconfig list")
 	}
-	return fontConfigDescriptors, true
+	return list.descriptors, list.active
 }
 
-var loadFontConfigListTask sync.Once
-var loadedFontConfigListOK bool
-var fontConfigDescriptors []fontfind.FontVariantsLocation
+// fontConfigVariants collects the variants of a font family from the fontconfig list.
+// family is compared case-insensitively.
+func fontConfigVariants(appkey string, io IO, family string, fcList string) []string {
+	descriptors, ok := ensureFontConfigList(appkey, io, fcList)
+	if !ok {
+		return nil
+	}
+	var variants []string
+	for _, desc := range descriptors {
+		if strings.EqualFold(desc.Family, family) {
+			variants = append(variants, desc.Variants...)
+		}
+	}
+	return variants
+}
 
 // findFontConfigFont searches for a locally installed font variant using the fontconfig
 // system (https://www.freedesktop.org/wiki/Software/fontconfig/).
 // However, we need some preparation from the user to de-couple from the
-// fontconfig library.
-func findFontConfigFont(appkey string, io IO, pattern string, style font.Style, weight font.Weight) (
-	desc fontfind.FontVariantsLocation, variant string) {
+// fontconfig library. active is true if fontconfig is active, i.e. if a font not
+// found here should not be searched for elsewhere on the system.
+func findFontConfigFont(appkey string, io IO, fdesc fontfind.Descriptor, fcList string) (
+	desc fontfind.FontVariantsLocation, variant string, confidence fontfind.MatchConfidence, active bool) {
 	//
-	loadFontConfigListTask.Do(func() {
-		_, loadedFontConfigListOK = loadFontConfigList(appkey, io)
-		tracer().Infof("loaded fontconfig list")
-	})
-	if !loadedFontConfigListOK {
+	descriptors, active := ensureFontConfigList(appkey, io, fcList)
+	if !active {
 		return
 	}
-	var confidence fontfind.MatchConfidence
-	desc, variant, confidence = fontfind.ClosestMatch(fontConfigDescriptors, pattern, style, weight)
+	minConfidence := fdesc.MinConfidence
+	desc, variant, confidence = fdesc.ClosestMatch(descriptors)
 	tracer().Debugf("closest fontconfig match confidence for %s|%s= %d", desc.Family, variant, confidence)
-	if confidence > fontfind.LowConfidence {
+	if fontfind.AcceptMatch(confidence, minConfidence) {
 		return
 	}
-	return fontfind.FontVariantsLocation{}, ""
+	if cmd, runner, ok := fontConfigCommand(io, fcList); ok {
+		matches := runFcMatch(cmd, runner, fdesc.Pattern, fdesc.Style, fdesc.Weight, fdesc.Width)
+		desc, variant, confidence = fdesc.ClosestMatch(matches)
+		tracer().Debugf("fc-match confidence for %s|%s= %d", desc.Family, variant, confidence)
+		if fontfind.AcceptMatch(confidence, minConfidence) {
+			return
+		}
+	}
+	return fontfind.FontVariantsLocation{}, "", fontfind.NoConfidence, true
 }
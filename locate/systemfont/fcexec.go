@@ -0,0 +1,174 @@
+package systemfont
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
+	"github.com/npillmayer/schuko"
+	"golang.org/x/image/font"
+)
+
+// Instead of reading a pre-generated font list, systemfont may run fontconfig's
+// fc-list command to create the list on demand, and fc-match to find a font for a
+// pattern. This is enabled by configuration key "fontconfig-fc-list", holding the
+// path of the fc-list binary. fc-match is expected in the same folder.
+
+// fcFormat is the output format requested from fc-list and fc-match, see parseFontConfigLine.
+const fcFormat = "%{file}: %{family}:style=%{style}:weight=%{weight}:slant=%{slant}:width=%{width}:index=%{index}\n"
+
+// fcTimeout limits the runtime of fontconfig commands.
+const fcTimeout = 10 * time.Second
+
+// CommandRunner is an optional interface of IO. If an IO implements it,
+// fontconfig commands are run through it. The default IO runs commands with
+// package os/exec.
+type CommandRunner interface {
+	RunCommand(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+func (s *systemIO) RunCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", filepath.Base(name), err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// FindWithConfig creates a FontLocator that resolves fonts from local system sources,
+// like Find. It reads the app-key from conf, and if conf contains key
+// "fontconfig-fc-list", fc-list and fc-match are run on demand, if no font list
+// file is present. Environments without fontconfig will skip this silently.
+//
+// Key "extra-font-dirs" may hold a list of application-specific font folders,
+// separated by os.PathListSeparator (':' on Unix). Key "retain-system-fonts" tells
+// if the locator keeps the data of fonts found in memory. The settings are held by
+// the locator and do not affect other locators. For keys not set, the package-wide
+// settings apply, see SetFontConfigCommand, SetExtraFontDirs and SetRetainFontData.
+func FindWithConfig(conf schuko.Configuration, io IO) locate.FontLocator {
+	own := lookupSettings{
+		fcList:    conf.GetString("fontconfig-fc-list"),
+		extraDirs: filepath.SplitList(conf.GetString("extra-font-dirs")),
+		retain:    conf.GetBool("retain-system-fonts"),
+	}
+	fcSet, dirsSet := conf.IsSet("fontconfig-fc-list"), conf.IsSet("extra-font-dirs")
+	retainSet := conf.IsSet("retain-system-fonts")
+	return find(conf.GetString("app-key"), io, func() lookupSettings {
+		settings := packageSettings()
+		if fcSet {
+			settings.fcList = own.fcList
+		}
+		if dirsSet {
+			settings.extraDirs = own.extraDirs
+		}
+		if retainSet {
+			settings.retain = own.retain
+		}
+		return settings
+	})
+}
+
+// SetFontConfigCommand sets the path of the fc-list binary to run for creating a
+// font list. An empty path disables running fontconfig commands. Locators of
+// FindWithConfig with key "fontconfig-fc-list" set ignore it.
+func SetFontConfigCommand(fcList string) {
+	fontConfig.Lock()
+	defer fontConfig.Unlock()
+	fontConfig.fcList = fcList
+}
+
+// fontConfigCommandPath returns the path of fc-list set with SetFontConfigCommand.
+func fontConfigCommandPath() string {
+	fontConfig.Lock()
+	defer fontConfig.Unlock()
+	return fontConfig.fcList
+}
+
+// fontConfigCommand returns fcList and the runner of fontconfig commands, if
+// running them is possible.
+func fontConfigCommand(io IO, fcList string) (string, CommandRunner, bool) {
+	runner, ok := io.(CommandRunner)
+	if !ok || fcList == "" {
+		return "", nil, false
+	}
+	return fcList, runner, true
+}
+
+// runFcList creates a font list by running fc-list.
+func runFcList(fcList string, runner CommandRunner) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fcTimeout)
+	defer cancel()
+	tracer().Infof("running %s to create font list", fcList)
+	return runner.RunCommand(ctx, fcList, "--format", fcFormat)
+}
+
+// maxFcMatches is the number of fc-match answers remembered.
+const maxFcMatches = 1024
+
+// runFcMatch asks fc-match for the best font for pattern, style, weight and width.
+// fc-match always answers with some font, so the result has to be checked by the caller.
+// Answers are remembered until Refresh is called, so that repeated lookups of a font
+// missing from the fontconfig list do not run fc-match each time.
+func runFcMatch(fcList string, runner CommandRunner, pattern string, style font.Style,
+	weight font.Weight, width font.Stretch) []fontfind.FontVariantsLocation {
+	//
+	fcMatch := filepath.Join(filepath.Dir(fcList), "fc-match")
+	p := fcPattern(pattern, style, weight, width)
+	key := fcMatch + "|" + p
+	fontConfig.Lock()
+	matches, ok := fontConfig.matches[key]
+	fontConfig.Unlock()
+	if ok {
+		return matches
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fcTimeout)
+	defer cancel()
+	out, err := runner.RunCommand(ctx, fcMatch, "--format", fcFormat, p)
+	if err != nil { // not remembered, the failure may be transient
+		tracer().Debugf("fc-match failed: %v", err)
+		return nil
+	}
+	matches = parseFontConfigLine(strings.TrimSpace(string(out)))
+	fontConfig.Lock()
+	defer fontConfig.Unlock()
+	if fontConfig.matches == nil || len(fontConfig.matches) >= maxFcMatches {
+		fontConfig.matches = make(map[string][]fontfind.FontVariantsLocation) // cheap to re-run
+	}
+	fontConfig.matches[key] = matches
+	return matches
+}
+
+// fcPattern creates a fontconfig pattern, e.g. "Noto Sans:weight=200:slant=100".
+func fcPattern(pattern string, style font.Style, weight font.Weight, width font.Stretch) string {
+	css := (int(weight) + 4) * 100
+	fcWeight := fcWeights[0].fc
+	for _, w := range fcWeights {
+		if w.css == css {
+			fcWeight = w.fc
+		}
+	}
+	p := fmt.Sprintf("%s:weight=%s", strings.ReplaceAll(pattern, ":", `\:`),
+		strconv.FormatFloat(fcWeight, 'f', -1, 64))
+	switch style {
+	case font.StyleItalic:
+		p += ":slant=100"
+	case font.StyleOblique:
+		p += ":slant=110"
+	}
+	if fontfind.WidthName(width) != "" {
+		p += ":width=" + strconv.FormatFloat(fcWidths[int(width)+4], 'f', -1, 64)
+	}
+	return p
+}
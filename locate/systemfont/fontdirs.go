@@ -0,0 +1,197 @@
+package systemfont
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+)
+
+// Without fontconfig, systemfont scans the platform-standard font folders and reads
+// the metadata of every font file found. The result is cached until the font list is
+// refreshed (see Refresh).
+
+var fontDirs struct {
+	sync.Mutex
+	scanned    bool
+	fonts      []fontfind.FontVariantsLocation            // replaced on re-scan, never modified
+	extra      []string                                   // extra font folders, see SetExtraFontDirs
+	extraFonts map[string][]fontfind.FontVariantsLocation // fonts of extra folders, see extraDirFonts
+}
+
+// SetExtraFontDirs sets application-specific font folders, e.g. for fonts bundled
+// with an application. Extra folders are searched before fontconfig and the platform
+// font folders. An empty list disables extra folders. Locators of FindWithConfig
+// with key "extra-font-dirs" set ignore it.
+func SetExtraFontDirs(dirs []string) {
+	fontDirs.Lock()
+	defer fontDirs.Unlock()
+	fontDirs.extra = append([]string(nil), dirs...)
+	fontDirs.extraFonts = nil
+}
+
+// extraFontDirs returns the folders set with SetExtraFontDirs.
+func extraFontDirs() []string {
+	fontDirs.Lock()
+	defer fontDirs.Unlock()
+	return fontDirs.extra
+}
+
+// extraDirFonts returns the fonts of extra font folders dirs, scanning them once.
+func extraDirFonts(io IO, dirs []string) []fontfind.FontVariantsLocation {
+	if len(dirs) == 0 {
+		return nil
+	}
+	key := strings.Join(dirs, string(filepath.ListSeparator))
+	fontDirs.Lock()
+	defer fontDirs.Unlock()
+	fonts, ok := fontDirs.extraFonts[key]
+	if !ok {
+		fonts = scanFontDirs(io, dirs)
+		if fontDirs.extraFonts == nil {
+			fontDirs.extraFonts = make(map[string][]fontfind.FontVariantsLocation)
+		}
+		fontDirs.extraFonts[key] = fonts
+		tracer().Infof("scanned extra font folders, found %d fonts", len(fonts))
+	}
+	return fonts
+}
+
+// ScanFontDirs walks the platform-standard font folders and returns a location for
+// every face of every font file found, suitable for fontfind.ClosestMatch.
+// Family and variant are read from the font binaries, not guessed from file names.
+//
+// Font folders are
+//
+//	Linux:   ~/.local/share/fonts, ~/.fonts, /usr/local/share/fonts, /usr/share/fonts
+//	macOS:   ~/Library/Fonts, /Library/Fonts, /System/Library/Fonts
+//	Windows: %WINDIR%\Fonts, %LOCALAPPDATA%\Microsoft\Windows\Fonts
+//
+// On Linux, XDG_DATA_HOME and XDG_DATA_DIRS are respected. The folders are scanned
+// once; subsequent calls return the cached result.
+func ScanFontDirs(io IO) []fontfind.FontVariantsLocation {
+	if io == nil {
+		io = &systemIO{}
+	}
+	fontDirs.Lock()
+	defer fontDirs.Unlock()
+	if !fontDirs.scanned {
+		fontDirs.fonts = scanFontDirs(io, fontDirectories())
+		fontDirs.scanned = true
+		tracer().Infof("scanned font folders, found %d fonts", len(fontDirs.fonts))
+	}
+	return fontDirs.fonts
+}
+
+// invalidateFontDirs drops the result of previous font folder scans.
+func invalidateFontDirs() {
+	fontDirs.Lock()
+	defer fontDirs.Unlock()
+	fontDirs.scanned = false
+	fontDirs.fonts = nil
+	fontDirs.extraFonts = nil
+}
+
+// fontDirectories returns the font folders of the platform.
+func fontDirectories() []string {
+	home, _ := os.UserHomeDir()
+	var dirs []string
+	switch runtime.GOOS {
+	case "windows":
+		if windir := os.Getenv("WINDIR"); windir != "" {
+			dirs = append(dirs, filepath.Join(windir, "Fonts"))
+		}
+		if appdata := os.Getenv("LOCALAPPDATA"); appdata != "" {
+			dirs = append(dirs, filepath.Join(appdata, "Microsoft", "Windows", "Fonts"))
+		}
+	case "darwin":
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, "Library", "Fonts"))
+		}
+		dirs = append(dirs, "/Library/Fonts", "/System/Library/Fonts")
+	default:
+		if data := os.Getenv("XDG_DATA_HOME"); data != "" {
+			dirs = append(dirs, filepath.Join(data, "fonts"))
+		} else if home != "" {
+			dirs = append(dirs, filepath.Join(home, ".local", "share", "fonts"))
+		}
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, ".fonts"))
+		}
+		if data := os.Getenv("XDG_DATA_DIRS"); data != "" {
+			for _, d := range filepath.SplitList(data) {
+				dirs = append(dirs, filepath.Join(d, "fonts"))
+			}
+		} else {
+			dirs = append(dirs, "/usr/local/share/fonts", "/usr/share/fonts")
+		}
+	}
+	return dirs
+}
+
+// scanFontDirs walks dirs and reads the metadata of the font files found.
+// Folders which do not exist or cannot be read are skipped.
+func scanFontDirs(io IO, dirs []string) []fontfind.FontVariantsLocation {
+	var fonts []fontfind.FontVariantsLocation
+	for _, dir := range dirs {
+		fsys := io.DirFS(dir)
+		fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // skip unreadable entries
+			}
+			if d.IsDir() || !isFontFile(path) {
+				return nil
+			}
+			fonts = append(fonts, scanFontFile(fsys, dir, path)...)
+			return nil
+		})
+	}
+	return fonts
+}
+
+// scanFontFile reads the metadata of all faces of a font file. The locations
+// returned carry the path of the file within dir.
+func scanFontFile(fsys fs.FS, dir string, path string) []fontfind.FontVariantsLocation {
+	var locs []fontfind.FontVariantsLocation
+	for index := 0; ; index++ {
+		f := fontfind.ScalableFont{Name: filepath.Base(path), FaceIndex: index}
+		f.SetFS(fsys, path)
+		md, err := fontfind.ReadMetadata(f)
+		if err != nil {
+			if index == 0 {
+				tracer().Debugf("cannot read font %s: %v", path, err)
+			}
+			break
+		}
+		if md.Family != "" {
+			variant := variantName((int(md.Weight)+4)*100, md.Style)
+			if w := fontfind.WidthName(md.Width); w != "" {
+				variant += " " + w
+			}
+			locs = append(locs, fontfind.FontVariantsLocation{
+				Family:    md.Family,
+				Variants:  []string{variant},
+				Path:      filepath.Join(dir, filepath.FromSlash(path)),
+				FaceIndex: index,
+			})
+		}
+		if !isCollection(path) {
+			break
+		}
+	}
+	return locs
+}
+
+// isFontFile returns true if fontpath has the extension of a font file
+// package sfnt is able to parse.
+func isFontFile(fontpath string) bool {
+	switch strings.ToLower(filepath.Ext(fontpath)) {
+	case ".ttf", ".otf", ".ttc", ".otc":
+		return true
+	}
+	return false
+}
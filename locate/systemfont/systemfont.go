@@ -2,16 +2,21 @@ package systemfont
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/flopp/go-findfont"
 	"github.com/npillmayer/fontfind"
 	"github.com/npillmayer/fontfind/locate"
 	"github.com/npillmayer/schuko/tracing"
 	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
 )
 
 // tracer writes to trace with key 'tyse.font'
@@ -28,14 +33,34 @@ var USE_SYSTEM_IO IO = nil
 // appkey identifies the caller's config area used for fontconfig list lookup.
 // io customizes host I/O and may be nil.
 func Find(appkey string, io IO) locate.FontLocator {
+	return find(appkey, io, packageSettings)
+}
+
+// find creates the locator of Find. settings returns the settings of a lookup.
+func find(appkey string, io IO, settings func() lookupSettings) locate.FontLocator {
 	if io == nil {
 		io = &systemIO{}
 	}
 	return func(descr fontfind.Descriptor) (fontfind.ScalableFont, error) {
-		pattern := descr.Pattern
-		style := descr.Style
-		weight := descr.Weight
-		return FindLocalFont(appkey, io, pattern, style, weight)
+		return findLocalFont(appkey, io, descr, settings())
+	}
+}
+
+// lookupSettings are the settings of a font lookup. Locators of FindWithConfig
+// hold settings of their own, other lookups use the package-wide settings.
+type lookupSettings struct {
+	fcList    string   // path of fc-list, or empty; see SetFontConfigCommand
+	extraDirs []string // see SetExtraFontDirs
+	retain    bool     // keep font data in memory? see SetRetainFontData
+}
+
+// packageSettings returns the package-wide settings, see SetFontConfigCommand,
+// SetExtraFontDirs and SetRetainFontData.
+func packageSettings() lookupSettings {
+	return lookupSettings{
+		fcList:    fontConfigCommandPath(),
+		extraDirs: extraFontDirs(),
+		retain:    retainFontData(),
 	}
 }
 
@@ -66,46 +91,248 @@ func (s *systemIO) ReadAll(r io.Reader) ([]byte, error) {
 // system (https://www.freedesktop.org/wiki/Software/fontconfig/).
 //
 // If fontconfig is not configured, FindLocalFont will fall back to scanning
-// system font folders (OS dependent).
+// system font folders (OS dependent). Extra font folders (see SetExtraFontDirs)
+// are searched first in any case.
 func FindLocalFont(appkey string, io IO, pattern string, style font.Style, weight font.Weight) (
+	fontfind.ScalableFont, error) {
+	//
+	desc := fontfind.Descriptor{Pattern: pattern, Style: style, Weight: weight}
+	return findLocalFont(appkey, io, desc, packageSettings())
+}
+
+// retention tells whether the data of fonts found is kept in memory, see SetRetainFontData.
+var retention struct {
+	sync.Mutex
+	enabled bool
+}
+
+// SetRetainFontData sets whether the font data of system fonts is read into memory
+// when a font is located (see fontfind.ScalableFont.Retain). Subsequent reads of the
+// font data do not touch the disk then, which pays off for servers resolving fonts
+// repeatedly. Call Close on a font to drop its data. Retention is off by default,
+// and locators of FindWithConfig with key "retain-system-fonts" set ignore it.
+//
+// A resolver pipeline does not keep retained data in its registry: fonts served
+// from the registry read their data from the file-system again.
+func SetRetainFontData(retain bool) {
+	retention.Lock()
+	defer retention.Unlock()
+	retention.enabled = retain
+}
+
+func retainFontData() bool {
+	retention.Lock()
+	defer retention.Unlock()
+	return retention.enabled
+}
+
+// findLocalFont is FindLocalFont for a descriptor and settings. Matches need a
+// confidence of at least desc.MinConfidence, see fontfind.AcceptMatch.
+func findLocalFont(appkey string, io IO, desc fontfind.Descriptor, settings lookupSettings) (
+	fontfind.ScalableFont, error) {
+	//
+	f, err := lookupLocalFont(appkey, io, desc, settings)
+	if err == nil && settings.retain {
+		if err = f.Retain(); err != nil {
+			return fontfind.NullFont, fmt.Errorf("cannot read font %s: %w", f.File(), err)
+		}
+	}
+	return f, err
+}
+
+// lookupLocalFont searches for a locally installed font, see findLocalFont.
+func lookupLocalFont(appkey string, io IO, desc fontfind.Descriptor, settings lookupSettings) (
 	fontfind.ScalableFont, error) {
 	//
 	if io == nil {
 		io = &systemIO{}
 	}
-	variants, _ := findFontConfigFont(appkey, io, pattern, style, weight)
+	pattern, style, weight, width := desc.Pattern, desc.Style, desc.Weight, desc.Width
+	minConfidence := desc.MinConfidence
+	extra, variant, confidence := desc.ClosestMatch(extraDirFonts(io, settings.extraDirs))
+	if fontfind.AcceptMatch(confidence, minConfidence) {
+		tracer().Debugf("%s found in extra font folder: %s|%s", pattern, extra.Path, variant)
+		sfnt := fontfind.ScalableFont{
+			Name:       pattern,
+			Weight:     weight,
+			Style:      style,
+			FaceIndex:  extra.FaceIndex,
+			Source:     fontfind.SourceSystem,
+			Confidence: confidence,
+		}
+		sfnt.SetFile(extra.Path)
+		return sfnt, nil
+	}
+	variants, _, confidence, active := findFontConfigFont(appkey, io, desc, settings.fcList)
 	if variants.Family != "" {
 		if fsys, path, err := wrapDirFS(variants.Path); err == nil {
 			sfnt := fontfind.ScalableFont{
-				Name:   pattern,
-				Weight: weight,
-				Style:  style,
+				Name:       pattern,
+				Weight:     weight,
+				Style:      style,
+				FaceIndex:  variants.FaceIndex,
+				Source:     fontfind.SourceSystem,
+				Confidence: confidence,
 			}
-			sfnt.SetFS(fsys, path)
+			if isCollection(path) && variants.FaceIndex == 0 {
+				sfnt.FaceIndex = collectionFaceIndex(fsys, path, variants.Family)
+			}
+			sfnt.SetFile(variants.Path)
 			return sfnt, nil
 		}
 		return fontfind.NullFont, errors.New("path error with fontconfig file path")
 	}
-	if loadedFontConfigListOK { // fontconfig is active, but didn't find a font
+	if active { // fontconfig is active, but didn't find a font
 		// therefore don't do a file system scan
-		return fontfind.NullFont, errors.New("no such font")
+		return fontfind.NullFont, fmt.Errorf("%w: no such font", locate.ErrFontNotFound)
 	}
 	// otherwise fontconfig is not active => scan file system
-	fpath, err := findfont.Find(pattern) // go-findfont lib does not accept style & weight
-	if err == nil && fpath != "" {
+	scanned := ScanFontDirs(io)
+	loc, variant, confidence := desc.ClosestMatch(scanned)
+	tracer().Debugf("closest font folder match confidence for %s|%s= %d", loc.Family, variant, confidence)
+	fpath, ok := loc.Path, fontfind.AcceptMatch(confidence, minConfidence)
+	if !ok { // try to match file names
+		loc.FaceIndex, confidence = 0, fontfind.NoConfidence
+		fpath, ok = findFontFile(locationPaths(scanned), pattern, style, weight, width, minConfidence)
+	}
+	if !ok && minConfidence == fontfind.NoConfidence {
+		// no good match => take the first hit of go-findfont, ignoring style & weight
+		if p, err := findfont.Find(pattern); err == nil && p != "" {
+			fpath, ok, confidence = p, true, fontfind.NoConfidence
+		}
+	}
+	if ok {
 		tracer().Debugf("%s is a system font: %s", pattern, fpath)
-		if fsys, path, err := wrapDirFS(fpath); err == nil {
-			sfnt := fontfind.ScalableFont{
-				Name:   pattern,
-				Weight: weight,
-				Style:  style,
+		sfnt := fontfind.ScalableFont{
+			Name:       pattern,
+			Weight:     weight,
+			Style:      style,
+			FaceIndex:  loc.FaceIndex,
+			Source:     fontfind.SourceSystem,
+			Confidence: confidence,
+		}
+		sfnt.SetFile(fpath)
+		return sfnt, nil
+	}
+	return fontfind.NullFont, fmt.Errorf("%w: no such font", locate.ErrFontNotFound)
+}
+
+// findFontFile selects the font file from paths which best matches pattern, style,
+// weight and width. Candidates are font files with a base name matching pattern,
+// ignoring case, diacritics, spaces and punctuation for plain names ("DejaVu Sans"
+// matches "DejaVuSans-Bold.ttf"), and ignoring case only for regular expressions
+// ("^dejavu[-_]?sans-bold$"). Style and
+// weight of a candidate are guessed from its file name or read from the font binary.
+// ok is false if no candidate matches with at least minConfidence.
+func findFontFile(paths []string, pattern string, style font.Style, weight font.Weight,
+	width font.Stretch, minConfidence fontfind.MatchConfidence) (fpath string, ok bool) {
+	//
+	compact := func(name string) string { // "Déjà Vu-Sans" → "dejavusans"
+		return strings.ReplaceAll(fontfind.NormalizeFamily(name), " ", "")
+	}
+	literal := regexp.QuoteMeta(pattern) == pattern
+	p := "(?i)" + pattern // a regular expression is matched against file names as given
+	if literal {
+		p = compact(pattern)
+	}
+	r, err := regexp.Compile(p)
+	if err != nil {
+		return "", false
+	}
+	var candidates []fontfind.FontVariantsLocation
+	for _, p := range paths {
+		base := filepath.Base(p)
+		family := strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base)))
+		if literal {
+			family = compact(family)
+		}
+		if !r.MatchString(family) {
+			continue
+		}
+		f := fontfind.ScalableFont{Name: base}
+		f.SetFile(p)
+		s, w := fontfind.GuessFontStyleAndWeight(f)
+		variant := variantName((int(w)+4)*100, s)
+		if w := fontfind.WidthName(fontfind.GuessWidth(family)); w != "" {
+			variant += " " + w
+		}
+		candidates = append(candidates, fontfind.FontVariantsLocation{
+			Family:   family,
+			Variants: []string{variant},
+			Path:     p,
+		})
+	}
+	match, variant, confidence := fontfind.ClosestMatchWithWidth(candidates, r.String(), style, weight, width)
+	tracer().Debugf("closest font file match confidence for %s|%s= %d", match.Path, variant, confidence)
+	if !fontfind.AcceptMatch(confidence, minConfidence) {
+		return "", false
+	}
+	return match.Path, true
+}
+
+// locationPaths returns the distinct file paths of a list of font locations.
+func locationPaths(locs []fontfind.FontVariantsLocation) []string {
+	paths := make([]string, 0, len(locs))
+	seen := make(map[string]bool, len(locs))
+	for _, loc := range locs {
+		if !seen[loc.Path] {
+			seen[loc.Path] = true
+			paths = append(paths, loc.Path)
+		}
+	}
+	return paths
+}
+
+// FindLocalFontVariants returns the variants of a locally installed font family,
+// as listed by fontconfig. family must be a family name, not a pattern; it is
+// compared case-insensitively.
+//
+// Variants can only be listed if fontconfig is configured (see FindLocalFont).
+func FindLocalFontVariants(appkey string, io IO, family string) ([]fontfind.Variant, error) {
+	if io == nil {
+		io = &systemIO{}
+	}
+	names := fontConfigVariants(appkey, io, family, fontConfigCommandPath())
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: no variants found for font family %s", locate.ErrFontNotFound, family)
+	}
+	variants := make([]fontfind.Variant, 0, len(names))
+	for _, n := range names {
+		variants = append(variants, fontfind.ParseVariant(n))
+	}
+	return variants, nil
+}
+
+// isCollection returns true if fontpath denotes a font collection file.
+func isCollection(fontpath string) bool {
+	ext := strings.ToLower(filepath.Ext(fontpath))
+	return ext == ".ttc" || ext == ".otc"
+}
+
+// collectionFaceIndex finds the face of a font collection with a given family name,
+// as fc-list does not report face indices by default. Faces are parsed with
+// ScalableFont.Sfnt, so they are shared with later users of the font by the cache
+// of parsed fonts. If the collection cannot be read or contains no face of family,
+// 0 is returned.
+func collectionFaceIndex(fsys fs.FS, path string, family string) int {
+	var buf sfnt.Buffer
+	for index := 0; ; index++ {
+		f := fontfind.ScalableFont{Name: filepath.Base(path), FaceIndex: index}
+		f.SetFS(fsys, path)
+		sf, err := f.Sfnt()
+		if err != nil {
+			if index == 0 {
+				tracer().Debugf("cannot read font collection %s: %v", path, err)
+			}
+			return 0
+		}
+		for _, id := range []sfnt.NameID{sfnt.NameIDTypographicFamily, sfnt.NameIDFamily, sfnt.NameIDFull} {
+			if name, err := sf.Name(&buf, id); err == nil && strings.EqualFold(name, family) {
+				tracer().Debugf("font %s is face %d of collection %s", family, index, path)
+				return index
 			}
-			sfnt.SetFS(fsys, path)
-			return sfnt, nil
 		}
-		return fontfind.NullFont, errors.New("path error with system font file path")
 	}
-	return fontfind.NullFont, errors.New("no such font")
 }
 
 func wrapDirFS(fontpath string) (fs.FS, string, error) {
@@ -0,0 +1,546 @@
+package systemfont
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/internal/fonttest"
+	"github.com/npillmayer/schuko/schukonf/testconfig"
+	"github.com/npillmayer/schuko/tracing/gotestingadapter"
+	"golang.org/x/image/font"
+)
+
+const packagedDir = "../fallbackfont/packaged/"
+
+func packagedFonts(names ...string) []fontfind.FontVariantsLocation {
+	locs := make([]fontfind.FontVariantsLocation, 0, len(names))
+	for _, n := range names {
+		locs = append(locs, fontfind.FontVariantsLocation{
+			Family: n,
+			Path:   packagedDir + n,
+		})
+	}
+	return locs
+}
+
+func TestBuildCatalog(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	fonts := packagedFonts("Go-Regular.otf", "Go-Mono.otf", "does-not-exist.otf")
+	catalog, err := BuildCatalog(context.Background(), fonts, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Errors) != 1 {
+		t.Errorf("expected 1 parse error, got %d", len(catalog.Errors))
+	}
+	if len(catalog.Entries) != 2 {
+		t.Fatalf("expected 2 catalog entries, got %d", len(catalog.Entries))
+	}
+	regular, mono := catalog.Entries[0], catalog.Entries[1]
+	if regular.FontFamily != "Go" || regular.Monospace {
+		t.Errorf("unexpected metadata for Go-Regular: %q, monospace=%v", regular.FontFamily, regular.Monospace)
+	}
+	if mono.FontFamily != "Go Mono" || !mono.Monospace {
+		t.Errorf("unexpected metadata for Go-Mono: %q, monospace=%v", mono.FontFamily, mono.Monospace)
+	}
+	if len(regular.Scripts) == 0 || regular.Scripts[0] != "latin" {
+		t.Errorf("expected Go-Regular to cover latin, has %v", regular.Scripts)
+	}
+	if regular.Color || mono.Color {
+		t.Errorf("expected Go fonts not to be color fonts")
+	}
+}
+
+func TestBuildCatalogCollection(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	var fonts [][]byte
+	for _, name := range []string{"Go-Regular.otf", "Go-Mono.otf"} {
+		data, err := os.ReadFile(packagedDir + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fonts = append(fonts, data)
+	}
+	ttc := filepath.Join(t.TempDir(), "Go.ttc")
+	if err := os.WriteFile(ttc, fonttest.MakeCollection(fonts...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	locs := []fontfind.FontVariantsLocation{ // one location per face, as from ScanFontDirs
+		{Family: "Go", Path: ttc},
+		{Family: "Go Mono", Path: ttc, FaceIndex: 1},
+	}
+	catalog, err := BuildCatalog(context.Background(), locs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Errors) != 0 || len(catalog.Entries) != 2 {
+		t.Fatalf("expected 2 catalog entries for collection, have %d (errors %v)", len(catalog.Entries), catalog.Errors)
+	}
+	for i, family := range []string{"Go", "Go Mono"} {
+		entry := catalog.Entries[i]
+		if entry.FontFamily != family || entry.FaceIndex != i || entry.Monospace != (i == 1) || entry.Color {
+			t.Errorf("unexpected catalog entry for face %d: %+v", i, entry)
+		}
+	}
+}
+
+func TestBuildCatalogCancelled(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	catalog, err := BuildCatalog(ctx, packagedFonts("Go-Regular.otf"), 1)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(catalog.Entries) != 0 {
+		t.Errorf("expected empty catalog for cancelled build")
+	}
+}
+
+func BenchmarkBuildCatalog(b *testing.B) {
+	fonts := packagedFonts("Go-Regular.otf", "Go-Bold.otf", "Go-Italic.otf",
+		"Go-Bold-Italic.otf", "Go-Mono.otf", "GentiumPlus-R.ttf")
+	for i := 0; i < b.N; i++ {
+		if _, err := BuildCatalog(context.Background(), fonts, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var fclist = `
+/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf: DejaVu Sans:style=Book,Regular
+/usr/share/fonts/truetype/dejavu/DejaVuSans-Bold.ttf: DejaVu Sans:style=Bold
+/usr/share/fonts/truetype/dejavu/DejaVuSans-Oblique.ttf: DejaVu Sans:style=Oblique,Italic
+/usr/share/fonts/truetype/dejavu/DejaVuSans-ExtraLight.ttf: DejaVu Sans:style=ExtraLight
+/usr/share/fonts/truetype/dejavu/DejaVuSansCondensed.ttf: DejaVu Sans Condensed:style=Condensed,Regular
+/usr/share/fonts/truetype/dejavu/DejaVuSerif.ttf: DejaVu Serif:style=Book,Regular
+`
+
+type testIO struct {
+	fsys fstest.MapFS
+}
+
+func newTestIO() *testIO {
+	return &testIO{
+		fsys: fstest.MapFS{
+			"fontconfig/fontlist.txt": &fstest.MapFile{Data: []byte(fclist)},
+		},
+	}
+}
+
+func (s *testIO) UserConfigDir() (string, error) {
+	return "home", nil
+}
+
+func (s *testIO) DirFS(path string) fs.FS {
+	return s.fsys
+}
+
+func (s *testIO) ReadAll(r io.Reader) ([]byte, error) {
+	return io.ReadAll(r)
+}
+
+func TestFindLocalFontVariants(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	variants, err := FindLocalFontVariants("tyse-test", newTestIO(), "dejavu sans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fontfind.Variant{
+		{Name: "regular", Style: font.StyleNormal, Weight: font.WeightNormal},
+		{Name: "bold", Style: font.StyleNormal, Weight: font.WeightBold},
+		{Name: "italic", Style: font.StyleItalic, Weight: font.WeightNormal},
+		{Name: "light", Style: font.StyleNormal, Weight: font.WeightLight},
+	}
+	if len(variants) != len(expected) {
+		t.Fatalf("expected %d variants, got %v", len(expected), variants)
+	}
+	for i, v := range variants {
+		if v != expected[i] {
+			t.Errorf("expected variant %v, got %v", expected[i], v)
+		}
+	}
+}
+
+func TestRefreshRereadsFontList(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	Refresh()
+	defer Refresh()
+	io := newTestIO()
+	_, err := FindLocalFont("tyse-test", io, "Noto Sans Cham", font.StyleNormal, font.WeightNormal)
+	if err == nil {
+		t.Fatal("expected Noto Sans Cham not to be found before refresh")
+	}
+	io.fsys["fontconfig/fontlist.txt"] = &fstest.MapFile{Data: []byte(fclist +
+		"/usr/share/fonts/NotoSansCham-Regular.ttf: Noto Sans Cham:style=Regular\n")}
+	Refresh()
+	f, err := FindLocalFont("tyse-test", io, "Noto Sans Cham", font.StyleNormal, font.WeightNormal)
+	if err != nil {
+		t.Fatalf("expected Noto Sans Cham to be found after refresh, got %v", err)
+	}
+	if f.Path() != "NotoSansCham-Regular.ttf" {
+		t.Errorf("unexpected font path %q", f.Path())
+	}
+}
+
+func TestFontListReloadedOnModification(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	Refresh()
+	defer Refresh()
+	io := newTestIO()
+	io.fsys["fontconfig/fontlist.txt"].ModTime = time.Unix(1000, 0)
+	if _, err := FindLocalFont("tyse-test", io, "Noto Sans Cham", font.StyleNormal, font.WeightNormal); err == nil {
+		t.Fatal("expected Noto Sans Cham not to be found in initial font list")
+	}
+	io.fsys["fontconfig/fontlist.txt"] = &fstest.MapFile{
+		Data:    []byte(fclist + "/usr/share/fonts/NotoSansCham-Regular.ttf: Noto Sans Cham:style=Regular\n"),
+		ModTime: time.Unix(2000, 0),
+	}
+	if _, err := FindLocalFont("tyse-test", io, "Noto Sans Cham", font.StyleNormal, font.WeightNormal); err != nil {
+		t.Errorf("expected modified font list to be re-read, got %v", err)
+	}
+}
+
+func TestFontListConcurrentAccess(t *testing.T) {
+	// no test tracer: gotestingadapter is not safe for concurrent use
+	Refresh()
+	defer Refresh()
+	io := newTestIO()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if i%4 == 0 {
+					Refresh()
+				}
+				if _, err := FindLocalFont("tyse-test", io, "DejaVu Sans", font.StyleNormal, font.WeightBold); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestFindFontFileVariant(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	var paths []string
+	for _, name := range []string{"Go-Regular.otf", "Go-Bold.otf", "Go-Italic.otf",
+		"Go-Bold-Italic.otf"} {
+		paths = append(paths, filepath.Join(packagedDir, name))
+	}
+	for _, tc := range []struct {
+		style    font.Style
+		weight   font.Weight
+		expected string
+	}{
+		{font.StyleNormal, font.WeightNormal, "Go-Regular.otf"},
+		{font.StyleNormal, font.WeightBold, "Go-Bold.otf"},
+		{font.StyleItalic, font.WeightNormal, "Go-Italic.otf"},
+		{font.StyleItalic, font.WeightBold, "Go-Bold-Italic.otf"},
+	} {
+		fpath, ok := findFontFile(paths, "Go", tc.style, tc.weight, font.StretchNormal, fontfind.NoConfidence)
+		if !ok || filepath.Base(fpath) != tc.expected {
+			t.Errorf("expected %s for style %v and weight %v, got %q", tc.expected, tc.style, tc.weight, fpath)
+		}
+	}
+	paths = append(paths, filepath.Join(packagedDir, "Go-Mono.otf"))
+	if _, ok := findFontFile(paths, "Go Mono", font.StyleItalic, font.WeightBold, font.StretchNormal, fontfind.NoConfidence); ok {
+		t.Errorf("expected regular Go Mono not to match bold italic with confidence")
+	}
+	if fpath, ok := findFontFile(paths, "Go Mono", font.StyleNormal, font.WeightNormal, font.StretchNormal, fontfind.NoConfidence); !ok ||
+		filepath.Base(fpath) != "Go-Mono.otf" {
+		t.Errorf("expected to find Go Mono, got %q", fpath)
+	}
+	// regular expressions are matched against file names as given
+	if fpath, ok := findFontFile(paths, "^go[-_]mono$", font.StyleNormal, font.WeightNormal, font.StretchNormal,
+		fontfind.NoConfidence); !ok || filepath.Base(fpath) != "Go-Mono.otf" {
+		t.Errorf("expected to find Go Mono by regular expression, got %q", fpath)
+	}
+}
+
+func TestFindCondensedFont(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	Refresh()
+	defer Refresh()
+	find := Find("tyse-test", newTestIO())
+	f, err := find(fontfind.Descriptor{Pattern: "DejaVu Sans", Width: font.StretchCondensed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path() != "DejaVuSansCondensed.ttf" {
+		t.Errorf("expected condensed font, got %q", f.Path())
+	}
+	if f, err = find(fontfind.Descriptor{Pattern: "DejaVu Sans"}); err != nil || f.Path() != "DejaVuSans.ttf" {
+		t.Errorf("expected normal width font, got %q", f.Path())
+	}
+}
+
+func TestParseFontConfigCollectionLine(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	line := "/System/Library/Fonts/NotoSerifMyanmar.ttc: Noto Serif Myanmar,Noto Serif Myanmar Light:style=Light,Regular:index=2"
+	descs := parseFontConfigLine(line)
+	if len(descs) != 2 {
+		t.Fatalf("expected 2 font descriptors, got %d", len(descs))
+	}
+	for i, family := range []string{"Noto Serif Myanmar", "Noto Serif Myanmar Light"} {
+		if descs[i].Family != family || len(descs[i].Variants) != 1 || descs[i].Variants[0] != "light" {
+			t.Errorf("expected %s|light as descriptor %d, got %v", family, i, descs[i])
+		}
+		if descs[i].FaceIndex != 2 {
+			t.Errorf("expected face index 2 for descriptor %d, got %d", i, descs[i].FaceIndex)
+		}
+	}
+}
+
+func TestParseFontConfigNumericFields(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	for line, variant := range map[string]string{
+		"/fonts/Inter-Light.ttf: Inter:style=Light:weight=50:slant=0:width=100":               "300",
+		"/fonts/Inter-Bold.ttf: Inter:style=Bold:weight=200:slant=0:width=100":                "700",
+		"/fonts/Inter-BoldItalic.ttf: Inter:style=Bold Italic:weight=200:slant=100:width=100": "700italic",
+		"/fonts/Inter-Book.ttf: Inter:style=Book:weight=75:slant=0:width=100":                 "regular",
+		"/fonts/Inter-Oblique.ttf: Inter:style=Oblique:weight=80:slant=110":                   "oblique",
+		"/fonts/InterCond-SemiBold.ttf: Inter Condensed:style=SemiBold:weight=180:width=75":   "600 condensed",
+		"/fonts/Inter-Italic.ttf: Inter:style=Italic:slant=100":                               "italic",
+		"/fonts/InterVar.ttf: Inter:style=Regular:weight=[0 210]":                             "regular",
+	} {
+		descs := parseFontConfigLine(line)
+		if len(descs) != 1 || len(descs[0].Variants) != 1 || descs[0].Variants[0] != variant {
+			t.Errorf("expected variant %q for %q, got %v", variant, line, descs)
+		}
+	}
+	fonts := parseFontConfigLine("/fonts/Inter-Light.ttf: Inter:style=Light:weight=50")
+	fonts = append(fonts, parseFontConfigLine("/fonts/Inter-Bold.ttf: Inter:style=Light:weight=200")...)
+	if desc, v, _ := fontfind.ClosestMatch(fonts, "inter", font.StyleNormal, font.WeightBold); desc.Path != "/fonts/Inter-Bold.ttf" {
+		t.Errorf("expected weight 200 to be matched as bold, got %s|%s", desc.Path, v)
+	}
+}
+
+func TestFindLocalFontInCollection(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	var fonts [][]byte
+	for _, name := range []string{"Go-Regular.otf", "Go-Mono.otf"} {
+		data, err := os.ReadFile(packagedDir + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fonts = append(fonts, data)
+	}
+	ttc := filepath.Join(t.TempDir(), "Go.ttc")
+	if err := os.WriteFile(ttc, fonttest.MakeCollection(fonts...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	Refresh()
+	defer Refresh()
+	io := newTestIO()
+	io.fsys["fontconfig/fontlist.txt"] = &fstest.MapFile{Data: []byte(ttc + ": Go,Go Mono:style=Regular,Regular\n")}
+	f, err := FindLocalFont("tyse-test", io, "Go Mono", font.StyleNormal, font.WeightNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.FaceIndex != 1 {
+		t.Errorf("expected Go Mono to be face 1 of collection, got %d", f.FaceIndex)
+	}
+}
+
+type fcRunnerIO struct {
+	*testIO
+	calls []string
+}
+
+func (r *fcRunnerIO) RunCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("expected fontconfig command to run with a timeout")
+	}
+	r.calls = append(r.calls, filepath.Base(name))
+	switch filepath.Base(name) {
+	case "fc-list":
+		return []byte("/fonts/Inter-Bold.ttf: Inter:style=Bold:weight=200:slant=0:width=100:index=0\n"), nil
+	case "fc-match":
+		if !strings.HasPrefix(args[len(args)-1], "Noto Sans Cham:weight=80") {
+			return nil, fmt.Errorf("unexpected fc-match pattern %q", args[len(args)-1])
+		}
+		return []byte("/fonts/NotoSansCham-Regular.ttf: Noto Sans Cham:style=Regular:weight=80:slant=0:width=100:index=0"), nil
+	}
+	return nil, errors.New("unknown command")
+}
+
+func TestFontConfigCommands(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	io := &fcRunnerIO{testIO: &testIO{fsys: fstest.MapFS{}}} // no font list file
+	Refresh()
+	defer Refresh()
+	FindLocalFont("tyse-test", io, "Inter", font.StyleNormal, font.WeightBold)
+	if len(io.calls) > 0 {
+		t.Errorf("expected no fontconfig commands to run unless configured, ran %v", io.calls)
+	}
+	conf := testconfig.Conf{"app-key": "tyse-test", "fontconfig-fc-list": "/usr/bin/fc-list"}
+	find := FindWithConfig(conf, io)
+	if fontConfigCommandPath() != "" {
+		t.Errorf("expected locator to keep fontconfig settings to itself")
+	}
+	Refresh()
+	f, err := find(fontfind.Descriptor{Pattern: "Inter", Weight: font.WeightBold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path() != "Inter-Bold.ttf" {
+		t.Errorf("expected font from fc-list, got %q", f.Path())
+	}
+	for i := 0; i < 2; i++ { // fc-match answers are remembered
+		if f, err = find(fontfind.Descriptor{Pattern: "Noto Sans Cham"}); err != nil ||
+			f.Path() != "NotoSansCham-Regular.ttf" {
+			t.Errorf("expected font from fc-match, got %q (%v)", f.Path(), err)
+		}
+	}
+	if len(io.calls) != 2 || io.calls[0] != "fc-list" || io.calls[1] != "fc-match" {
+		t.Errorf("expected fc-list and fc-match to run once each, ran %v", io.calls)
+	}
+	io.calls = nil
+	FindLocalFont("tyse-test", io, "Inter", font.StyleNormal, font.WeightBold)
+	if len(io.calls) > 0 {
+		t.Errorf("expected other lookups not to run fontconfig commands, ran %v", io.calls)
+	}
+}
+
+func TestScanFontDirs(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	read := func(name string) []byte {
+		data, err := os.ReadFile(packagedDir + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	io := &testIO{fsys: fstest.MapFS{
+		"a1b2c3.otf":         &fstest.MapFile{Data: read("Go-Bold-Italic.otf")},
+		"sub/Collection.ttc": &fstest.MapFile{Data: fonttest.MakeCollection(read("Go-Regular.otf"), read("Go-Mono.otf"))},
+		"sub/ReadMe.txt":     &fstest.MapFile{Data: []byte("not a font")},
+		"broken.ttf":         &fstest.MapFile{Data: []byte("not a font either")},
+	}}
+	fonts := scanFontDirs(io, []string{"/fonts"})
+	if len(fonts) != 3 {
+		t.Fatalf("expected 3 faces, got %v", fonts)
+	}
+	// Go Bold declares OS/2 weight class 600
+	desc, v, c := fontfind.ClosestMatch(fonts, "^go$", font.StyleItalic, font.WeightSemiBold)
+	if desc.Path != filepath.Join("/fonts", "a1b2c3.otf") || c != fontfind.PerfectConfidence {
+		t.Errorf("expected hashed file name to be matched by metadata, got %s|%s (%d)", desc.Path, v, c)
+	}
+	desc, v, _ = fontfind.ClosestMatch(fonts, "go mono", font.StyleNormal, font.WeightNormal)
+	if desc.Path != filepath.Join("/fonts", "sub", "Collection.ttc") || desc.FaceIndex != 1 {
+		t.Errorf("expected Go Mono to be face 1 of collection, got %s|%s, index %d", desc.Path, v, desc.FaceIndex)
+	}
+}
+
+func TestExtraFontDirs(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	data, err := os.ReadFile(packagedDir + "Go-Bold.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io := newTestIO()
+	io.fsys["bundled/x.otf"] = &fstest.MapFile{Data: data}
+	conf := testconfig.Conf{"app-key": "tyse-test", "extra-font-dirs": "/app"}
+	find := FindWithConfig(conf, io)
+	Refresh()
+	defer Refresh()
+	f, err := find(fontfind.Descriptor{Pattern: "Go", Weight: font.WeightSemiBold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path() != "x.otf" {
+		t.Errorf("expected font from extra folder, got %q", f.Path())
+	}
+	if len(extraFontDirs()) != 0 {
+		t.Errorf("expected locator to keep extra font folders to itself")
+	}
+	// fonts not in extra folders are found with fontconfig
+	if f, err = find(fontfind.Descriptor{Pattern: "DejaVu Sans", Weight: font.WeightBold}); err != nil {
+		t.Fatal(err)
+	}
+	if f.Path() != "DejaVuSans-Bold.ttf" {
+		t.Errorf("expected DejaVu Sans Bold from fontconfig list, got %q", f.Path())
+	}
+}
+
+func TestRetainFontData(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "tyse.font")
+	defer teardown()
+	//
+	data, err := os.ReadFile(packagedDir + "Go-Bold.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "x.otf"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := testconfig.Conf{"app-key": "tyse-test", "extra-font-dirs": dir, "retain-system-fonts": true}
+	find := FindWithConfig(conf, USE_SYSTEM_IO)
+	f, err := find(fontfind.Descriptor{Pattern: "Go", Weight: font.WeightBold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retainFontData() {
+		t.Errorf("expected retain-system-fonts to apply to its locator only")
+	}
+	if f.File() != filepath.Join(dir, "x.otf") {
+		t.Errorf("expected retained font to keep its file, have %q", f.File())
+	}
+	if err := os.Remove(f.File()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := f.ReadFontData(); err != nil || len(b) != len(data) {
+		t.Errorf("expected font data to be read from memory, have %d bytes (%v)", len(b), err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadFontData(); err == nil {
+		t.Errorf("expected closed font to be read from disk again")
+	}
+}
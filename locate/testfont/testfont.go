@@ -54,6 +54,7 @@ func findTestFont(testdata embed.FS, pattern string, style font.Style, weight fo
 	sFont.Name = fname
 	sFont.Style = style
 	sFont.Weight = weight
+	sFont.Source = fontfind.SourcePackaged
 	sFont.SetFS(testdata, "testdata/"+fname)
 	return sFont, nil
 }
@@ -0,0 +1,84 @@
+/*
+Package zipfont resolves fonts from zip archives, e.g. an application's fonts
+shipped in a single file, or a font family downloaded from Google Fonts as a zip.
+
+Fonts within an archive are identified by the metadata of the font binaries, not
+by their file names, see locate.FSLocator.
+*/
+package zipfont
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
+	"github.com/npillmayer/schuko/tracing"
+)
+
+// tracer writes to trace with key 'tyse.font'
+func tracer() tracing.Trace {
+	return tracing.Select("tyse.font")
+}
+
+// Find creates a locator that resolves fonts from the zip archive at zippath, and
+// a closer for the archive.
+//
+// The archive is opened and its fonts are scanned on first use of the locator.
+// It stays open until it is closed, as the fonts returned read their data from it
+// (see fontfind.ScalableFont.SetFS). Fonts returned cannot read their data once the
+// archive is closed, and the locator returns an error wrapping fs.ErrClosed.
+// If the archive cannot be opened, every call of the locator returns the error.
+func Find(zippath string) (locate.FontLocator, io.Closer) {
+	a := &archive{path: zippath}
+	return a.find, a
+}
+
+// archive is a zip archive of fonts, opened on first use.
+type archive struct {
+	sync.Mutex
+	path   string
+	zr     *zip.ReadCloser // nil until opened
+	locate locate.FontLocator
+	err    error // error opening the archive
+	closed bool
+}
+
+func (a *archive) find(descr fontfind.Descriptor) (fontfind.ScalableFont, error) {
+	a.Lock()
+	if a.closed {
+		a.Unlock()
+		return fontfind.NullFont, fmt.Errorf("font archive %s: %w", a.path, fs.ErrClosed)
+	}
+	if a.zr == nil && a.err == nil {
+		if a.zr, a.err = zip.OpenReader(a.path); a.err != nil {
+			a.zr, a.err = nil, fmt.Errorf("cannot open font archive: %w", a.err)
+		} else {
+			tracer().Debugf("opened font archive %s", a.path)
+			a.locate = locate.FSLocator(a.zr, ".")
+		}
+	}
+	find, err := a.locate, a.err
+	a.Unlock()
+	if err != nil {
+		return fontfind.NullFont, err
+	}
+	return find(descr)
+}
+
+// Close closes the archive. It implements io.Closer.
+func (a *archive) Close() error {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.zr == nil {
+		return nil
+	}
+	return a.zr.Close()
+}
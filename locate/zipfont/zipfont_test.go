@@ -0,0 +1,92 @@
+package zipfont
+
+import (
+	"archive/zip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/npillmayer/fontfind"
+	"github.com/npillmayer/fontfind/locate"
+	"github.com/npillmayer/schuko/tracing/gotestingadapter"
+	"golang.org/x/image/font"
+)
+
+// writeArchive creates a zip archive in a temporary folder, containing the
+// packaged fallback fonts given as entries (name in archive → packaged font).
+func writeArchive(t *testing.T, entries map[string]string) string {
+	t.Helper()
+	zippath := filepath.Join(t.TempDir(), "fonts.zip")
+	out, err := os.Create(zippath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for name, packaged := range entries {
+		data := []byte("not a font")
+		if packaged != "" {
+			if data, err = os.ReadFile(filepath.Join("..", "fallbackfont", "packaged", packaged)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return zippath
+}
+
+func TestFindInArchive(t *testing.T) {
+	teardown := gotestingadapter.QuickConfig(t, "resources")
+	defer teardown()
+	//
+	zippath := writeArchive(t, map[string]string{
+		"Go/OFL.txt":                      "",
+		"Go/Go-Regular.otf":               "Go-Regular.otf",
+		"Go/static/Font-BI.otf":           "Go-Bold-Italic.otf",
+		"Go/static/Go-Bold.otf":           "Go-Bold.otf",
+		"Gentium/GentiumPlus-Regular.ttf": "GentiumPlus-R.ttf",
+	})
+	find, archive := Find(zippath)
+	f, err := find(fontfind.Descriptor{Pattern: "Go", Style: font.StyleItalic, Weight: font.WeightBold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Go Bold has an OS/2 weight class of 600
+	if f.Name != "Font-BI.otf" || f.Style != font.StyleItalic || f.Weight <= font.WeightNormal {
+		t.Errorf("expected bold italic Go font from archive, have %s %v %v", f.Name, f.Style, f.Weight)
+	}
+	data, err := f.ReadFontData()
+	if err != nil || len(data) == 0 {
+		t.Fatalf("cannot read font data from archive: %v", err)
+	}
+	if f, err = find(fontfind.Descriptor{Pattern: "Gentium Plus"}); err != nil || f.Name != "GentiumPlus-Regular.ttf" {
+		t.Errorf("expected Gentium Plus from archive, have %q, %v", f.Name, err)
+	}
+	if _, err = find(fontfind.Descriptor{Pattern: "Roboto"}); !errors.Is(err, locate.ErrFontNotFound) {
+		t.Errorf("expected ErrFontNotFound for font not in archive, have %v", err)
+	}
+	if err = archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = find(fontfind.Descriptor{Pattern: "Go"}); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("expected locator to fail after archive is closed, have %v", err)
+	}
+	missing, closer := Find(filepath.Join(t.TempDir(), "missing.zip"))
+	if _, err = missing(fontfind.Descriptor{Pattern: "Go"}); err == nil {
+		t.Errorf("expected error for missing archive")
+	}
+	if err = closer.Close(); err != nil {
+		t.Errorf("expected archive which could not be opened to close, have %v", err)
+	}
+}
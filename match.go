@@ -5,15 +5,53 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/text/unicode/norm"
 )
 
 // FontVariantsLocation describes known variants and location info for a font family.
 type FontVariantsLocation struct {
-	Family   string   `json:"family"`
-	Variants []string `json:"variants"`
-	Path     string   // used for local font sources
+	Family    string   `json:"family"`
+	Variants  []string `json:"variants"`
+	Path      string   // used for local font sources
+	FaceIndex int      // index of the face within a font collection (*.ttc), if known
+}
+
+// Variant describes an available variant of a font family.
+type Variant struct {
+	Name   string // variant name as used by the font source, e.g. "700italic"
+	Style  font.Style
+	Weight font.Weight
+}
+
+// ParseVariant derives style and weight from a variant name. It understands
+// numeric CSS weights as used by Google Fonts ("300", "700italic") as well as
+// weight words ("light", "semibold", "black").
+func ParseVariant(name string) Variant {
+	v := Variant{Name: name, Style: font.StyleNormal, Weight: font.WeightNormal}
+	lower := strings.ToLower(name)
+	if strings.Contains(lower, "italic") {
+		v.Style = font.StyleItalic
+	} else if strings.Contains(lower, "obliq") {
+		v.Style = font.StyleOblique
+	}
+	digits := 0
+	for digits < len(lower) && lower[digits] >= '0' && lower[digits] <= '9' {
+		digits++
+	}
+	if n, err := strconv.Atoi(lower[:digits]); err == nil && n >= 100 && n <= 900 {
+		v.Weight = font.Weight(n/100 - 4)
+		return v
+	}
+	if w, ok := weightFromWord(lower); ok {
+		v.Weight = w
+	} else if w, ok := containedWeight(lower); ok {
+		v.Weight = w
+	}
+	return v
 }
 
 // Matches returns true if a font's filename contains pattern and indicators
@@ -43,29 +81,98 @@ const (
 	PerfectConfidence MatchConfidence = 4
 )
 
+// AcceptMatch returns true if a match with confidence is good enough for a
+// required minimum confidence (see Descriptor.MinConfidence). A minimum of
+// NoConfidence selects the default, which accepts matches with more than
+// LowConfidence.
+func AcceptMatch(confidence, minConfidence MatchConfidence) bool {
+	if minConfidence == NoConfidence {
+		return confidence > LowConfidence
+	}
+	return confidence >= minConfidence
+}
+
 // ClosestMatch scans a list of font descriptors and returns the closest match
-// for a given set of parameters.
+// for a given set of parameters. Fonts of normal width are preferred, see
+// ClosestMatchWithWidth.
 //
 // If no variant matches, returns `NoConfidence`.
 func ClosestMatch(fdescs []FontVariantsLocation, pattern string, style font.Style,
 	weight font.Weight) (match FontVariantsLocation, variant string, confidence MatchConfidence) {
 	//
-	r, err := regexp.Compile(strings.ToLower(pattern))
+	return ClosestMatchWithWidth(fdescs, pattern, style, weight, font.StretchNormal)
+}
+
+// ClosestMatchWithWidth scans a list of font descriptors and returns the closest match
+// for a given set of parameters, including the width of the font. Width may be
+// indicated by the family name ("Roboto Condensed") or by the variant name.
+//
+// If no variant matches, returns `NoConfidence`.
+func ClosestMatchWithWidth(fdescs []FontVariantsLocation, pattern string, style font.Style,
+	weight font.Weight, width font.Stretch) (match FontVariantsLocation, variant string, confidence MatchConfidence) {
+	//
+	return closestMatch(fdescs, pattern, style, weight, width, false)
+}
+
+// ClosestMatch scans a list of font descriptors and returns the closest match for
+// desc, like ClosestMatchWithWidth. If desc.FuzzyFamily is set and no family matches
+// desc.Pattern, families with a name similar to the pattern are considered, e.g.
+// "Helvetica" for "Helvetca" (see FuzzyFamilyMatch). Confidence for these is one
+// level lower than for an exact match.
+func (desc Descriptor) ClosestMatch(fdescs []FontVariantsLocation) (match FontVariantsLocation,
+	variant string, confidence MatchConfidence) {
+	//
+	return closestMatch(fdescs, desc.Pattern, desc.Style, desc.Weight, desc.Width, desc.FuzzyFamily)
+}
+
+// closestMatch is ClosestMatchWithWidth, falling back to fuzzy matching of family
+// names if fuzzy is set.
+func closestMatch(fdescs []FontVariantsLocation, pattern string, style font.Style, weight font.Weight,
+	width font.Stretch, fuzzy bool) (match FontVariantsLocation, variant string, confidence MatchConfidence) {
+	//
+	r, err := regexp.Compile(familyPattern(pattern))
 	if err != nil {
 		tracer().Errorf("invalid font name pattern")
 		return
 	}
+	matchFamily := func(family string) bool {
+		return r.MatchString(strings.ToLower(family)) || r.MatchString(NormalizeFamily(family))
+	}
+	match, variant, confidence, found := closestVariant(fdescs, matchFamily, style, weight, width)
+	if found || !fuzzy {
+		return
+	}
+	matchFamily = func(family string) bool {
+		return FuzzyFamilyMatch(pattern, family)
+	}
+	if match, variant, confidence, found = closestVariant(fdescs, matchFamily, style, weight, width); found {
+		tracer().Debugf("font name pattern %q fuzzily matches %s", pattern, match.Family)
+		if confidence > NoConfidence {
+			confidence--
+		}
+	}
+	return
+}
+
+// closestVariant returns the closest match of the font descriptors with a family
+// accepted by matchFamily. found is false if no family is accepted.
+func closestVariant(fdescs []FontVariantsLocation, matchFamily func(string) bool, style font.Style,
+	weight font.Weight, width font.Stretch) (match FontVariantsLocation, variant string,
+	confidence MatchConfidence, found bool) {
+	//
+	var best MatchConfidence // sum of style, weight and width confidence, decides ties
 	for _, fdesc := range fdescs {
-		//trace().Debugf("trying to match %s", strings.ToLower(fdesc.Family))
-		if !r.MatchString(strings.ToLower(fdesc.Family)) {
+		if !matchFamily(fdesc.Family) {
 			continue
 		}
+		found = true
 		for _, v := range fdesc.Variants {
 			s := MatchStyle(v, style)
 			w := MatchWeight(v, weight)
-			if (s+w)/2 > confidence {
-				//trace().Debugf("variant %+v match confidence = %d + %d", v, s, w)
-				confidence = (s + w) / 2
+			x := MatchWidth(fdesc.Family+" "+v, width)
+			if c := variantConfidence(s, w, x); c > confidence || (c == confidence && s+w+x > best) {
+				best = s + w + x
+				confidence = c
 				variant = v
 				match = fdesc
 			}
@@ -74,52 +181,335 @@ func ClosestMatch(fdescs []FontVariantsLocation, pattern string, style font.Styl
 	return
 }
 
+// variantConfidence combines the style, weight and width confidence of a variant.
+// Style and weight are averaged, and width caps the result: a font of the wrong
+// width is a poor match, however well style and weight fit, and averaging the
+// three would hide the mismatch.
+func variantConfidence(s, w, x MatchConfidence) MatchConfidence {
+	return min((s+w)/2, x)
+}
+
+// minFamilySimilarity is the similarity of family names considered a fuzzy match.
+const minFamilySimilarity = 0.8
+
+// FuzzyFamilyMatch returns true if family is a close match for pattern, i.e. its
+// name differs from pattern in spacing, punctuation or a few typos only
+// ("Helvetca" for "Helvetica", "M PLUS 1 p" for "M PLUS 1p"). Names are compared
+// by their normalized Levenshtein distance. Fuzzy matching is opt-in for font
+// lookup, see Descriptor.FuzzyFamily.
+func FuzzyFamilyMatch(pattern, family string) bool {
+	a := []rune(strings.ReplaceAll(NormalizeFamily(pattern), " ", ""))
+	b := []rune(strings.ReplaceAll(NormalizeFamily(family), " ", ""))
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	maxLen := len(a)
+	if len(b) > maxLen {
+		maxLen = len(b)
+	}
+	return 1-float64(levenshtein(a, b))/float64(maxLen) >= minFamilySimilarity
+}
+
+// NormalizeFamily normalizes a font family name for comparison: letters are
+// lower-cased and stripped of diacritics, and runs of spaces and punctuation are
+// collapsed into a single space, e.g. "Amático  SC" → "amatico sc" and
+// "Go-Mono" → "go mono". The normalized form is for matching only; family names
+// are displayed as given.
+func NormalizeFamily(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r): // combining diacritical mark
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// familyPattern prepares a font name pattern for matching against family names.
+// Patterns without regular expression operators are plain names and are
+// normalized (see NormalizeFamily).
+func familyPattern(pattern string) string {
+	if regexp.QuoteMeta(pattern) == pattern {
+		return NormalizeFamily(pattern)
+	}
+	return strings.ToLower(pattern)
+}
+
+// levenshtein returns the edit distance of a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// MatchFont tells how well a font matches style, weight and width. Locators set
+// style and weight of a font to the requested ones, therefore MatchFont judges by
+// the subfamily name read from the font binary instead, e.g. "Bold Italic", or by
+// the name of the selected instance of a variable font (see SelectInstance).
+// Confidence is the mean of style and weight confidence, capped by width confidence.
+func MatchFont(f ScalableFont, style font.Style, weight font.Weight, width font.Stretch) (MatchConfidence, error) {
+	sf, err := f.Sfnt()
+	if err != nil {
+		return NoConfidence, err
+	}
+	var buf sfnt.Buffer
+	family, err := sf.Name(&buf, sfnt.NameIDTypographicFamily)
+	if err != nil {
+		family, _ = sf.Name(&buf, sfnt.NameIDFamily)
+	}
+	subfamily, err := sf.Name(&buf, sfnt.NameIDTypographicSubfamily)
+	if err != nil {
+		subfamily, _ = sf.Name(&buf, sfnt.NameIDSubfamily)
+	}
+	if f.Instance != nil {
+		subfamily = f.Instance.Name
+	}
+	// "Semi Bold Italic" → "semibolditalic", as understood by MatchWeight
+	variant := strings.NewReplacer(" ", "", "-", "").Replace(strings.ToLower(subfamily))
+	s := MatchStyle(variant, style)
+	w := MatchWeight(variant, weight)
+	x := MatchWidth(family+" "+subfamily, width)
+	return variantConfidence(s, w, x), nil
+}
+
 // ---------------------------------------------------------------------------
 
 // GuessStyleAndWeight tries to guess a font's style and weight from the
 // font's file name.
 func GuessStyleAndWeight(fontfilename string) (font.Style, font.Weight) {
+	style, weight, _ := guessStyleAndWeight(fontfilename)
+	return style, weight
+}
+
+// GuessFontStyleAndWeight determines style and weight of font f. If f's file name
+// carries style or weight indicators, these are used. Otherwise, e.g. for
+// `Cambria Math.ttf` or hashed cache file names, style and weight are read
+// from the font binary (see ReadMetadata).
+func GuessFontStyleAndWeight(f ScalableFont) (font.Style, font.Weight) {
+	style, weight, ok := guessStyleAndWeight(f.Path())
+	if ok {
+		return style, weight
+	}
+	if md, err := ReadMetadata(f); err == nil {
+		return md.Style, md.Weight
+	}
+	return style, weight
+}
+
+// guessStyleAndWeight guesses style and weight from a file name. ok is false if the
+// file name does not contain any style or weight indicators.
+func guessStyleAndWeight(fontfilename string) (style font.Style, weight font.Weight, ok bool) {
 	fontfilename = path.Base(fontfilename)
 	ext := path.Ext(fontfilename)
 	fontfilename = strings.ToLower(fontfilename[:len(fontfilename)-len(ext)])
+	style = font.StyleNormal
+	if strings.Contains(fontfilename, "italic") {
+		style, ok = font.StyleItalic, true
+	} else if strings.Contains(fontfilename, "obliq") {
+		style, ok = font.StyleOblique, true
+	}
 	s := strings.Split(fontfilename, "-")
 	if len(s) > 1 {
-		switch s[len(s)-1] {
-		case "light", "xlight":
-			return font.StyleNormal, font.WeightLight
-		case "normal", "medium", "regular", "r":
-			return font.StyleNormal, font.WeightNormal
-		case "bold", "b":
-			return font.StyleNormal, font.WeightBold
-		case "xbold", "black":
-			return font.StyleNormal, font.WeightExtraBold
-		}
-	}
-	style, weight := font.StyleNormal, font.WeightNormal
-	if strings.Contains(fontfilename, "italic") {
-		style = font.StyleItalic
+		last := s[len(s)-1]
+		if w, rest, isNum := numericWeight(last); isNum && (rest == "" || rest == "italic" || rest == "oblique") {
+			return style, w, true
+		}
+		if w, isWord := weightFromWord(last); isWord {
+			return style, w, true
+		}
+	}
+	weight = font.WeightNormal
+	if w, found := containedWeight(fontfilename); found {
+		weight, ok = w, true
+	}
+	return style, weight, ok
+}
+
+// weightWords is the ladder of weight words designers use in font names. Compound
+// words are listed before their parts. Words flagged as substring are recognized
+// within other words as well (e.g., "bold" in "bolditalic"); the others must be
+// words of their own, as they may well be part of a family name ("Nothing You Could Do").
+var weightWords = []struct {
+	word      string
+	weight    font.Weight
+	substring bool
+}{
+	{"extralight", font.WeightExtraLight, true},
+	{"ultralight", font.WeightExtraLight, true},
+	{"semibold", font.WeightSemiBold, true},
+	{"demibold", font.WeightSemiBold, true},
+	{"extrabold", font.WeightExtraBold, true},
+	{"ultrabold", font.WeightExtraBold, true},
+	{"xlight", font.WeightExtraLight, false},
+	{"xbold", font.WeightExtraBold, false},
+	{"hairline", font.WeightThin, false},
+	{"thin", font.WeightThin, false},
+	{"medium", font.WeightMedium, false},
+	{"heavy", font.WeightBlack, false},
+	{"black", font.WeightBlack, false},
+	{"bold", font.WeightBold, true},
+	{"light", font.WeightLight, true},
+}
+
+// weightFromWord returns the weight denoted by a single word, e.g. "semibold".
+// A style suffix is ignored ("bolditalic").
+func weightFromWord(word string) (font.Weight, bool) {
+	word = strings.ToLower(word)
+	for _, style := range []string{"italic", "oblique"} {
+		if word != style {
+			word = strings.TrimSuffix(word, style)
+		}
 	}
-	if strings.Contains(fontfilename, "light") {
-		weight = font.WeightLight
+	switch word {
+	case "normal", "regular", "book", "roman", "r":
+		return font.WeightNormal, true
+	case "b":
+		return font.WeightBold, true
 	}
-	if strings.Contains(fontfilename, "bold") {
-		weight = font.WeightBold
+	for _, ww := range weightWords {
+		if ww.word == word {
+			return ww.weight, true
+		}
 	}
-	return style, weight
+	return font.WeightNormal, false
+}
+
+// containedWeight looks for weight words in a font name consisting of several
+// words, e.g. "Gill Sans MT Semi Bold".
+func containedWeight(name string) (font.Weight, bool) {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		if i+1 < len(words) { // "semi bold", "extra light"
+			if w, ok := weightFromWord(word + words[i+1]); ok && w != font.WeightNormal {
+				return w, true
+			}
+		}
+		if w, ok := weightFromWord(word); ok && w != font.WeightNormal && len(word) > 1 {
+			return w, true
+		}
+	}
+	for _, ww := range weightWords {
+		if ww.substring && strings.Contains(name, ww.word) {
+			return ww.weight, true
+		}
+	}
+	return font.WeightNormal, false
+}
+
+// widthNames are the names of font widths, indexed by font.Stretch+4.
+var widthNames = [...]string{
+	"ultracondensed", "extracondensed", "condensed", "semicondensed", "",
+	"semiexpanded", "expanded", "extraexpanded", "ultraexpanded",
 }
 
-// MatchStyle tries to match a font-variant to a given style.
+// WidthName returns the name of a font width as used in font and variant names,
+// e.g. "semicondensed" for StretchSemiCondensed. For normal width, or a width
+// outside of the range of font.Stretch, the empty string is returned.
+func WidthName(width font.Stretch) string {
+	if i := int(width) + 4; i >= 0 && i < len(widthNames) {
+		return widthNames[i]
+	}
+	return ""
+}
+
+// widthSynonyms are width indicators used in font names besides the width names.
+var widthSynonyms = map[string]font.Stretch{
+	"narrow":   font.StretchCondensed,
+	"extended": font.StretchExpanded,
+}
+
+// GuessWidth tries to guess a font's width from a font or variant name,
+// e.g. "Roboto Condensed Bold" → StretchCondensed.
+func GuessWidth(name string) font.Stretch {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(name)
+	_, width := containedWidth(name)
+	return width
+}
+
+// containedWidth finds the longest width name or synonym contained in name, e.g.
+// "semicondensed" rather than "condensed", and returns it with its width. If name
+// contains no width indicator, it returns the empty string and StretchNormal.
+func containedWidth(name string) (string, font.Stretch) {
+	found, width := "", font.StretchNormal
+	for i, wn := range widthNames {
+		if wn != "" && len(wn) > len(found) && strings.Contains(name, wn) {
+			found, width = wn, font.Stretch(i-4)
+		}
+	}
+	for word, w := range widthSynonyms {
+		if len(word) > len(found) && strings.Contains(name, word) {
+			found, width = word, w
+		}
+	}
+	return found, width
+}
+
+// MatchWidth tries to match a font name or variant name to a given width.
+func MatchWidth(name string, width font.Stretch) MatchConfidence {
+	have := GuessWidth(name)
+	switch {
+	case have == width:
+		return PerfectConfidence
+	case have*width > 0: // both condensed or both expanded
+		return HighConfidence
+	case have-width == 1 || width-have == 1:
+		return LowConfidence
+	}
+	return NoConfidence
+}
+
+// numericWeight splits a CSS weight (100…900) off the start of token, e.g.
+// "300italic" → (WeightLight, "italic"). ok is false if token does not start
+// with a CSS weight.
+func numericWeight(token string) (weight font.Weight, rest string, ok bool) {
+	if len(token) < 3 {
+		return font.WeightNormal, token, false
+	}
+	n, err := strconv.Atoi(token[:3])
+	if err != nil || n < 100 || n > 900 || n%100 != 0 {
+		return font.WeightNormal, token, false
+	}
+	return font.Weight(n/100 - 4), token[3:], true
+}
+
+// MatchStyle tries to match a font-variant to a given style. It considers only the
+// slant of the variant: for StyleNormal every upright variant is a perfect match,
+// whatever its weight (e.g. "700" or "bold"); use MatchWeight to rank weights.
 func MatchStyle(variantName string, style font.Style) MatchConfidence {
 	variantName = strings.ToLower(variantName)
 	switch style {
 	case font.StyleNormal:
-		switch variantName {
-		case "regular", "400":
-			return PerfectConfidence
-		case "100", "200", "300", "500":
-			return HighConfidence
+		if strings.Contains(variantName, "italic") || strings.Contains(variantName, "obliq") {
+			return NoConfidence
 		}
-		return NoConfidence
+		return PerfectConfidence
 	case font.StyleItalic:
 		if strings.Contains(variantName, "italic") {
 			return PerfectConfidence
@@ -140,6 +530,22 @@ func MatchStyle(variantName string, style font.Style) MatchConfidence {
 	return NoConfidence
 }
 
+// variantWeightName strips style and width indicators from a variant name, leaving
+// the weight part, e.g. "700italic condensed" → "700". A plain style ("italic") denotes the
+// regular weight.
+func variantWeightName(variantName string) string {
+	name := strings.ToLower(variantName)
+	for word, _ := containedWidth(name); word != ""; word, _ = containedWidth(name) { // "700 condensed" → "700"
+		name = strings.TrimSpace(strings.ReplaceAll(name, word, ""))
+	}
+	for _, style := range []string{"italic", "oblique"} {
+		if name != style {
+			name = strings.TrimSuffix(name, style)
+		}
+	}
+	return strings.TrimSpace(strings.TrimSuffix(name, "-"))
+}
+
 // MatchWeight tries to match a font-variant to a given weight.
 func MatchWeight(variantName string, weight font.Weight) MatchConfidence {
 	/* from https://pkg.go.dev/golang.org/x/image/font
@@ -153,7 +559,11 @@ func MatchWeight(variantName string, weight font.Weight) MatchConfidence {
 	WeightExtraBold  Weight = +4 // CSS font-weight value 800.
 	WeightBlack      Weight = +5 // CSS font-weight value 900.
 	*/
-	if strconv.Itoa(int(weight)+4*100) == variantName {
+	variantName = variantWeightName(variantName)
+	if w, ok := weightFromWord(variantName); ok {
+		variantName = strconv.Itoa((int(w) + 4) * 100) // "semibold" → "600"
+	}
+	if strconv.Itoa((int(weight)+4)*100) == variantName {
 		return PerfectConfidence
 	}
 	switch variantName {
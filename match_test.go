@@ -0,0 +1,31 @@
+package fontfind
+
+import (
+	"testing"
+
+	"golang.org/x/image/font"
+)
+
+func TestMatchStyle(t *testing.T) {
+	for _, tc := range []struct {
+		variant    string
+		style      font.Style
+		confidence MatchConfidence
+	}{
+		{"regular", font.StyleNormal, PerfectConfidence},
+		{"400", font.StyleNormal, PerfectConfidence},
+		{"700", font.StyleNormal, PerfectConfidence}, // weight is not considered
+		{"Bold", font.StyleNormal, PerfectConfidence},
+		{"700italic", font.StyleNormal, NoConfidence},
+		{"oblique", font.StyleNormal, NoConfidence},
+		{"700italic", font.StyleItalic, PerfectConfidence},
+		{"oblique", font.StyleItalic, HighConfidence},
+		{"bold", font.StyleItalic, NoConfidence},
+		{"oblique", font.StyleOblique, PerfectConfidence},
+		{"italic", font.StyleOblique, HighConfidence},
+	} {
+		if c := MatchStyle(tc.variant, tc.style); c != tc.confidence {
+			t.Errorf("MatchStyle(%q, %d) = %d, expected %d", tc.variant, tc.style, c, tc.confidence)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package fontfind
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+)
+
+// Metadata is information about a font, read from the font binary rather than
+// guessed from its file name.
+type Metadata struct {
+	Family      string // typographic family name, e.g. "Cambria Math"
+	Subfamily   string // typographic subfamily name, e.g. "Bold Italic"
+	FullName    string // full font name
+	Style       font.Style
+	Weight      font.Weight
+	Width       font.Stretch
+	IsMonospace bool // font is flagged as fixed-pitch
+}
+
+// ReadMetadata parses the name table of font f to get family, subfamily and
+// full name. Style, weight and width are read from the OS/2 table or, if the font
+// does not have one, from the style bits of the head table.
+func ReadMetadata(f ScalableFont) (Metadata, error) {
+	md := Metadata{Style: font.StyleNormal, Weight: font.WeightNormal, Width: font.StretchNormal}
+	sf, err := f.Sfnt()
+	if err != nil {
+		return md, err
+	}
+	var buf sfnt.Buffer
+	if md.Family, err = sf.Name(&buf, sfnt.NameIDTypographicFamily); err != nil {
+		md.Family, _ = sf.Name(&buf, sfnt.NameIDFamily)
+	}
+	if md.Subfamily, err = sf.Name(&buf, sfnt.NameIDTypographicSubfamily); err != nil {
+		md.Subfamily, _ = sf.Name(&buf, sfnt.NameIDSubfamily)
+	}
+	md.FullName, _ = sf.Name(&buf, sfnt.NameIDFull)
+	if post := sf.PostTable(); post != nil {
+		md.IsMonospace = post.IsFixedPitch
+	}
+	// package sfnt does not expose the OS/2 and head tables, so we read them ourselves
+	if os2, err := readTable(f, "OS/2"); err == nil && len(os2) >= 64 {
+		md.Weight = weightFromClass(int(binary.BigEndian.Uint16(os2[4:])))
+		if class := int(binary.BigEndian.Uint16(os2[6:])); class >= 1 && class <= 9 {
+			md.Width = font.Stretch(class - 5) // width class 5 is normal
+		}
+		fsSelection := binary.BigEndian.Uint16(os2[62:])
+		if fsSelection&(1<<9) != 0 {
+			md.Style = font.StyleOblique
+		} else if fsSelection&1 != 0 {
+			md.Style = font.StyleItalic
+		}
+	} else if head, err := readTable(f, "head"); err == nil && len(head) >= 46 {
+		macStyle := binary.BigEndian.Uint16(head[44:])
+		if macStyle&1 != 0 {
+			md.Weight = font.WeightBold
+		}
+		if macStyle&2 != 0 {
+			md.Style = font.StyleItalic
+		}
+	}
+	tracer().Debugf("metadata of %s: %+v", f.Name, md)
+	return md, nil
+}
+
+// weightFromClass maps an OS/2 weight class (100…900) to a font.Weight.
+func weightFromClass(class int) font.Weight {
+	w := (class+50)/100 - 4
+	if w < int(font.WeightThin) {
+		return font.WeightThin
+	} else if w > int(font.WeightBlack) {
+		return font.WeightBlack
+	}
+	return font.Weight(w)
+}
+
+// missingTableError tells that a font does not have a table, as opposed to a
+// font which cannot be parsed.
+type missingTableError string
+
+func (tag missingTableError) Error() string {
+	return fmt.Sprintf("font has no %s table", strings.TrimSpace(string(tag)))
+}
+
+// isMissingTable returns true if err tells that a font does not have a table.
+func isMissingTable(err error) bool {
+	var missing missingTableError
+	return errors.As(err, &missing)
+}
+
+// findTable returns the data of table tag of face number index of an SFNT font
+// or font collection.
+func findTable(data []byte, index int, tag string) ([]byte, error) {
+	return readTableAt(bytes.NewReader(data), int64(len(data)), index, tag)
+}
+
+// readTable returns the data of table tag of face f.FaceIndex of font f. If the font
+// file supports random access, only the table directory and the table are read, not
+// the whole file.
+func readTable(f ScalableFont, tag string) ([]byte, error) {
+	if f.fileSystem == nil || f.path == "" {
+		data, err := f.ReadFontData() // reports the error
+		if err != nil {
+			return nil, err
+		}
+		return findTable(data, f.FaceIndex, tag)
+	}
+	file, err := f.fileSystem.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r, ok := file.(io.ReaderAt)
+	info, err := file.Stat()
+	if !ok || err != nil {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		return findTable(data, f.FaceIndex, tag)
+	}
+	return readTableAt(r, info.Size(), f.FaceIndex, tag)
+}
+
+// readTableAt returns the data of table tag of face number index of an SFNT font
+// or font collection of size bytes.
+func readTableAt(r io.ReaderAt, size int64, index int, tag string) ([]byte, error) {
+	read := func(offset int64, n int) ([]byte, error) {
+		if offset < 0 || n < 0 || offset+int64(n) > size {
+			return nil, errors.New("invalid SFNT header")
+		}
+		buf := make([]byte, n)
+		if _, err := r.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	header, err := read(0, 12)
+	if err != nil {
+		return nil, err
+	}
+	var offset int64
+	if string(header[:4]) == "ttcf" {
+		numFonts := int(binary.BigEndian.Uint32(header[8:]))
+		if index < 0 || index >= numFonts {
+			return nil, fmt.Errorf("face index %d out of range", index)
+		}
+		entry, err := read(12+4*int64(index), 4)
+		if err != nil {
+			return nil, fmt.Errorf("face index %d out of range", index)
+		}
+		offset = int64(binary.BigEndian.Uint32(entry))
+		if header, err = read(offset, 12); err != nil {
+			return nil, err
+		}
+	}
+	numTables := int(binary.BigEndian.Uint16(header[4:]))
+	if n := (size - offset - 12) / 16; int64(numTables) > n {
+		numTables = int(n) // a truncated directory
+	}
+	dir, err := read(offset+12, 16*numTables)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < numTables; i++ {
+		rec := dir[16*i:]
+		if string(rec[:4]) != tag {
+			continue
+		}
+		start := int64(binary.BigEndian.Uint32(rec[8:]))
+		length := int(binary.BigEndian.Uint32(rec[12:]))
+		if start+int64(length) > size {
+			return nil, fmt.Errorf("invalid SFNT table entry %q", tag)
+		}
+		return read(start, length)
+	}
+	return nil, missingTableError(tag)
+}
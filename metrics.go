@@ -0,0 +1,56 @@
+package fontfind
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Metrics returns the vertical metrics of font sfont in pixels, for point-size ptSize
+// and output resolution dpi (see PpEm). Ascent, descent and line-gap are taken from
+// the hhea table, x-height and cap-height from the OS/2 table (or from the outlines
+// of 'x' and 'H' for fonts with an older OS/2 table). Metrics.Height is the distance
+// between two baselines, i.e. ascent plus descent plus line-gap; the line-gap itself
+// is Height - Ascent - Descent. Descent is positive for descenders below the baseline.
+//
+// Values are not hinted, i.e. not rounded to whole pixels.
+func Metrics(sfont *sfnt.Font, ptSize fixed.Int26_6, dpi float32) (font.Metrics, error) {
+	var buf sfnt.Buffer
+	return sfont.Metrics(&buf, PpEm(ptSize, dpi), font.HintingNone)
+}
+
+// Kern returns the kerning adjustment between glyphs a and b of font sfont, in
+// pixels for ppem pixels per em (see PpEm). The adjustment is to be added to the
+// advance of a. Only the legacy 'kern' table is read, as package sfnt does not
+// support GPOS kerning: fonts which are kerned by their GPOS table only, as most
+// current OpenType fonts are, have an adjustment of zero, as have glyph pairs
+// without kerning. Kern fails for glyph indices not contained in the font.
+func Kern(sfont *sfnt.Font, a, b sfnt.GlyphIndex, ppem fixed.Int26_6) (fixed.Int26_6, error) {
+	if n := sfont.NumGlyphs(); int(a) >= n || int(b) >= n {
+		return 0, fmt.Errorf("cannot kern glyphs %d and %d: font has %d glyphs", a, b, n)
+	}
+	var buf sfnt.Buffer
+	kern, err := sfont.Kern(&buf, a, b, ppem, font.HintingNone)
+	if errors.Is(err, sfnt.ErrNotFound) {
+		return 0, nil // no kerning for this pair
+	}
+	return kern, err
+}
+
+// Advance returns the horizontal advance of the glyph for rune r in font sfont, in
+// pixels for point-size ptSize and output resolution dpi (see PpEm). It fails if
+// the font has no glyph for r.
+func Advance(sfont *sfnt.Font, r rune, ptSize fixed.Int26_6, dpi float32) (fixed.Int26_6, error) {
+	var buf sfnt.Buffer
+	glyph, err := sfont.GlyphIndex(&buf, r)
+	if err != nil {
+		return 0, err
+	}
+	if glyph == 0 {
+		return 0, fmt.Errorf("font has no glyph for %q", r)
+	}
+	return sfont.GlyphAdvance(&buf, glyph, PpEm(ptSize, dpi), font.HintingNone)
+}
@@ -0,0 +1,161 @@
+package fontfind
+
+import (
+	"container/list"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// defaultParsedFontCacheSize is the default number of parsed fonts kept in memory.
+const defaultParsedFontCacheSize = 64
+
+// sfntKey identifies a face of a font file. The file-system is part of the key,
+// as paths are relative to it, see fsIdentity.
+type sfntKey struct {
+	fsys  any
+	path  string
+	index int
+}
+
+// fsIdentity returns a comparable identity of fsys for keys of caches. It returns
+// false for file-systems which are not comparable, e.g. fstest.MapFS: the files of
+// a map may be replaced without a change of modification time, so results for them
+// must not be cached.
+func fsIdentity(fsys fs.FS) (any, bool) {
+	if fsys == nil || !reflect.TypeOf(fsys).Comparable() {
+		return nil, false
+	}
+	return fsys, true
+}
+
+type sfntEntry struct {
+	key   sfntKey
+	mtime time.Time
+	font  *sfnt.Font
+}
+
+// sfntCache is a size-bounded LRU cache for parsed fonts, shared by all
+// ScalableFonts of a process.
+type sfntCache struct {
+	sync.Mutex
+	capacity int
+	order    *list.List // of *sfntEntry, most recently used first
+	entries  map[sfntKey]*list.Element
+}
+
+var parsedFonts = newSfntCache(defaultParsedFontCacheSize)
+
+func newSfntCache(capacity int) *sfntCache {
+	return &sfntCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[sfntKey]*list.Element),
+	}
+}
+
+// SetParsedFontCacheSize sets the maximum number of parsed fonts which are kept
+// in memory process-wide. A size of zero or less disables caching, so that every
+// call of ScalableFont.Sfnt reads and parses the font again.
+func SetParsedFontCacheSize(n int) {
+	parsedFonts.Lock()
+	defer parsedFonts.Unlock()
+	parsedFonts.capacity = n
+	parsedFonts.evict()
+}
+
+// get returns a cached font for key, if it has been parsed from a file with
+// modification time mtime. Stale entries are dropped.
+func (c *sfntCache) get(key sfntKey, mtime time.Time) *sfnt.Font {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*sfntEntry)
+	if !entry.mtime.Equal(mtime) {
+		tracer().Debugf("font file %s has changed, dropping parsed font", key.path)
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return entry.font
+}
+
+func (c *sfntCache) put(key sfntKey, mtime time.Time, f *sfnt.Font) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&sfntEntry{key: key, mtime: mtime, font: f})
+	c.evict()
+}
+
+// evict drops least recently used entries until the cache is within capacity.
+// Expects the lock to be held.
+func (c *sfntCache) evict() {
+	for c.order.Len() > 0 && c.order.Len() > c.capacity {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*sfntEntry).key)
+	}
+}
+
+// Sfnt parses the font data of f and returns it as an sfnt.Font. For font
+// collections (*.ttc), face number f.FaceIndex is returned.
+//
+// Parsed fonts are kept in a process-wide cache, keyed by file-system, path and
+// modification time of the font file, so that repeated calls are cheap and
+// different ScalableFonts for the same font file share a single parsed font.
+// Fonts in file-systems which are not comparable, e.g. fstest.MapFS, are not
+// cached and are parsed on every call, as is every font if the cache is disabled
+// (see SetParsedFontCacheSize). The parsed font is not remembered by f itself, so Sfnt is safe to call
+// concurrently, for copies of f as well as for f. The sfnt.Font returned may be
+// used concurrently, as long as every goroutine uses a sfnt.Buffer of its own.
+func (f *ScalableFont) Sfnt() (*sfnt.Font, error) {
+	return loadSfnt(f, f.FaceIndex)
+}
+
+// loadSfnt returns face number index of the font (collection) f, consulting the
+// cache of parsed fonts first.
+func loadSfnt(f *ScalableFont, index int) (*sfnt.Font, error) {
+	var key sfntKey
+	var mtime time.Time
+	fsys, cacheable := fsIdentity(f.fileSystem)
+	if cacheable {
+		key = sfntKey{fsys: fsys, path: f.path, index: index}
+		if fi, err := fs.Stat(f.fileSystem, f.path); err == nil {
+			mtime = fi.ModTime()
+		}
+		if sf := parsedFonts.get(key, mtime); sf != nil {
+			return sf, nil
+		}
+	}
+	data, err := f.ReadFontData()
+	if err != nil {
+		return nil, err
+	}
+	coll, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse font %s: %w", f.Name, err)
+	}
+	if index < 0 || index >= coll.NumFonts() {
+		return nil, fmt.Errorf("face index %d out of range: %s contains %d faces",
+			index, f.Name, coll.NumFonts())
+	}
+	sf, err := coll.Font(index)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse face %d of %s: %w", index, f.Name, err)
+	}
+	if cacheable {
+		parsedFonts.put(key, mtime, sf)
+	}
+	return sf, nil
+}
@@ -0,0 +1,44 @@
+package fontfind
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Typecase is a scaled font, i.e. a scalable font at a certain point-size and
+// output resolution. It holds the parsed font together with metrics computed
+// for this size, so that they need not be re-calculated for every use.
+type Typecase struct {
+	Font    ScalableFont
+	Sfnt    *sfnt.Font
+	PtSize  fixed.Int26_6 // point-size
+	DPI     float32       // output resolution
+	PpEm    fixed.Int26_6 // pixels per em, see PpEm
+	Metrics font.Metrics  // vertical metrics in pixels
+}
+
+// NewTypecase scales a scalable font to a point-size ptSize for an output resolution dpi.
+func NewTypecase(f ScalableFont, ptSize fixed.Int26_6, dpi float32) (*Typecase, error) {
+	sf, err := f.Sfnt()
+	if err != nil {
+		return nil, err
+	}
+	tc := &Typecase{
+		Font:   f,
+		Sfnt:   sf,
+		PtSize: ptSize,
+		DPI:    dpi,
+		PpEm:   PpEm(ptSize, dpi),
+	}
+	if tc.Metrics, err = Metrics(sf, ptSize, dpi); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// RasterCoords transforms u, a value in font-units, into pixel coordinates for
+// the typecase's size and resolution.
+func (tc *Typecase) RasterCoords(u sfnt.Units) fixed.Int26_6 {
+	return RasterCoords(u, tc.Sfnt, tc.PtSize, tc.DPI)
+}
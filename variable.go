@@ -0,0 +1,151 @@
+package fontfind
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+)
+
+// AxisValue is a coordinate on a design axis of a variable font, e.g. "wght" = 700.
+type AxisValue struct {
+	Tag   string  // axis tag, e.g. "wght", "wdth", "ital", "slnt"
+	Value float64 // in user coordinates of the axis
+}
+
+// NamedInstance is a named instance of a variable font, as listed in its fvar table.
+type NamedInstance struct {
+	Name   string      // subfamily name of the instance, e.g. "Bold"
+	Coords []AxisValue // coordinates for all axes of the font, in fvar order
+}
+
+// Coord returns the coordinate of the instance on axis tag.
+func (inst NamedInstance) Coord(tag string) (float64, bool) {
+	for _, c := range inst.Coords {
+		if c.Tag == tag {
+			return c.Value, true
+		}
+	}
+	return 0, false
+}
+
+// NamedInstances returns the named instances of a variable font f. For fonts
+// without an fvar table, nil is returned. Only the table directory of f is read
+// for telling if it is a variable font.
+func NamedInstances(f ScalableFont) ([]NamedInstance, error) {
+	fvar, err := readTable(f, "fvar")
+	if isMissingTable(err) {
+		return nil, nil // not a variable font
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read named instances of %s: %w", f.Name, err)
+	}
+	tags, instances, err := parseFvar(fvar)
+	if err != nil || len(instances) == 0 {
+		return nil, err
+	}
+	sf, err := f.Sfnt()
+	if err != nil {
+		return nil, err
+	}
+	var buf sfnt.Buffer
+	named := make([]NamedInstance, len(instances))
+	for i, inst := range instances {
+		named[i].Name, _ = sf.Name(&buf, sfnt.NameID(inst.nameID))
+		named[i].Coords = make([]AxisValue, len(tags))
+		for j, tag := range tags {
+			named[i].Coords[j] = AxisValue{Tag: tag, Value: inst.coords[j]}
+		}
+	}
+	return named, nil
+}
+
+// SelectInstance selects the named instance of a variable font f which is closest
+// to style and weight, and records it as f.Instance. Clients rendering f are expected
+// to instantiate the font at the coordinates of f.Instance; package opentype does not
+// apply font variations by itself.
+//
+// SelectInstance returns false if f is not a variable font.
+func (f *ScalableFont) SelectInstance(style font.Style, weight font.Weight) (bool, error) {
+	instances, err := NamedInstances(*f)
+	if err != nil || len(instances) == 0 {
+		return false, err
+	}
+	best, bestDist := 0, math.Inf(1)
+	for i, inst := range instances {
+		if d := instanceDistance(inst, style, weight); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	f.Instance = &instances[best]
+	tracer().Debugf("selected instance %q of variable font %s", f.Instance.Name, f.Name)
+	return true, nil
+}
+
+// instanceDistance measures how far a named instance is from a requested style and
+// weight. A mismatch of the style outweighs any difference in weight.
+func instanceDistance(inst NamedInstance, style font.Style, weight font.Weight) float64 {
+	w, ok := inst.Coord("wght")
+	if !ok {
+		w = 400
+	}
+	dist := math.Abs(w - float64((int(weight)+4)*100))
+	if isSlanted(inst) != (style != font.StyleNormal) {
+		dist += 1000
+	}
+	return dist
+}
+
+// isSlanted returns true if a named instance is italic or oblique.
+func isSlanted(inst NamedInstance) bool {
+	if ital, ok := inst.Coord("ital"); ok {
+		return ital >= 0.5
+	}
+	if slnt, ok := inst.Coord("slnt"); ok {
+		return slnt != 0
+	}
+	name := strings.ToLower(inst.Name)
+	return strings.Contains(name, "italic") || strings.Contains(name, "oblique")
+}
+
+// fvarInstance is an instance record of an fvar table.
+type fvarInstance struct {
+	nameID uint16
+	coords []float64
+}
+
+// parseFvar reads the axis tags and instance records of an fvar table.
+func parseFvar(fvar []byte) ([]string, []fvarInstance, error) {
+	if len(fvar) < 16 {
+		return nil, nil, errors.New("invalid fvar table")
+	}
+	axesOffset := int(binary.BigEndian.Uint16(fvar[4:]))
+	axisCount := int(binary.BigEndian.Uint16(fvar[8:]))
+	axisSize := int(binary.BigEndian.Uint16(fvar[10:]))
+	instanceCount := int(binary.BigEndian.Uint16(fvar[12:]))
+	instanceSize := int(binary.BigEndian.Uint16(fvar[14:]))
+	if axisSize < 20 || instanceSize < 4+4*axisCount ||
+		axesOffset+axisCount*axisSize+instanceCount*instanceSize > len(fvar) {
+		return nil, nil, errors.New("invalid fvar table")
+	}
+	tags := make([]string, axisCount)
+	for i := range tags {
+		rec := fvar[axesOffset+i*axisSize:]
+		tags[i] = string(rec[:4])
+	}
+	instances := make([]fvarInstance, instanceCount)
+	start := axesOffset + axisCount*axisSize
+	for i := range instances {
+		rec := fvar[start+i*instanceSize:]
+		instances[i].nameID = binary.BigEndian.Uint16(rec)
+		instances[i].coords = make([]float64, axisCount)
+		for j := range instances[i].coords {
+			fixed := int32(binary.BigEndian.Uint32(rec[4+4*j:])) // 16.16
+			instances[i].coords[j] = float64(fixed) / 65536
+		}
+	}
+	return tags, instances, nil
+}